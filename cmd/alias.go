@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var aliasCmd = &cobra.Command{
+	Use:   "alias",
+	Short: "Manage model aliases",
+	Long:  `Add, remove, and list the short names in models.aliases that can be passed to --model.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+	},
+}
+
+var aliasListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List model aliases and the models they point to",
+	Args:  cobra.NoArgs,
+	RunE:  runAliasListCommand,
+}
+
+var aliasAddCmd = &cobra.Command{
+	Use:               "add <name> <model>",
+	Short:             "Add or update a model alias",
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeAliasAddArgs,
+	RunE:              runAliasAddCommand,
+}
+
+var aliasRmCmd = &cobra.Command{
+	Use:               "rm <name>",
+	Short:             "Remove a model alias",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeAliasNames,
+	RunE:              runAliasRmCommand,
+}
+
+func runAliasListCommand(cmd *cobra.Command, args []string) error {
+	aliases := viper.GetStringMapString("models.aliases")
+	if len(aliases) == 0 {
+		fmt.Println("No aliases defined. Add one with 'llm alias add <name> <model>'.")
+		return nil
+	}
+
+	// Without a cache there's nothing to check against, and listing aliases shouldn't need the network
+	knownModels := map[string]bool{}
+	if cache, err := readModelsCache(); err == nil {
+		for _, model := range cache.Models {
+			knownModels[model.ID] = true
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ALIAS\tMODEL\t")
+	for _, name := range sortedAliasNames(aliases) {
+		note := ""
+		if len(knownModels) > 0 && !knownModels[aliases[name]] {
+			note = "(not in models list)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, aliases[name], note)
+	}
+
+	return tw.Flush()
+}
+
+func runAliasAddCommand(cmd *cobra.Command, args []string) error {
+	name, model := args[0], args[1]
+
+	aliases, err := loadConfigAliases()
+	if err != nil {
+		return err
+	}
+
+	aliases[name] = model
+	if err := saveConfigValue("models.aliases", aliases); err != nil {
+		return err
+	}
+
+	fmt.Printf("Alias %s now points to %s\n", name, model)
+	return nil
+}
+
+func runAliasRmCommand(cmd *cobra.Command, args []string) error {
+	name := args[0]
+
+	aliases, err := loadConfigAliases()
+	if err != nil {
+		return err
+	}
+
+	if _, found := aliases[name]; !found {
+		return fmt.Errorf("alias %q not found", name)
+	}
+
+	delete(aliases, name)
+	if err := saveConfigValue("models.aliases", aliases); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed alias %s\n", name)
+	return nil
+}
+
+// loadConfigAliases returns the aliases as stored in the config file. A file that doesn't define
+// any yet starts from the built-in ones so adding the first alias doesn't make them disappear
+func loadConfigAliases() (map[string]string, error) {
+	fileConfig, err := loadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := map[string]string{}
+	source := defaultModelAliases
+	if fileConfig.IsSet("models.aliases") {
+		source = fileConfig.GetStringMapString("models.aliases")
+	}
+
+	for name, model := range source {
+		aliases[name] = model
+	}
+
+	return aliases, nil
+}
+
+func sortedAliasNames(aliases map[string]string) []string {
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func completeAliasNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	return sortedAliasNames(viper.GetStringMapString("models.aliases")), cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeAliasAddArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 1 {
+		return completeModelNames(cmd, args, toComplete)
+	}
+
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	rootCmd.AddCommand(aliasCmd)
+
+	aliasCmd.AddCommand(aliasListCmd)
+	aliasCmd.AddCommand(aliasAddCmd)
+	aliasCmd.AddCommand(aliasRmCmd)
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/internal/utils"
+	"github.com/spf13/viper"
+)
+
+const (
+	apiKeyFilePrefix    = "file:"
+	apiKeyCommandPrefix = "cmd:"
+)
+
+// resolveAPIKey returns the configured API key. An api_key of file:path reads the key from that
+// file, and cmd:command runs the command (e.g. pass or the 1Password CLI) and uses its output.
+// The resolved key is only kept in memory, never written back to the config
+func resolveAPIKey() (string, error) {
+	apiKey := viper.GetString("api_key")
+
+	var resolved string
+	switch {
+	case strings.HasPrefix(apiKey, apiKeyFilePrefix):
+		path := utils.ExpandPath(strings.TrimSpace(strings.TrimPrefix(apiKey, apiKeyFilePrefix)))
+		keyBytes, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("error reading API key file %q: %w", path, err)
+		}
+		resolved = strings.TrimSpace(string(keyBytes))
+		if resolved == "" {
+			return "", fmt.Errorf("API key file %q is empty", path)
+		}
+	case strings.HasPrefix(apiKey, apiKeyCommandPrefix):
+		command := strings.TrimSpace(strings.TrimPrefix(apiKey, apiKeyCommandPrefix))
+		if command == "" {
+			return "", errors.New("api_key has an empty cmd: command")
+		}
+
+		output, err := runAPIKeyCommand(command)
+		if err != nil {
+			return "", fmt.Errorf("error running API key command %q: %w", command, err)
+		}
+		resolved = strings.TrimSpace(output)
+		if resolved == "" {
+			return "", fmt.Errorf("API key command %q printed nothing", command)
+		}
+	default:
+		return apiKey, nil
+	}
+
+	log.RegisterSecret(resolved)
+	return resolved, nil
+}
+
+// runAPIKeyCommand runs command through the shell. Stdin isn't passed on since it may hold the
+// prompt, but stderr is so password prompts and errors from the secret manager stay visible
+func runAPIKeyCommand(command string) (string, error) {
+	keyCmd := shellCommand(context.Background(), command)
+
+	var stdout bytes.Buffer
+	keyCmd.Stdout = &stdout
+	keyCmd.Stderr = os.Stderr
+
+	if err := keyCmd.Run(); err != nil {
+		return "", err
+	}
+
+	return stdout.String(), nil
+}
+
+// isAPIKeyReference reports whether value points at the key instead of being the key
+func isAPIKeyReference(value string) bool {
+	return strings.HasPrefix(value, apiKeyFilePrefix) || strings.HasPrefix(value, apiKeyCommandPrefix)
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/flacial/llm/internal/utils"
+	"github.com/flacial/llm/pkg/llm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+var errAPIKeyRejected = errors.New("the API key was rejected")
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Set up and check your API key",
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+	},
+}
+
+var authLoginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Save an API key to the config after checking it works",
+	Long: `Prompts for an API key (input is hidden), checks it against the API, and saves it to the
+config file, under the active profile when --profile is given. The key can also be piped in:
+
+  echo "$OPENROUTER_API_KEY" | llm auth login`,
+	Args: cobra.NoArgs,
+	RunE: runAuthLogin,
+}
+
+var authStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether an API key is configured and still valid",
+	Args:  cobra.NoArgs,
+	RunE:  runAuthStatus,
+}
+
+func runAuthLogin(cmd *cobra.Command, args []string) error {
+	fmt.Fprint(cmd.ErrOrStderr(), "Paste your API key (get one at https://openrouter.ai/settings/keys): ")
+	apiKey, err := readSecretLine()
+	fmt.Fprintln(cmd.ErrOrStderr())
+	if err != nil {
+		return fmt.Errorf("error reading API key: %w", err)
+	}
+
+	if apiKey == "" {
+		return errors.New("no API key entered")
+	}
+
+	if err := verifyAPIKey(apiKey); err != nil {
+		return err
+	}
+
+	configKey := "api_key"
+	if profile := viper.GetString("profile"); profile != "" {
+		configKey = "profiles." + profile + ".api_key"
+	}
+
+	if err := saveConfigValue(configKey, apiKey); err != nil {
+		return err
+	}
+
+	fmt.Printf("Logged in. Saved the API key (%s) as %s in %s\n", utils.MaskSecret(apiKey), configKey, viper.ConfigFileUsed())
+	return nil
+}
+
+func runAuthStatus(cmd *cobra.Command, args []string) error {
+	configured := viper.GetString("api_key")
+	if configured == "" {
+		fmt.Println("No API key configured. Run 'llm auth login' to set one.")
+		return errors.New("api key not set")
+	}
+
+	if isAPIKeyReference(configured) {
+		fmt.Printf("API key: from %s\n", configured)
+	}
+
+	apiKey, err := resolveAPIKey()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("API key: %s\n", utils.MaskSecret(apiKey))
+
+	if err := verifyAPIKey(apiKey); err != nil {
+		fmt.Println("Status: invalid")
+		return err
+	}
+	fmt.Println("Status: valid")
+
+	// Credits are OpenRouter specific, and a key limited to inference may not be allowed to see them
+	if getBaseURL() == llm.OpenRouterBaseURL {
+		if balance, err := fetchCredits(apiKey); err == nil {
+			fmt.Printf("Credits: $%.2f remaining\n", balance.Remaining())
+		}
+	}
+
+	return nil
+}
+
+// readSecretLine reads the key without echoing it when stdin is a terminal, or the first line of
+// whatever was piped in otherwise
+func readSecretLine() (string, error) {
+	if file, ok := promptStdin.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+		secret, err := term.ReadPassword(int(file.Fd()))
+		return strings.TrimSpace(string(secret)), err
+	}
+
+	line, err := bufio.NewReader(promptStdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+// verifyAPIKey makes a cheap authenticated request. OpenRouter's models list is public, so its
+// /key endpoint is used there, while other providers get the models list since that needs a key
+func verifyAPIKey(apiKey string) error {
+	path := "/models"
+	if getBaseURL() == llm.OpenRouterBaseURL {
+		path = "/key"
+	}
+
+	resp, err := authenticatedGet(apiKey, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w (status %d), check that it was copied in full", errAPIKeyRejected, resp.StatusCode)
+	default:
+		return fmt.Errorf("couldn't verify the API key: %s returned status %d", path, resp.StatusCode)
+	}
+}
+
+func authenticatedGet(apiKey, path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", getBaseURL()+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := newHTTPClient(false).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", getBaseURL(), err)
+	}
+
+	return resp, nil
+}
+
+func init() {
+	rootCmd.AddCommand(authCmd)
+
+	authCmd.AddCommand(authLoginCmd)
+	authCmd.AddCommand(authStatusCmd)
+}
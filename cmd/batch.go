@@ -0,0 +1,416 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/internal/utils"
+	"github.com/flacial/llm/pkg/llm"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// How many times a rate limited or overloaded prompt is retried
+const batchMaxRetries = 3
+
+// The wait before the first retry, doubled on each one after
+var batchRetryBackoff = 2 * time.Second
+
+// batchResult is one prompt's outcome, as written to the combined JSON
+type batchResult struct {
+	Index    int    `json:"index"`
+	Prompt   string `json:"prompt"`
+	Response string `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// runBatch answers every prompt in the --batch file, --concurrency at a time. The answers go to
+// numbered files in --batch-output, or to stdout as one JSON array
+// checkBatchFlags rejects the flags batch jobs don't apply, rather than sending every job without
+// what they asked for
+func checkBatchFlags() error {
+	unsupported := map[string]bool{
+		"--attach":  len(attachFlag) > 0,
+		"--image":   len(imageFlag) > 0,
+		"--prefill": prefillFlag != "",
+		"--web":     webFlag,
+		"--tools":   toolsFlag != "",
+		"--choices": choicesFlag > 1,
+	}
+
+	var names []string
+	for name, set := range unsupported {
+		if set {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		slices.Sort(names)
+		return &UsageError{fmt.Errorf("--batch can't be combined with %s", strings.Join(names, ", "))}
+	}
+
+	return nil
+}
+
+func runBatch(ctx context.Context, cmd *cobra.Command) error {
+	if templateFlag != "" {
+		return errors.New("--template isn't supported with --batch yet")
+	}
+
+	if err := checkBatchFlags(); err != nil {
+		return err
+	}
+
+	if batchConcurrencyFlag < 1 {
+		return fmt.Errorf("invalid concurrency %d: must be at least 1", batchConcurrencyFlag)
+	}
+
+	prompts, err := readBatchPrompts(batchFlag, batchDelimiterFlag)
+	if err != nil {
+		return err
+	}
+
+	baseRequest, err := batchBaseRequest(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Like a single dry run, nothing is sent and no key is needed. Each request is printed in turn
+	if dryRunFlag {
+		for _, prompt := range prompts {
+			request, err := batchRequest(ctx, baseRequest, prompt)
+			if err != nil {
+				return err
+			}
+			if err := printRequestBody(os.Stdout, request); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	apiKey, err := resolveAPIKey()
+	if err != nil {
+		return err
+	}
+	if apiKey == "" && !mockMode() {
+		return errors.New("api key not set")
+	}
+
+	if batchOutputFlag != "" {
+		if err := os.MkdirAll(utils.ExpandPath(batchOutputFlag), 0755); err != nil {
+			return fmt.Errorf("failed to create batch output directory %q: %w", batchOutputFlag, err)
+		}
+	}
+
+	llmClient := newLLMClient(apiKey, false)
+	progress := newBatchProgress(os.Stderr, len(prompts))
+
+	results := make([]batchResult, len(prompts))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(batchConcurrencyFlag, len(prompts)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = answerBatchPrompt(ctx, llmClient, baseRequest, i, prompts[i])
+				progress.done(results[i].Error != "")
+			}
+		}()
+	}
+
+	for i := range prompts {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	progress.finish()
+
+	// Prompts never sent because of Ctrl-C still get an entry so the output lines up with the input
+	for i := range results {
+		if results[i].Index == 0 {
+			results[i] = batchResult{Index: i + 1, Prompt: prompts[i], Error: context.Canceled.Error()}
+		}
+	}
+
+	if err := writeBatchResults(results); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Answered %d of %d prompts", len(results)-failed, len(results))
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, ", %d failed", failed)
+	}
+	fmt.Fprintln(os.Stderr)
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d prompts failed", failed, len(results))
+	}
+
+	return nil
+}
+
+// readBatchPrompts splits the file into prompts, one per line, or separated by lines equal to
+// delimiter when one is given. Blank prompts are skipped
+func readBatchPrompts(path, delimiter string) ([]string, error) {
+	content, err := readTextFile(utils.ExpandPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading batch file %q: %w", path, err)
+	}
+
+	var chunks []string
+	if delimiter == "" {
+		chunks = strings.Split(content, "\n")
+	} else {
+		var current []string
+		for _, line := range strings.Split(content, "\n") {
+			if strings.TrimSpace(line) == delimiter {
+				chunks = append(chunks, strings.Join(current, "\n"))
+				current = nil
+				continue
+			}
+			current = append(current, line)
+		}
+		chunks = append(chunks, strings.Join(current, "\n"))
+	}
+
+	var prompts []string
+	for _, chunk := range chunks {
+		if prompt := strings.TrimSpace(chunk); prompt != "" {
+			prompts = append(prompts, prompt)
+		}
+	}
+
+	if len(prompts) == 0 {
+		return nil, fmt.Errorf("batch file %q has no prompts", path)
+	}
+
+	return prompts, nil
+}
+
+// batchBaseRequest holds everything the batch prompts share: the model, system prompt, and
+// sampling parameters
+func batchBaseRequest(cmd *cobra.Command) (llm.ChatCompletionRequest, error) {
+	model := resolveModel(viper.GetString("model"))
+	if err := validateModel(model); err != nil {
+		return llm.ChatCompletionRequest{}, err
+	}
+
+	request := llm.ChatCompletionRequest{
+		Model:            model,
+		Temperature:      optionalFloat(cmd, "temperature"),
+		TopP:             optionalFloat(cmd, "top_p"),
+		FrequencyPenalty: optionalFloat(cmd, "frequency_penalty"),
+		PresencePenalty:  optionalFloat(cmd, "presence_penalty"),
+		Stop:             viper.GetStringSlice("stop"),
+		Provider:         providerPreferences(cmd, nil),
+	}
+
+	if maxTokens := optionalInt(cmd, "max_tokens"); maxTokens != nil {
+		if *maxTokens <= 0 {
+			return llm.ChatCompletionRequest{}, fmt.Errorf("invalid max tokens %d: must be a positive integer", *maxTokens)
+		}
+		request.MaxTokens = maxTokens
+	}
+
+	defaults, err := loadModelDefaults(model)
+	if err != nil {
+		return llm.ChatCompletionRequest{}, err
+	}
+	if defaults.Temperature != nil && !cmd.Flags().Changed("temperature") {
+		request.Temperature = defaults.Temperature
+	}
+	if defaults.MaxTokens != nil && !cmd.Flags().Changed("max-tokens") {
+		request.MaxTokens = defaults.MaxTokens
+	}
+	if defaults.TopP != nil && !cmd.Flags().Changed("top-p") {
+		request.TopP = defaults.TopP
+	}
+
+	if cmd.Flags().Changed("stop") {
+		request.Stop = stopFlag
+	}
+
+	request.Seed = optionalInt(cmd, "seed")
+
+	responseFormat, err := getResponseFormat(jsonFlag, jsonSchemaFlag)
+	if err != nil {
+		return llm.ChatCompletionRequest{}, err
+	}
+	request.ResponseFormat = responseFormat
+
+	if err := checkSystemFlags(); err != nil {
+		return llm.ChatCompletionRequest{}, err
+	}
+
+	if !noSystemFlag {
+		systemMessage, err := defaultSystemPrompt()
+		if systemFlag != "" {
+			systemMessage, err = getSystemPromptContent(systemFlag)
+		}
+		if err != nil {
+			return llm.ChatCompletionRequest{}, err
+		}
+		if systemMessage != "" {
+			request.Messages = []llm.ChatCompletionMessage{{Role: "system", Content: systemMessage}}
+		}
+	}
+
+	if err := runPreRequestHook(cmd.Context(), request.Messages); err != nil {
+		return llm.ChatCompletionRequest{}, err
+	}
+
+	return request, request.Validate()
+}
+
+// batchRequest adds prompt to the shared request, wrapped in --prefix and --suffix and passed
+// through the pre-request hook
+func batchRequest(ctx context.Context, baseRequest llm.ChatCompletionRequest, prompt string) (llm.ChatCompletionRequest, error) {
+	userMessages := []llm.ChatCompletionMessage{{
+		Role:    "user",
+		Content: joinPromptParts(prefixFlag, prompt, suffixFlag),
+	}}
+	if err := runPreRequestHook(ctx, userMessages); err != nil {
+		return llm.ChatCompletionRequest{}, err
+	}
+
+	request := baseRequest
+	request.Messages = append(append([]llm.ChatCompletionMessage{}, baseRequest.Messages...), userMessages...)
+
+	return request, nil
+}
+
+// answerBatchPrompt sends one prompt, retrying with backoff when the API is rate limiting or
+// overloaded, since a burst of parallel requests is exactly what triggers that
+func answerBatchPrompt(ctx context.Context, llmClient *llm.LLMClient, baseRequest llm.ChatCompletionRequest, index int, prompt string) batchResult {
+	result := batchResult{Index: index + 1, Prompt: prompt}
+
+	request, err := batchRequest(ctx, baseRequest, prompt)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	backoff := batchRetryBackoff
+	for attempt := 0; ; attempt++ {
+		completion, err := llmClient.GetChatCompletion(ctx, request)
+		if err == nil {
+			if len(completion.Choices) == 0 {
+				result.Error = "no completion choices received"
+			} else {
+				result.Response = runPostResponseHook(ctx, completion.Choices[0].Message.Content)
+			}
+			return result
+		}
+
+		if attempt == batchMaxRetries || !isRetryableError(err) {
+			result.Error = explainAPIError(err).Error()
+			return result
+		}
+
+		log.Logger.Debug().Err(err).Int("prompt", result.Index).Dur("backoff", backoff).Msg("Retrying batch prompt.")
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			result.Error = ctx.Err().Error()
+			return result
+		}
+	}
+}
+
+func isRetryableError(err error) bool {
+	var apiErr *llm.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= http.StatusInternalServerError
+}
+
+// writeBatchResults saves each answer as a numbered markdown file in --batch-output, or prints
+// them all as a JSON array
+func writeBatchResults(results []batchResult) error {
+	if batchOutputFlag == "" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	}
+
+	// Zero padded so the files sort in prompt order
+	width := len(fmt.Sprint(len(results)))
+	for _, result := range results {
+		if result.Error != "" {
+			continue
+		}
+
+		path := filepath.Join(utils.ExpandPath(batchOutputFlag), fmt.Sprintf("%0*d.md", width, result.Index))
+		if err := os.WriteFile(path, []byte(result.Response+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write batch result %q: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// batchProgress keeps a running count on a single terminal line. Piped stderr only gets the
+// final summary
+type batchProgress struct {
+	mu       sync.Mutex
+	out      io.Writer
+	total    int
+	finished int
+	failed   int
+	enabled  bool
+}
+
+func newBatchProgress(out *os.File, total int) *batchProgress {
+	return &batchProgress{out: out, total: total, enabled: isatty.IsTerminal(out.Fd()) && !viper.GetBool("quiet")}
+}
+
+func (p *batchProgress) done(failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.finished++
+	if failed {
+		p.failed++
+	}
+
+	if p.enabled {
+		fmt.Fprintf(p.out, "\r%d/%d done, %d failed", p.finished, p.total, p.failed)
+	}
+}
+
+func (p *batchProgress) finish() {
+	if p.enabled {
+		fmt.Fprint(p.out, "\r\x1b[K")
+	}
+}
@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/flacial/llm/internal/history"
+	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/pkg/llm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var chatModelFlag string
+
+var chatCmd = &cobra.Command{
+	Use:   "chat",
+	Short: "Start an interactive chat session",
+	Long: `Opens an interactive session that keeps the whole conversation as context.
+
+Commands available inside the session:
+  /reset          Forget the conversation so far
+  /model <name>   Switch to another model or alias
+  /save <file>    Save the conversation as JSON
+  /exit           Leave the session (Ctrl-D works too)
+
+Ctrl-C cancels the answer being streamed without leaving the session.`,
+	RunE: runChatCommand,
+}
+
+func runChatCommand(cmd *cobra.Command, args []string) error {
+	apiKey, err := resolveAPIKey()
+	if err != nil {
+		return err
+	}
+	if apiKey == "" {
+		return errors.New("api key not set. Please provide it via --api-key, environment variable (LLM_API_KEY or OPENROUTER_API_KEY), or in your config file")
+	}
+
+	if timeout := viper.GetDuration("timeout"); timeout < 0 {
+		return fmt.Errorf("invalid timeout %s: must not be negative", timeout)
+	}
+
+	requestedModel := viper.GetString("model")
+	if chatModelFlag != "" {
+		requestedModel = chatModelFlag
+	}
+
+	model := resolveModel(requestedModel)
+	if err := validateModel(model); err != nil {
+		return err
+	}
+
+	return runChatSession(cmd, apiKey, model, history.NewConversation())
+}
+
+// runChatSession drives the read-send-print loop until the user exits or stdin closes. The
+// conversation is saved to history after every answer so the session can be resumed later
+func runChatSession(cmd *cobra.Command, apiKey, model string, conversation *history.Conversation) error {
+	llmClient := newLLMClient(apiKey, true)
+
+	historyDirPath, err := getHistoryDirPath()
+	if err != nil {
+		return err
+	}
+
+	// The default system prompt opens the session unless a resumed conversation already has one.
+	// /reset starts over with it too
+	hasSystemPrompt := slices.ContainsFunc(conversation.Messages, func(message llm.ChatCompletionMessage) bool {
+		return message.Role == "system"
+	})
+	systemPrompt, err := resolveSystemPrompt("", hasSystemPrompt)
+	if err != nil {
+		return err
+	}
+
+	var systemMessages []llm.ChatCompletionMessage
+	if systemPrompt != "" {
+		systemMessages = []llm.ChatCompletionMessage{{Role: "system", Content: systemPrompt}}
+		if err := runPreRequestHook(cmd.Context(), systemMessages); err != nil {
+			log.Logger.Error().Err(err).Msg("Pre-request hook failed")
+			return err
+		}
+		conversation.Messages = slices.Concat(systemMessages, conversation.Messages)
+	}
+
+	// Only cancel the in-flight answer on Ctrl-C, the session itself keeps going
+	var mu sync.Mutex
+	var cancelInFlight context.CancelFunc
+
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signalChannel)
+
+	go func() {
+		for range signalChannel {
+			mu.Lock()
+			cancel := cancelInFlight
+			mu.Unlock()
+
+			if cancel != nil {
+				log.Logger.Info().Msg("Stream interrupted. Cancelling...")
+				cancel()
+				continue
+			}
+
+			fmt.Fprintln(cmd.ErrOrStderr(), "\n(Use /exit or Ctrl-D to leave)")
+		}
+	}()
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Chatting with %s. Type /exit to leave.\n", model)
+
+	scanner := bufio.NewScanner(cmd.InOrStdin())
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			break
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "/") {
+			command, argument, _ := strings.Cut(line, " ")
+			argument = strings.TrimSpace(argument)
+
+			switch command {
+			case "/exit", "/quit":
+				return nil
+			case "/reset":
+				conversation = history.NewConversation()
+				conversation.Messages = slices.Clone(systemMessages)
+				fmt.Fprintln(out, "Conversation reset.")
+			case "/model":
+				if argument == "" {
+					fmt.Fprintf(out, "Current model: %s\n", model)
+					continue
+				}
+				switchedModel := resolveModel(argument)
+				if err := validateModel(switchedModel); err != nil {
+					fmt.Fprintln(out, err)
+					continue
+				}
+				model = switchedModel
+				fmt.Fprintf(out, "Switched to %s.\n", model)
+			case "/save":
+				if argument == "" {
+					fmt.Fprintln(out, "Usage: /save <file>")
+					continue
+				}
+				if err := saveConversationFile(argument, conversation); err != nil {
+					log.Logger.Error().Err(err).Str("path", argument).Msg("Failed to save conversation")
+					continue
+				}
+				fmt.Fprintf(out, "Saved conversation to %s.\n", argument)
+			default:
+				fmt.Fprintf(out, "Unknown command %q. Available: /reset, /model <name>, /save <file>, /exit\n", command)
+			}
+
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(cmd.Context())
+		mu.Lock()
+		cancelInFlight = cancel
+		mu.Unlock()
+
+		userMessage := []llm.ChatCompletionMessage{{Role: "user", Content: line}}
+		if err := runPreRequestHook(ctx, userMessage); err != nil {
+			mu.Lock()
+			cancelInFlight = nil
+			mu.Unlock()
+			cancel()
+			log.Logger.Error().Err(err).Msg("Pre-request hook failed")
+			continue
+		}
+		messages := append(conversation.Messages, userMessage...)
+
+		streamedCompletion, err := getChatAnswer(ctx, llmClient, llm.ChatCompletionRequest{
+			Model:    model,
+			Messages: messages,
+		}, out)
+
+		mu.Lock()
+		cancelInFlight = nil
+		mu.Unlock()
+		cancel()
+
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				// A stream that got going already ended its line
+				if streamedCompletion == nil || streamedCompletion.Content == "" {
+					fmt.Fprintln(out)
+				}
+				continue
+			}
+
+			log.Logger.Error().Err(explainAPIError(err)).Msg("Error getting streaming chat completion")
+			continue
+		}
+
+		printFinishNotice(cmd.ErrOrStderr(), streamedCompletion.FinishReason)
+		printCitations(out, streamedCompletion.Annotations)
+
+		conversation.Model = model
+		conversation.Messages = append(messages, llm.ChatCompletionMessage{
+			Role:    "assistant",
+			Content: streamedCompletion.Content,
+		})
+
+		if err := history.Save(historyDirPath, conversation); err != nil {
+			log.Logger.Warn().Err(err).Msg("Failed to save conversation history")
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading input: %w", err)
+	}
+
+	return nil
+}
+
+// getChatAnswer streams the answer to out, unless hooks.post_response is set. The hook needs the
+// whole answer, so then it's fetched in one go and what the hook printed is shown instead
+func getChatAnswer(ctx context.Context, llmClient *llm.LLMClient, request llm.ChatCompletionRequest, out io.Writer) (*llm.StreamingChatCompletion, error) {
+	if viper.GetString(hookPostResponse) == "" {
+		request.Stream = true
+		return llmClient.GetStreamingChatCompletion(ctx, request, out)
+	}
+
+	completion, err := llmClient.GetChatCompletion(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if len(completion.Choices) == 0 {
+		return nil, fmt.Errorf("%w: the response had no choices", ErrNoContent)
+	}
+
+	choice := completion.Choices[0]
+	content := runPostResponseHook(ctx, choice.Message.Content)
+	fmt.Fprintln(out, content)
+
+	return &llm.StreamingChatCompletion{
+		Content:      content,
+		FinishReason: choice.FinishReason,
+		Annotations:  choice.Message.Annotations,
+	}, nil
+}
+
+func saveConversationFile(path string, conversation *history.Conversation) error {
+	data, err := json.MarshalIndent(conversation, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding conversation: %w", err)
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+func init() {
+	rootCmd.AddCommand(chatCmd)
+
+	chatCmd.Flags().StringVarP(&chatModelFlag, "model", "m", "", "Specify the LLM model to chat with (defaults to the configured model)")
+	chatCmd.RegisterFlagCompletionFunc("model", completeModelNames)
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/flacial/llm/pkg/llm"
+)
+
+// printChoices prints every choice of an -n request under its own header and copies the one
+// picked with --copy-choice. That choice is returned, prefill included, to be saved to history
+func printChoices(choices []llm.ChatCompletionResponseChoices, prefill string, outputFile *os.File, expectJSON bool) (string, error) {
+	if copyChoiceFlag > len(choices) {
+		return "", fmt.Errorf("invalid copy choice %d: only %d choices came back", copyChoiceFlag, len(choices))
+	}
+
+	for i, choice := range choices {
+		content := choice.Message.Content
+		if echoPrefill() {
+			content = prefill + content
+		}
+		header := fmt.Sprintf("## Choice %d", i+1)
+
+		if outputFile != nil {
+			if _, err := fmt.Fprintf(outputFile, "%s\n\n%s\n\n", header, content); err != nil {
+				return "", fmt.Errorf("failed to write output file: %w", err)
+			}
+		}
+
+		rendered := content
+		if !rawOutput() {
+			if output, err := renderResponse(content, expectJSON); err == nil {
+				rendered = output
+			}
+		}
+		fmt.Printf("%s\n\n%s\n\n", header, rendered)
+		printCitations(citationOutput(outputFile, expectJSON), choice.Message.Annotations)
+
+		printFinishNotice(os.Stderr, choice.FinishReason)
+		if expectJSON {
+			warnIfNotJSON(prefill + choice.Message.Content)
+		}
+	}
+
+	picked := choices[copyChoiceFlag-1].Message.Content
+	if echoPrefill() {
+		copyResponse(prefill + picked)
+	} else {
+		copyResponse(picked)
+	}
+
+	return prefill + picked, nil
+}
+
+// validateChoices checks -n and --copy-choice before anything is sent
+func validateChoices(choices, copyChoice int) error {
+	if choices < 1 {
+		return fmt.Errorf("invalid choices %d: must be at least 1", choices)
+	}
+
+	if copyChoice < 1 || copyChoice > choices {
+		return fmt.Errorf("invalid copy choice %d: must be between 1 and %d", copyChoice, choices)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/flacial/llm/internal/utils"
+	"github.com/flacial/llm/pkg/llm"
+	"github.com/spf13/viper"
+)
+
+// It's a global variable to allow easy mocking in tests by direct assignment. When it's nil,
+// a client is built from the configured timeout instead
+var httpClient llm.HTTPClient
+
+// newHTTPClient builds the client used to talk to OpenRouter, recording to --trace-file when set
+func newHTTPClient(streaming bool) llm.HTTPClient {
+	client := baseHTTPClient(streaming)
+	if traceFileFlag != "" {
+		return &traceHTTPClient{next: client, path: utils.ExpandPath(traceFileFlag)}
+	}
+
+	return client
+}
+
+// baseHTTPClient is the client requests go out with. Blocking requests get the full timeout
+// applied to the whole round trip, while streaming requests only bound the time until the
+// response headers arrive so a slow but active stream isn't cut off mid-answer
+func baseHTTPClient(streaming bool) llm.HTTPClient {
+	if mockMode() {
+		return mockHTTPClient{}
+	}
+
+	if httpClient != nil {
+		return httpClient
+	}
+
+	transport, err := httpTransport()
+	if err != nil {
+		return failingHTTPClient{err}
+	}
+
+	timeout := viper.GetDuration("timeout")
+
+	if !streaming {
+		return &http.Client{Transport: transport, Timeout: timeout}
+	}
+
+	transport.ResponseHeaderTimeout = timeout
+
+	return &http.Client{Transport: transport}
+}
+
+// httpTransport is built explicitly so it keeps honoring HTTP_PROXY, HTTPS_PROXY, and NO_PROXY,
+// and trusts the CA in ca_cert on top of the system ones, for networks that intercept TLS
+func httpTransport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	caCertPath := viper.GetString("ca_cert")
+	if caCertPath == "" {
+		return transport, nil
+	}
+
+	caCertPath = utils.ExpandPath(caCertPath)
+	caCert, err := os.ReadFile(caCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA certificate %q: %w", caCertPath, err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no PEM certificates found in %q", caCertPath)
+	}
+
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return transport, nil
+}
+
+// failingHTTPClient fails every request with the error that kept the real client from being
+// built, so a bad ca_cert surfaces wherever a request is made
+type failingHTTPClient struct {
+	err error
+}
+
+func (c failingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return nil, c.err
+}
+
+// getBaseURL returns the API root requests go to, OpenRouter unless base_url points elsewhere
+func getBaseURL() string {
+	if baseURL := viper.GetString("base_url"); baseURL != "" {
+		return strings.TrimRight(baseURL, "/")
+	}
+
+	return llm.OpenRouterBaseURL
+}
+
+// newLLMClient builds the client for the configured endpoint, identified to OpenRouter by the
+// openrouter.referer and openrouter.title settings. Other endpoints don't get those headers
+func newLLMClient(apiKey string, streaming bool) *llm.LLMClient {
+	llmClient := llm.NewLLMClient(apiKey, newHTTPClient(streaming), getBaseURL())
+	if getBaseURL() == llm.OpenRouterBaseURL {
+		llmClient.AppReferer = viper.GetString("openrouter.referer")
+		llmClient.AppTitle = viper.GetString("openrouter.title")
+	}
+	llmClient.MaxStreamLineBytes = viper.GetInt("stream.max_line_bytes")
+
+	return llmClient
+}
@@ -2,8 +2,11 @@ package cmd
 
 import (
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var completionCmd = &cobra.Command{
@@ -57,7 +60,7 @@ func init() {
 		Use:   "bash",
 		Short: "Generate the bash completion script",
 		Run: func(cmd *cobra.Command, args []string) {
-			rootCmd.GenBashCompletion(os.Stdout)
+			rootCmd.GenBashCompletionV2(os.Stdout, true)
 		},
 	})
 
@@ -85,3 +88,33 @@ func init() {
 		},
 	})
 }
+
+// completeModelNames suggests the configured aliases followed by the model IDs from the models
+// cache. It never hits the network so <TAB> stays instant and works offline; run `llm models`
+// once to populate the cache
+func completeModelNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var suggestions []string
+
+	aliases := viper.GetStringMapString("models.aliases")
+	aliasNames := make([]string, 0, len(aliases))
+	for alias := range aliases {
+		aliasNames = append(aliasNames, alias)
+	}
+	sort.Strings(aliasNames)
+
+	for _, alias := range aliasNames {
+		if strings.HasPrefix(alias, toComplete) {
+			suggestions = append(suggestions, alias+"\talias for "+aliases[alias])
+		}
+	}
+
+	if cache, err := readModelsCache(); err == nil {
+		for _, model := range cache.Models {
+			if strings.HasPrefix(model.ID, toComplete) {
+				suggestions = append(suggestions, model.ID+"\t"+model.Name)
+			}
+		}
+	}
+
+	return suggestions, cobra.ShellCompDirectiveNoFileComp
+}
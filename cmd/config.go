@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/flacial/llm/internal/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and manage the llm configuration",
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+	},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the effective value of a config key (e.g. model, models.aliases)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := args[0]
+		if !viper.IsSet(key) {
+			return fmt.Errorf("config key %q is not set", key)
+		}
+
+		return printConfigValue(maskSecrets(key, viper.Get(key)))
+	},
+}
+
+var configListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Print every effective config value",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printConfigValue(maskSecrets("", viper.AllSettings()))
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a config key and save it to the config file",
+	Long: `Sets a config key and saves it to the config file. The value is parsed like YAML, so
+"true" becomes a boolean and "0.7" a number. Nested keys use dots, e.g. models.aliases.quick.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, rawValue := args[0], args[1]
+
+		var value any
+		if err := yaml.Unmarshal([]byte(rawValue), &value); err != nil || value == nil {
+			value = rawValue
+		}
+
+		if err := saveConfigValue(key, value); err != nil {
+			return err
+		}
+
+		fmt.Printf("Set %s in %s\n", key, viper.ConfigFileUsed())
+		return nil
+	},
+}
+
+var configPathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Print the path of the config file in use",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println(viper.ConfigFileUsed())
+	},
+}
+
+var configProfilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "List the profiles defined in the config file",
+	Long: `Lists the named sections under "profiles" in the config file. The active one, picked with
+--profile or LLM_PROFILE, is marked with an asterisk.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		profiles := listProfiles()
+		if len(profiles) == 0 {
+			fmt.Println("No profiles defined. Add them under \"profiles\" in your config file.")
+			return nil
+		}
+
+		active := viper.GetString("profile")
+		for _, profile := range profiles {
+			marker := " "
+			if profile == active {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, profile)
+		}
+
+		return nil
+	},
+}
+
+// loadConfigFile reads the config file on its own, without the defaults, flags, env vars, and
+// profile merged into the global viper, so writing it back doesn't leak any of those into it
+func loadConfigFile() (*viper.Viper, error) {
+	fileConfig := viper.New()
+	fileConfig.SetConfigFile(viper.ConfigFileUsed())
+	fileConfig.SetConfigType(configType(viper.ConfigFileUsed()))
+
+	if err := fileConfig.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read config file %q: %w", viper.ConfigFileUsed(), err)
+	}
+
+	return fileConfig, nil
+}
+
+// saveConfigValue persists a single key to the config file and updates the running config to match.
+// The value replaces whatever was at that key, so removing entries from a map sticks
+func saveConfigValue(key string, value any) error {
+	fileConfig, err := loadConfigFile()
+	if err != nil {
+		return err
+	}
+
+	settings := fileConfig.AllSettings()
+	setNestedValue(settings, strings.Split(key, "."), value)
+
+	updatedConfig := viper.New()
+	updatedConfig.SetConfigFile(fileConfig.ConfigFileUsed())
+	updatedConfig.SetConfigType(configType(fileConfig.ConfigFileUsed()))
+	if err := updatedConfig.MergeConfigMap(settings); err != nil {
+		return fmt.Errorf("failed to update config: %w", err)
+	}
+
+	if err := updatedConfig.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to write config file %q: %w", fileConfig.ConfigFileUsed(), err)
+	}
+
+	viper.Set(key, value)
+	return nil
+}
+
+func setNestedValue(settings map[string]any, path []string, value any) {
+	if len(path) == 1 {
+		settings[path[0]] = value
+		return
+	}
+
+	child, ok := settings[path[0]].(map[string]any)
+	if !ok {
+		child = map[string]any{}
+		settings[path[0]] = child
+	}
+
+	setNestedValue(child, path[1:], value)
+}
+
+func printConfigValue(value any) error {
+	switch value.(type) {
+	case map[string]any, []any:
+		out, err := yaml.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to encode config value: %w", err)
+		}
+		fmt.Print(string(out))
+	default:
+		fmt.Println(value)
+	}
+
+	return nil
+}
+
+// maskSecrets walks a config value and masks every api_key in it, including the ones nested in
+// profiles
+func maskSecrets(key string, value any) any {
+	if nested, ok := value.(map[string]any); ok {
+		masked := make(map[string]any, len(nested))
+		for k, v := range nested {
+			masked[k] = maskSecrets(k, v)
+		}
+		return masked
+	}
+
+	// A file: or cmd: reference isn't the key itself, and is more useful shown as is
+	if secret, ok := value.(string); ok && (key == "api_key" || strings.HasSuffix(key, ".api_key")) && !isAPIKeyReference(secret) {
+		return utils.MaskSecret(secret)
+	}
+
+	return value
+}
+
+func listProfiles() []string {
+	profiles := make([]string, 0)
+	for profile := range viper.GetStringMap("profiles") {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+
+	return profiles
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configListCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configPathCmd)
+	configCmd.AddCommand(configProfilesCmd)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/internal/tokens"
+	"github.com/flacial/llm/pkg/llm"
+)
+
+// How fitContext handles a prompt that's too big for the model's context window
+const (
+	contextOverflowWarn     = "warn"
+	contextOverflowError    = "error"
+	contextOverflowTruncate = "truncate"
+)
+
+// fitContext checks the estimated size of messages against the model's context length from the
+// models cache, keeping room for maxTokens of answer when it's set. Depending on mode it warns,
+// fails, or drops the oldest history and attachment messages until the rest fits. The system
+// message and the prompt itself are never dropped. Models missing from the cache aren't checked
+func fitContext(messages []llm.ChatCompletionMessage, model string, maxTokens *int, mode string) ([]llm.ChatCompletionMessage, error) {
+	switch mode {
+	case contextOverflowWarn, contextOverflowError, contextOverflowTruncate:
+	default:
+		return nil, fmt.Errorf("invalid context.overflow %q: must be one of warn, error, truncate", mode)
+	}
+
+	cachedModel, found := findCachedModel(model)
+	if !found || cachedModel.ContextLength <= 0 {
+		return messages, nil
+	}
+
+	budget := cachedModel.ContextLength
+	if maxTokens != nil {
+		budget -= *maxTokens
+	}
+
+	tokenizer := cachedModel.Architecture.Tokenizer
+	estimated := estimateMessagesTokens(messages, tokenizer)
+	if estimated <= budget {
+		return messages, nil
+	}
+
+	switch mode {
+	case contextOverflowWarn:
+		log.Logger.Warn().Int("estimated_tokens", estimated).Int("context_length", budget).Str("model", model).Msg("The prompt probably doesn't fit the model's context, the request may be rejected. Use --truncate to trim it.")
+		return messages, nil
+	case contextOverflowError:
+		return nil, fmt.Errorf("prompt is ~%d tokens but %s only has room for %d, use --truncate to drop old history and attachments", estimated, model, budget)
+	}
+
+	fitted := append([]llm.ChatCompletionMessage(nil), messages...)
+	dropped := 0
+	for estimated > budget {
+		oldest := -1
+		for i, message := range fitted[:len(fitted)-1] {
+			if message.Role != "system" {
+				oldest = i
+				break
+			}
+		}
+
+		if oldest == -1 {
+			return nil, fmt.Errorf("prompt is ~%d tokens but %s only has room for %d, even without history and attachments", estimated, model, budget)
+		}
+
+		fitted = append(fitted[:oldest], fitted[oldest+1:]...)
+		dropped++
+		estimated = estimateMessagesTokens(fitted, tokenizer)
+	}
+
+	log.Logger.Warn().Int("dropped_messages", dropped).Int("estimated_tokens", estimated).Msg("Dropped the oldest messages to fit the model's context.")
+	return fitted, nil
+}
+
+func estimateMessagesTokens(messages []llm.ChatCompletionMessage, tokenizer string) int {
+	contents := make([]string, len(messages))
+	for i, message := range messages {
+		contents[i] = message.Content
+	}
+
+	return tokens.EstimateMessages(contents, tokenizer)
+}
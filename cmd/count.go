@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/flacial/llm/internal/tokens"
+)
+
+var countFlag bool
+
+// printResponseStats sizes up the answer for whatever consumes it next. Tokens are an estimate,
+// made with the model's tokenizer when the models cache knows it
+func printResponseStats(w io.Writer, content, model string) {
+	tokenizer := ""
+	if cachedModel, found := findCachedModel(model); found {
+		tokenizer = cachedModel.Architecture.Tokenizer
+	}
+
+	// A last line without a trailing newline still counts, unlike with wc -l
+	lines := strings.Count(content, "\n")
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		lines++
+	}
+
+	fmt.Fprintf(w, "Stats: %d characters, %d words, %d lines, ~%d tokens\n",
+		utf8.RuneCountInString(content), len(strings.Fields(content)), lines, tokens.Estimate(content, tokenizer))
+}
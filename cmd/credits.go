@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/pkg/llm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var creditsCmd = &cobra.Command{
+	Use:   "credits",
+	Short: "Show how much OpenRouter credit is left",
+	Long: `Prints the credit purchased, used, and remaining on the OpenRouter account of the configured key.
+Set credits.warn_below in the config to get a warning after a prompt once the balance drops under it.`,
+	Args: cobra.NoArgs,
+	RunE: runCreditsCommand,
+}
+
+// credits is the account balance in US dollars as reported by OpenRouter's /credits endpoint
+type credits struct {
+	Total float64 `json:"total_credits"`
+	Used  float64 `json:"total_usage"`
+}
+
+func (c credits) Remaining() float64 {
+	return c.Total - c.Used
+}
+
+func runCreditsCommand(cmd *cobra.Command, args []string) error {
+	if getBaseURL() != llm.OpenRouterBaseURL {
+		return fmt.Errorf("credits are only available on OpenRouter, not %s", getBaseURL())
+	}
+
+	apiKey, err := resolveAPIKey()
+	if err != nil {
+		return err
+	}
+	if apiKey == "" {
+		return errors.New("api key not set. Run 'llm auth login' or set LLM_API_KEY or OPENROUTER_API_KEY")
+	}
+
+	balance, err := fetchCredits(apiKey)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Total:     $%.2f\n", balance.Total)
+	fmt.Printf("Used:      $%.2f\n", balance.Used)
+	fmt.Printf("Remaining: $%.2f\n", balance.Remaining())
+
+	return nil
+}
+
+// fetchCredits asks OpenRouter for the account balance
+func fetchCredits(apiKey string) (credits, error) {
+	resp, err := authenticatedGet(apiKey, "/credits")
+	if err != nil {
+		return credits{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return credits{}, fmt.Errorf("credits endpoint returned status %d", resp.StatusCode)
+	}
+
+	var creditsResponse struct {
+		Data credits `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&creditsResponse); err != nil {
+		return credits{}, fmt.Errorf("failed to parse credits response: %w", err)
+	}
+
+	return creditsResponse.Data, nil
+}
+
+// warnIfLowCredits checks the balance after a prompt when credits.warn_below is set. It costs a
+// request, so it's off by default, and failures only show up in the debug log
+func warnIfLowCredits(apiKey string) {
+	threshold := viper.GetFloat64("credits.warn_below")
+	if threshold <= 0 || getBaseURL() != llm.OpenRouterBaseURL {
+		return
+	}
+
+	balance, err := fetchCredits(apiKey)
+	if err != nil {
+		log.Logger.Debug().Err(err).Msg("Failed to check the credit balance.")
+		return
+	}
+
+	if remaining := balance.Remaining(); remaining < threshold {
+		log.Logger.Warn().
+			Str("remaining", fmt.Sprintf("$%.2f", remaining)).
+			Msg("Low OpenRouter balance. Top up at https://openrouter.ai/settings/credits")
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(creditsCmd)
+}
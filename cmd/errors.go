@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/flacial/llm/pkg/llm"
+	"github.com/spf13/cobra"
+)
+
+// Exit statuses besides 0 for success and 1 for any other failure, so scripts can branch on the
+// kind of failure
+const (
+	// Bad flags, arguments, or no prompt at all
+	ExitUsage = 2
+	// The API answered with an error status
+	ExitAPIError = 3
+	// The request succeeded but the model produced no text
+	ExitNoContent = 4
+	// Ctrl-C, following the shell's 128 + SIGINT convention
+	ExitInterrupted = 130
+)
+
+// ErrNoContent means the request succeeded but produced no text, no choices or an empty stream
+var ErrNoContent = errors.New("no content produced")
+
+// UsageError marks a mistake in how llm was invoked, as opposed to something failing along the way
+type UsageError struct {
+	Err error
+}
+
+func (e *UsageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode maps the error Execute returned to the process exit status
+func ExitCode(err error) int {
+	var usageErr *UsageError
+	var apiErr *llm.APIError
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	case errors.As(err, &usageErr):
+		return ExitUsage
+	case errors.As(err, &apiErr):
+		return ExitAPIError
+	case errors.Is(err, ErrNoContent):
+		return ExitNoContent
+	}
+
+	return 1
+}
+
+// markUsageErrors makes the argument checks of cmd and its subcommands return UsageErrors. Flag
+// parsing errors are covered by the flag error func instead
+func markUsageErrors(cmd *cobra.Command) {
+	if validateArgs := cmd.Args; validateArgs != nil {
+		cmd.Args = func(cmd *cobra.Command, args []string) error {
+			if err := validateArgs(cmd, args); err != nil {
+				return &UsageError{err}
+			}
+			return nil
+		}
+	}
+
+	for _, child := range cmd.Commands() {
+		markUsageErrors(child)
+	}
+}
+
+// explainAPIError puts a hint on the API failures people can fix themselves. The original error
+// stays wrapped so callers can still inspect it
+func explainAPIError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("request timed out, allow more time with --timeout (or --request-timeout for a one-off prompt): %w", err)
+	}
+
+	var apiErr *llm.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusUnauthorized:
+		return fmt.Errorf("authentication failed, check your API key (--api-key, LLM_API_KEY, or api_key in the config): %w", err)
+	case http.StatusPaymentRequired:
+		return fmt.Errorf("insufficient credits, top up at https://openrouter.ai/settings/credits: %w", err)
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("rate limited, wait a moment and try again: %w", err)
+	}
+
+	return err
+}
@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/flacial/llm/internal/history"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var historyExportFormatFlag string
+var historyClearYesFlag bool
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Work with saved conversations",
+	Long:  `Work with the conversations saved in ~/.llm/history, the ones --continue picks up from.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+	},
+}
+
+var historyExportCmd = &cobra.Command{
+	Use:   "export [session]",
+	Short: "Print a saved conversation as Markdown or JSON",
+	Long: `Prints a saved conversation, the most recent one unless a session ID is given, as a Markdown
+transcript with a header per message, or as JSON with --format json.
+
+  llm history export > chat.md`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeConversationIDs,
+	RunE:              runHistoryExportCommand,
+}
+
+var historyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved conversations, most recent first",
+	Args:  cobra.NoArgs,
+	RunE:  runHistoryListCommand,
+}
+
+var historyResumeCmd = &cobra.Command{
+	Use:               "resume <session>",
+	Short:             "Continue a saved conversation in an interactive chat",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeConversationIDs,
+	RunE:              runHistoryResumeCommand,
+}
+
+var historyRmCmd = &cobra.Command{
+	Use:               "rm <session>...",
+	Short:             "Delete saved conversations",
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeConversationIDs,
+	RunE:              runHistoryRmCommand,
+}
+
+var historyClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Delete every saved conversation",
+	Args:  cobra.NoArgs,
+	RunE:  runHistoryClearCommand,
+}
+
+func runHistoryListCommand(cmd *cobra.Command, args []string) error {
+	historyDirPath, err := getHistoryDirPath()
+	if err != nil {
+		return err
+	}
+
+	conversations, err := history.List(historyDirPath)
+	if err != nil {
+		return err
+	}
+
+	if len(conversations) == 0 {
+		fmt.Println("No saved conversations yet.")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUPDATED\tMODEL\tMESSAGES\tFIRST PROMPT")
+	for _, conversation := range conversations {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n",
+			conversation.ID,
+			conversation.UpdatedAt.Format("2006-01-02 15:04"),
+			conversation.Model,
+			len(conversation.Messages),
+			firstPromptPreview(conversation))
+	}
+
+	return tw.Flush()
+}
+
+// firstPromptPreview is the first user message squeezed onto one short line
+func firstPromptPreview(conversation *history.Conversation) string {
+	for _, message := range conversation.Messages {
+		if message.Role == "user" {
+			return truncateString(strings.Join(strings.Fields(message.Content), " "), 50)
+		}
+	}
+
+	return ""
+}
+
+func runHistoryResumeCommand(cmd *cobra.Command, args []string) error {
+	historyDirPath, err := getHistoryDirPath()
+	if err != nil {
+		return err
+	}
+
+	conversation, err := history.Load(historyDirPath, args[0])
+	if err != nil {
+		return err
+	}
+
+	apiKey, err := resolveAPIKey()
+	if err != nil {
+		return err
+	}
+	if apiKey == "" {
+		return errors.New("api key not set. Please provide it via --api-key, environment variable (LLM_API_KEY or OPENROUTER_API_KEY), or in your config file")
+	}
+
+	// Older conversations may not have recorded their model
+	model := conversation.Model
+	if model == "" {
+		model = resolveModel(viper.GetString("model"))
+	}
+	if err := validateModel(model); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Resuming %s (%d messages).\n", conversation.ID, len(conversation.Messages))
+	return runChatSession(cmd, apiKey, model, conversation)
+}
+
+func runHistoryRmCommand(cmd *cobra.Command, args []string) error {
+	historyDirPath, err := getHistoryDirPath()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range args {
+		if err := history.Delete(historyDirPath, id); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %s\n", id)
+	}
+
+	return nil
+}
+
+func runHistoryClearCommand(cmd *cobra.Command, args []string) error {
+	historyDirPath, err := getHistoryDirPath()
+	if err != nil {
+		return err
+	}
+
+	if !historyClearYesFlag {
+		fmt.Fprint(cmd.ErrOrStderr(), "Delete every saved conversation? [y/N] ")
+		answer, err := bufio.NewReader(promptStdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("error reading answer: %w", err)
+		}
+
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Fprintln(cmd.ErrOrStderr(), "Nothing deleted.")
+			return nil
+		}
+	}
+
+	deleted, err := history.Clear(historyDirPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Deleted %d conversations\n", deleted)
+	return nil
+}
+
+func runHistoryExportCommand(cmd *cobra.Command, args []string) error {
+	switch historyExportFormatFlag {
+	case "md", "json":
+	default:
+		return fmt.Errorf("invalid format %q: must be md or json", historyExportFormatFlag)
+	}
+
+	historyDirPath, err := getHistoryDirPath()
+	if err != nil {
+		return err
+	}
+
+	var conversation *history.Conversation
+	if len(args) == 1 {
+		conversation, err = history.Load(historyDirPath, args[0])
+	} else {
+		conversation, err = history.Latest(historyDirPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	if historyExportFormatFlag == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(conversation)
+	}
+
+	return writeConversationMarkdown(os.Stdout, conversation)
+}
+
+// writeConversationMarkdown prints the messages verbatim under a header per role, so code blocks
+// in them come through untouched
+func writeConversationMarkdown(w io.Writer, conversation *history.Conversation) error {
+	fmt.Fprintf(w, "# Conversation %s\n\n", conversation.ID)
+	if conversation.Model != "" {
+		fmt.Fprintf(w, "- Model: %s\n", conversation.Model)
+	}
+	fmt.Fprintf(w, "- Started: %s\n", conversation.CreatedAt.Format("2006-01-02 15:04"))
+	fmt.Fprintf(w, "- Updated: %s\n", conversation.UpdatedAt.Format("2006-01-02 15:04"))
+
+	for _, message := range conversation.Messages {
+		role := message.Role
+		if role != "" {
+			role = strings.ToUpper(role[:1]) + role[1:]
+		}
+
+		if _, err := fmt.Fprintf(w, "\n## %s\n\n%s\n", role, strings.TrimSpace(message.Content)); err != nil {
+			return fmt.Errorf("failed to write conversation: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func completeConversationIDs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	historyDirPath, err := getHistoryDirPath()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	// Listing skips the last request and anything else that isn't a conversation
+	conversations, err := history.List(historyDirPath)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var ids []string
+	for _, conversation := range conversations {
+		if strings.HasPrefix(conversation.ID, toComplete) {
+			ids = append(ids, conversation.ID)
+		}
+	}
+
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.AddCommand(historyListCmd)
+	historyCmd.AddCommand(historyResumeCmd)
+	historyCmd.AddCommand(historyRmCmd)
+	historyCmd.AddCommand(historyClearCmd)
+	historyCmd.AddCommand(historyExportCmd)
+
+	historyClearCmd.Flags().BoolVarP(&historyClearYesFlag, "yes", "y", false, "Don't ask for confirmation")
+	historyExportCmd.Flags().StringVar(&historyExportFormatFlag, "format", "md", "Export format: md or json")
+}
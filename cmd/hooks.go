@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/pkg/llm"
+	"github.com/spf13/viper"
+)
+
+const (
+	hookPreRequest   = "hooks.pre_request"
+	hookPostResponse = "hooks.post_response"
+)
+
+// runHook pipes input through the shell command configured under key and returns what it printed,
+// or input as is when there's no command. hooks.timeout bounds each run, and cancelling ctx stops it
+func runHook(ctx context.Context, key, input string) (string, error) {
+	command := viper.GetString(key)
+	if command == "" {
+		return input, nil
+	}
+
+	timeout := viper.GetDuration("hooks.timeout")
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	hookCmd := shellCommand(ctx, command)
+	killProcessGroup(hookCmd)
+	hookCmd.Stdin = strings.NewReader(input)
+	var stdout bytes.Buffer
+	hookCmd.Stdout = &stdout
+	hookCmd.Stderr = os.Stderr
+	// A background process the hook started could hold stdout open long after it was killed
+	hookCmd.WaitDelay = time.Second
+
+	log.Logger.Debug().Str("hook", key).Msg("Running hook.")
+	if err := hookCmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("%s command %q timed out after %s", key, command, timeout)
+		}
+		return "", fmt.Errorf("%s command %q failed: %w", key, command, err)
+	}
+
+	output := strings.TrimRight(stdout.String(), "\r\n")
+	if strings.TrimSpace(output) == "" {
+		return "", fmt.Errorf("%s command %q printed nothing", key, command)
+	}
+
+	return output, nil
+}
+
+// shellCommand runs command through the platform's shell, so pipes and quoting in the config work
+// as they would in a terminal
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// runPreRequestHook passes every message through hooks.pre_request, including the system prompt and
+// attachments, so nothing reaches the API without going through it. Image messages have their text
+// parts rewritten too, since those are what's sent
+func runPreRequestHook(ctx context.Context, messages []llm.ChatCompletionMessage) error {
+	if viper.GetString(hookPreRequest) == "" {
+		return nil
+	}
+
+	for i := range messages {
+		message := &messages[i]
+		original := message.Content
+		if original != "" {
+			content, err := runHook(ctx, hookPreRequest, original)
+			if err != nil {
+				return err
+			}
+			message.Content = content
+		}
+
+		for j := range message.ContentParts {
+			part := &message.ContentParts[j]
+			if part.Type != "text" || part.Text == "" {
+				continue
+			}
+			// The first text part is a copy of Content, there's no need to run the hook on it again
+			if part.Text == original {
+				part.Text = message.Content
+				continue
+			}
+			text, err := runHook(ctx, hookPreRequest, part.Text)
+			if err != nil {
+				return err
+			}
+			part.Text = text
+		}
+	}
+
+	return nil
+}
+
+// runPostResponseHook passes the answer through hooks.post_response. The answer has already been
+// paid for, so when the hook fails it's kept as received with a warning rather than lost
+func runPostResponseHook(ctx context.Context, content string) string {
+	output, err := runHook(ctx, hookPostResponse, content)
+	if err != nil {
+		log.Logger.Warn().Err(err).Msg("Post-response hook failed, showing the answer as received.")
+		return content
+	}
+
+	return output
+}
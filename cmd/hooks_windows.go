@@ -0,0 +1,9 @@
+//go:build windows
+
+package cmd
+
+import "os/exec"
+
+// killProcessGroup leaves cmd as is. Windows has no process groups to kill this way, so cancelling
+// only stops cmd.exe itself
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/flacial/llm/internal/log"
+	"github.com/mattn/go-runewidth"
+)
+
+const defaultTerminalWidth = 80
+
+// markdownStreamWriter shows streamed text right away and, once a markdown block is complete
+// (a blank line outside a code fence, or a closing fence), erases the raw block from the terminal
+// and prints it rendered instead. Only meant for terminals since it relies on cursor movement
+type markdownStreamWriter struct {
+	out   io.Writer
+	width int
+	// The text of the current, still incomplete block, which is on screen unrendered
+	pending strings.Builder
+}
+
+func newMarkdownStreamWriter(out io.Writer) *markdownStreamWriter {
+	return &markdownStreamWriter{out: out, width: terminalWidth()}
+}
+
+func (w *markdownStreamWriter) Write(p []byte) (int, error) {
+	if _, err := w.out.Write(p); err != nil {
+		return 0, err
+	}
+	w.pending.Write(p)
+
+	blockEnd := completedBlockEnd(w.pending.String())
+	if blockEnd == 0 {
+		return len(p), nil
+	}
+
+	pending := w.pending.String()
+	block, rest := pending[:blockEnd], pending[blockEnd:]
+
+	// Whitespace between blocks has nothing to render, so the raw newlines can stay
+	if strings.TrimSpace(block) == "" {
+		w.pending.Reset()
+		w.pending.WriteString(rest)
+		return len(p), nil
+	}
+
+	if err := w.replace(pending, block); err != nil {
+		return 0, err
+	}
+
+	if _, err := io.WriteString(w.out, rest); err != nil {
+		return 0, err
+	}
+
+	w.pending.Reset()
+	w.pending.WriteString(rest)
+
+	return len(p), nil
+}
+
+// Flush renders whatever is left once the stream ends, since the last block rarely ends with a
+// blank line
+func (w *markdownStreamWriter) Flush() error {
+	pending := w.pending.String()
+	w.pending.Reset()
+
+	if strings.TrimSpace(pending) == "" {
+		return nil
+	}
+
+	return w.replace(pending, pending)
+}
+
+// interrupt wraps next, something else printing to the same terminal like streamed reasoning, so
+// the block on screen is rendered before next writes under it. The rows next takes up would
+// otherwise be erased along with the block, since they aren't part of it
+func (w *markdownStreamWriter) interrupt(next io.Writer) io.Writer {
+	return &interruptingWriter{stream: w, next: next}
+}
+
+type interruptingWriter struct {
+	stream *markdownStreamWriter
+	next   io.Writer
+}
+
+func (w *interruptingWriter) Write(p []byte) (int, error) {
+	if err := w.stream.Flush(); err != nil {
+		return 0, err
+	}
+
+	return w.next.Write(p)
+}
+
+// replace erases the raw text shown on screen and prints block rendered in its place
+func (w *markdownStreamWriter) replace(shown, block string) error {
+	rendered, err := renderResponse(block, false)
+	if err != nil {
+		// The raw text is still on screen, which beats losing it
+		log.Logger.Debug().Err(err).Msg("Error rendering streamed markdown block.")
+		return nil
+	}
+
+	if rowsUp := w.rows(shown) - 1; rowsUp > 0 {
+		fmt.Fprintf(w.out, "\x1b[%dA", rowsUp)
+	}
+
+	_, err = fmt.Fprintf(w.out, "\r\x1b[J%s", strings.TrimLeft(rendered, "\n"))
+	return err
+}
+
+// rows counts the terminal rows text takes up, including lines the terminal wrapped
+func (w *markdownStreamWriter) rows(text string) int {
+	rows := 0
+	for _, line := range strings.Split(text, "\n") {
+		lineWidth := runewidth.StringWidth(line)
+		if lineWidth == 0 {
+			rows++
+			continue
+		}
+
+		rows += (lineWidth + w.width - 1) / w.width
+	}
+
+	return rows
+}
+
+// completedBlockEnd returns the offset just past the last complete markdown block in text, or 0
+// when there's none yet. Blank lines inside code fences don't end a block
+func completedBlockEnd(text string) int {
+	end := 0
+	inFence := false
+
+	offset := 0
+	for {
+		newline := strings.IndexByte(text[offset:], '\n')
+		if newline == -1 {
+			break
+		}
+
+		line := strings.TrimSpace(text[offset : offset+newline])
+		offset += newline + 1
+
+		switch {
+		case strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~"):
+			if inFence {
+				end = offset
+			}
+			inFence = !inFence
+		case line == "" && !inFence:
+			end = offset
+		}
+	}
+
+	return end
+}
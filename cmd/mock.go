@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/flacial/llm/pkg/llm"
+	"github.com/spf13/viper"
+)
+
+// mockMode reports whether requests are answered locally instead of by the API (--mock or
+// LLM_MOCK), so templates, rendering, and pipelines can be tried without spending tokens
+func mockMode() bool {
+	return viper.GetBool("mock")
+}
+
+// mockHTTPClient answers chat completion requests with mock_response from the config, or by echoing
+// the prompt back. Every other endpoint gets a 404 so nothing else pretends to work
+type mockHTTPClient struct{}
+
+func (mockHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/chat/completions") {
+		return mockHTTPResponse(http.StatusNotFound, `{"error": {"message": "not available in mock mode"}}`), nil
+	}
+
+	var request llm.ChatCompletionRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		return mockHTTPResponse(http.StatusBadRequest, fmt.Sprintf(`{"error": {"message": %q}}`, err.Error())), nil
+	}
+
+	content := mockContent(request)
+	usage := llm.Usage{
+		PromptTokens:     mockTokenCount(request.Messages),
+		CompletionTokens: len(content) / 4,
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	if !request.Stream {
+		response := llm.ChatCompletionResponse{Id: "mock", Usage: &usage}
+		for i := range max(1, intValue(request.N)) {
+			response.Choices = append(response.Choices, llm.ChatCompletionResponseChoices{
+				Index:        i,
+				Message:      llm.ChatCompletionResponseMessage{Role: "assistant", Content: content},
+				FinishReason: "stop",
+			})
+		}
+
+		body, err := json.Marshal(response)
+		if err != nil {
+			return nil, err
+		}
+
+		return mockHTTPResponse(http.StatusOK, string(body)), nil
+	}
+
+	// Word sized chunks, so streaming and the markdown stream renderer see more than one delta
+	var stream bytes.Buffer
+	for _, word := range strings.SplitAfter(content, " ") {
+		chunk, _ := json.Marshal(llm.ChatCompletionStreamResponse{
+			Id: "mock",
+			Choices: []llm.ChatCompletionStreamResponseChoices{{
+				Delta: llm.ChatCompletionStreamResponseMessageDelta{Content: word},
+			}},
+		})
+		fmt.Fprintf(&stream, "data: %s\n\n", chunk)
+	}
+
+	final := llm.ChatCompletionStreamResponse{
+		Id:      "mock",
+		Choices: []llm.ChatCompletionStreamResponseChoices{{FinishReason: "stop"}},
+	}
+	if request.StreamOptions != nil && request.StreamOptions.IncludeUsage {
+		final.Usage = &usage
+	}
+	chunk, _ := json.Marshal(final)
+	fmt.Fprintf(&stream, "data: %s\n\ndata: [DONE]\n\n", chunk)
+
+	return mockHTTPResponse(http.StatusOK, stream.String()), nil
+}
+
+// mockContent is the configured mock_response, or a reply quoting the last user message. JSON
+// requests get a JSON object back so structured output handling is exercised too
+func mockContent(request llm.ChatCompletionRequest) string {
+	if response := viper.GetString("mock_response"); response != "" {
+		return response
+	}
+
+	prompt := ""
+	for i := len(request.Messages) - 1; i >= 0; i-- {
+		if request.Messages[i].Role == "user" {
+			prompt = request.Messages[i].Content
+			break
+		}
+	}
+
+	if request.ResponseFormat != nil {
+		body, _ := json.Marshal(map[string]any{"mock": true, "model": request.Model, "prompt": prompt})
+		return string(body)
+	}
+
+	return fmt.Sprintf("**Mock response** from `%s` to:\n\n> %s", request.Model, strings.ReplaceAll(prompt, "\n", "\n> "))
+}
+
+// mockTokenCount is a rough four characters per token guess, enough to fill in --usage
+func mockTokenCount(messages []llm.ChatCompletionMessage) int {
+	characters := 0
+	for _, message := range messages {
+		characters += len(message.Content)
+	}
+
+	return characters / 4
+}
+
+func mockHTTPResponse(statusCode int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: statusCode,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     make(http.Header),
+	}
+}
+
+func intValue(value *int) int {
+	if value == nil {
+		return 0
+	}
+
+	return *value
+}
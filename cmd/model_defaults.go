@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// modelDefaults are the sampling parameters models.defaults.<model> sets for one model. They
+// override the global config, while templates and flags override them
+type modelDefaults struct {
+	Temperature *float64 `json:"temperature"`
+	MaxTokens   *int     `json:"max_tokens"`
+	TopP        *float64 `json:"top_p"`
+}
+
+// loadModelDefaults looks up the defaults for model, by its full ID or else an alias pointing at
+// it. Model IDs have dots in them, so the section is read as a whole instead of by key path
+func loadModelDefaults(model string) (modelDefaults, error) {
+	var defaults modelDefaults
+
+	sections := viper.GetStringMap("models.defaults")
+	name := ""
+	for candidate := range sections {
+		if strings.EqualFold(candidate, model) {
+			name = candidate
+			break
+		}
+		if strings.EqualFold(resolveModel(candidate), model) {
+			name = candidate
+		}
+	}
+	if name == "" {
+		return defaults, nil
+	}
+
+	// A round trip through JSON evens out what YAML, TOML, and JSON configs decode numbers as
+	encoded, err := json.Marshal(sections[name])
+	if err != nil {
+		return defaults, fmt.Errorf("invalid models.defaults for %q: %w", name, err)
+	}
+	if err := json.Unmarshal(encoded, &defaults); err != nil {
+		return defaults, fmt.Errorf("invalid models.defaults for %q: %w", name, err)
+	}
+
+	return defaults, nil
+}
@@ -4,12 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 type OpenRouterModelsResponse struct {
@@ -49,6 +54,14 @@ type ModelTopProvider struct {
 	IsModerated bool `json:"is_moderated"`
 }
 
+var modelsFilterFlag string
+var modelsSortFlag string
+var modelsFreeFlag bool
+var modelsModalityFlag string
+var modelsOutputFlag string
+var modelsRefreshFlag bool
+var modelsShowOutputFlag string
+
 var ModelsCmd = &cobra.Command{
 	Use:   "models",
 	Short: "List available LLM models from OpenRouter.ai",
@@ -56,52 +69,276 @@ var ModelsCmd = &cobra.Command{
 	RunE:  runModelsCommand,
 }
 
+var modelsShowCmd = &cobra.Command{
+	Use:               "show <model>",
+	Short:             "Show everything OpenRouter says about one model",
+	Long:              `Prints the full details of a model, by ID or alias: every price, its input and output modalities, tokenizer, moderation, and more.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeModelNames,
+	RunE:              runModelsShowCommand,
+}
+
+var modelsCompareCmd = &cobra.Command{
+	Use:               "compare <model> <model> [model...]",
+	Short:             "Compare models side by side",
+	Long:              `Prints a table of the given models, by ID or alias, with their context length, prices, modalities, and moderation. The cheapest prices and the largest context are marked.`,
+	Args:              cobra.MinimumNArgs(2),
+	ValidArgsFunction: completeModelNames,
+	RunE:              runModelsCompareCommand,
+}
+
 func runModelsCommand(cmd *cobra.Command, args []string) error {
-	apiKey := viper.GetString("api_key")
+	apiKey, err := resolveAPIKey()
+	if err != nil {
+		return err
+	}
 	if apiKey == "" {
-		return fmt.Errorf("API key not set. Please set LLM_API_KEY environment variable or 'api_key' in config to query OpenRouter.ai models.")
+		return fmt.Errorf("API key not set. Please set the LLM_API_KEY or OPENROUTER_API_KEY environment variable or 'api_key' in config to query OpenRouter.ai models.")
 	}
 
-	openRouterAPIURL := "https://openrouter.ai/api/v1/models"
+	switch modelsOutputFlag {
+	case "text", "json", "table":
+	default:
+		return fmt.Errorf("invalid output %q: must be one of text, json, table", modelsOutputFlag)
+	}
 
-	req, err := http.NewRequest("GET", openRouterAPIURL, nil)
+	models, err := loadModels(apiKey, modelsRefreshFlag)
 	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %w", err)
+		return err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
+	totalModels := len(models)
 
-	resp, err := httpClient.Do(req)
+	models = filterModels(models, modelsFilterFlag, modelsModalityFlag, modelsFreeFlag)
+	if err := sortModels(models, modelsSortFlag); err != nil {
+		return err
+	}
+
+	switch modelsOutputFlag {
+	case "json":
+		return printModelsJSON(os.Stdout, models)
+	case "table":
+		return printModelsTable(os.Stdout, models)
+	}
+
+	if totalModels == 0 {
+		fmt.Println("No models found from openrouter.ai—AI took over and we're now doomed.")
+		return nil
+	}
+
+	if len(models) == 0 {
+		fmt.Println("No models match the given filters.")
+		return nil
+	}
+
+	printModelsText(models)
+	return nil
+}
+
+// runModelsShowCommand prints every detail of one model, as text or JSON
+func runModelsShowCommand(cmd *cobra.Command, args []string) error {
+	switch modelsShowOutputFlag {
+	case "text", "json":
+	default:
+		return fmt.Errorf("invalid output %q: must be one of text, json", modelsShowOutputFlag)
+	}
+
+	models, err := lookupModels(args, modelsRefreshFlag)
 	if err != nil {
-		return fmt.Errorf("failed to make HTTP request to OpenRouter API: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("OpenRouter API returned non-200 status: %d %s, Body: %s", resp.StatusCode, resp.Status, string(bodyBytes))
+	if modelsShowOutputFlag == "json" {
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(models[0])
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+	printModelDetails(cmd.OutOrStdout(), models[0], args[0])
+	return nil
+}
+
+// lookupModels finds each model by ID or alias. The cached list is enough when it has all of
+// them, so no API key is needed for models that are already known. Otherwise a fresh list is
+// fetched, in case some of them are new
+func lookupModels(names []string, refresh bool) ([]OpenRouterModel, error) {
+	ids := make([]string, len(names))
+	for i, name := range names {
+		ids[i] = resolveModel(name)
+	}
+
+	if !refresh {
+		if cache, err := readModelsCache(); err == nil {
+			if models, err := pickModels(cache.Models, ids); err == nil {
+				return models, nil
+			}
+		}
+	}
+
+	apiKey, err := resolveAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("API key not set. Please set the LLM_API_KEY or OPENROUTER_API_KEY environment variable or 'api_key' in config to query OpenRouter.ai models.")
+	}
+
+	models, err := loadModels(apiKey, true)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
 	}
 
-	var modelsResponse OpenRouterModelsResponse
-	err = json.Unmarshal(bodyBytes, &modelsResponse)
+	return pickModels(models, ids)
+}
+
+// pickModels returns the models with the given IDs, in that order, suggesting the closest known
+// ID for the first one that's missing
+func pickModels(models []OpenRouterModel, ids []string) ([]OpenRouterModel, error) {
+	byID := make(map[string]OpenRouterModel, len(models))
+	candidates := make([]string, 0, len(models))
+	for _, model := range models {
+		byID[model.ID] = model
+		candidates = append(candidates, model.ID)
+	}
+
+	picked := make([]OpenRouterModel, 0, len(ids))
+	for _, id := range ids {
+		model, found := byID[id]
+		if !found {
+			if suggestion := closestMatch(id, candidates); suggestion != "" {
+				return nil, fmt.Errorf("unknown model %q; did you mean %q?", id, suggestion)
+			}
+			return nil, fmt.Errorf("unknown model %q; run 'llm models' to see the available ones", id)
+		}
+		picked = append(picked, model)
+	}
+
+	return picked, nil
+}
+
+func runModelsCompareCommand(cmd *cobra.Command, args []string) error {
+	models, err := lookupModels(args, modelsRefreshFlag)
 	if err != nil {
-		return fmt.Errorf("failed to parse JSON response from OpenRouter API: %w", err)
+		return err
 	}
 
-	if len(modelsResponse.Data) == 0 {
-		fmt.Println("No models found from openrouter.ai—AI took over and we're now doomed.")
-		return nil
+	return printModelsComparison(cmd.OutOrStdout(), models)
+}
+
+// printModelsComparison lines the models up in a table, marking the best value of each of the
+// columns people usually decide on with a *. Ties are all marked
+func printModelsComparison(w io.Writer, models []OpenRouterModel) error {
+	cheapestInput, cheapestOutput, largestContext := math.Inf(1), math.Inf(1), 0
+	for _, model := range models {
+		cheapestInput = min(cheapestInput, parsePrice(model.Pricing.Prompt))
+		cheapestOutput = min(cheapestOutput, parsePrice(model.Pricing.Completion))
+		largestContext = max(largestContext, model.ContextLength)
+	}
+
+	mark := func(value string, best bool) string {
+		if best {
+			return value + " *"
+		}
+		return value
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "MODEL\tCONTEXT\tINPUT $/1M\tOUTPUT $/1M\tINPUT\tOUTPUT\tMODERATED")
+	for _, model := range models {
+		inputPrice, outputPrice := parsePrice(model.Pricing.Prompt), parsePrice(model.Pricing.Completion)
+
+		moderated := "no"
+		if model.TopProvider.IsModerated {
+			moderated = "yes"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			model.ID,
+			mark(strconv.Itoa(model.ContextLength), model.ContextLength == largestContext),
+			mark(formatPricePerMillion(model.Pricing.Prompt), !math.IsInf(inputPrice, 1) && inputPrice == cheapestInput),
+			mark(formatPricePerMillion(model.Pricing.Completion), !math.IsInf(outputPrice, 1) && outputPrice == cheapestOutput),
+			joinOrNone(model.Architecture.InputModalities),
+			joinOrNone(model.Architecture.OutputModalities),
+			moderated)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(w, "\n* cheapest price or largest context")
+	return err
+}
+
+// printModelDetails prints every field of the model, unlike the list which keeps to the ones
+// worth comparing. requested is what the user typed, to show when it was an alias
+func printModelDetails(w io.Writer, model OpenRouterModel, requested string) {
+	if requested != model.ID {
+		fmt.Fprintf(w, "ID: %s (alias %s)\n", model.ID, requested)
+	} else {
+		fmt.Fprintf(w, "ID: %s\n", model.ID)
+	}
+	fmt.Fprintf(w, "Name: %s\n", model.Name)
+	fmt.Fprintf(w, "Added: %s\n", time.Unix(model.Created, 0).UTC().Format("2006-01-02"))
+	fmt.Fprintf(w, "Context Length: %d tokens\n", model.ContextLength)
+	fmt.Fprintf(w, "Input Modalities: %s\n", joinOrNone(model.Architecture.InputModalities))
+	fmt.Fprintf(w, "Output Modalities: %s\n", joinOrNone(model.Architecture.OutputModalities))
+	fmt.Fprintf(w, "Tokenizer: %s\n", valueOrDash(model.Architecture.Tokenizer))
+	fmt.Fprintf(w, "Instruct Type: %s\n", valueOrDash(model.Architecture.InstructType))
+	if model.TopProvider.IsModerated {
+		fmt.Fprintln(w, "Moderated: Yes")
+	} else {
+		fmt.Fprintln(w, "Moderated: No")
+	}
+
+	// Token prices are per token upstream, shown per million like the list does. The rest are
+	// charged per unit
+	prices := []struct {
+		label    string
+		price    string
+		perToken bool
+		unit     string
+	}{
+		{"Input", model.Pricing.Prompt, true, ""},
+		{"Output", model.Pricing.Completion, true, ""},
+		{"Reasoning", model.Pricing.InternalReasoning, true, ""},
+		{"Cache Read", model.Pricing.InputCacheRead, true, ""},
+		{"Cache Write", model.Pricing.InputCacheWrite, true, ""},
+		{"Image", model.Pricing.Image, false, "image"},
+		{"Request", model.Pricing.Request, false, "request"},
+		{"Web Search", model.Pricing.WebSearch, false, "search"},
+	}
+
+	fmt.Fprintln(w, "Pricing:")
+	for _, price := range prices {
+		if price.price == "" {
+			continue
+		}
+		if price.perToken {
+			fmt.Fprintf(w, "  %s: $%s / 1M tokens\n", price.label, formatPricePerMillion(price.price))
+		} else {
+			fmt.Fprintf(w, "  %s: $%s / %s\n", price.label, price.price, price.unit)
+		}
+	}
+
+	if model.Description != "" {
+		fmt.Fprintf(w, "\n%s\n", model.Description)
 	}
+}
+
+func joinOrNone(values []string) string {
+	if len(values) == 0 {
+		return "-"
+	}
+
+	return strings.Join(values, ", ")
+}
 
+func printModelsText(models []OpenRouterModel) {
 	fmt.Println("Available Models from openrouter.ai:")
 	fmt.Println("------------------------------------")
-	for _, model := range modelsResponse.Data {
+	for _, model := range models {
 		fmt.Printf("ID: %s\n", model.ID)
 		fmt.Printf("Name: %s\n", model.Name)
 		fmt.Printf("Description: %s\n", truncateString(model.Description, 100)+"\n") // Truncate long descriptions
@@ -123,10 +360,150 @@ func runModelsCommand(cmd *cobra.Command, args []string) error {
 		fmt.Printf("Added: %s\n", time.Unix(model.Created, 0).Format("2006-01-02"))
 		fmt.Println("------------------------------------")
 	}
+}
+
+// printModelsJSON dumps the models untouched (no truncation) so scripts get every field
+func printModelsJSON(w io.Writer, models []OpenRouterModel) error {
+	if models == nil {
+		models = []OpenRouterModel{}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(models); err != nil {
+		return fmt.Errorf("failed to encode models as JSON: %w", err)
+	}
+
+	return nil
+}
+
+func printModelsTable(w io.Writer, models []OpenRouterModel) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "ID\tCONTEXT\tINPUT $/1M\tOUTPUT $/1M\tMODALITIES")
+	for _, model := range models {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n",
+			model.ID,
+			model.ContextLength,
+			formatPricePerMillion(model.Pricing.Prompt),
+			formatPricePerMillion(model.Pricing.Completion),
+			strings.Join(model.Architecture.InputModalities, ","))
+	}
+
+	return tw.Flush()
+}
+
+// formatPricePerMillion converts OpenRouter's per-token price into the per-million figure people
+// compare models by
+func formatPricePerMillion(price string) string {
+	value := parsePrice(price)
+	if math.IsInf(value, 1) {
+		return "-"
+	}
+
+	return strconv.FormatFloat(value*1_000_000, 'f', -1, 64)
+}
+
+func fetchModels(apiKey string) ([]OpenRouterModel, error) {
+	req, err := http.NewRequest("GET", getBaseURL()+"/models", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := newHTTPClient(false).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make HTTP request to OpenRouter API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("OpenRouter API returned non-200 status: %d %s, Body: %s", resp.StatusCode, resp.Status, string(bodyBytes))
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var modelsResponse OpenRouterModelsResponse
+	err = json.Unmarshal(bodyBytes, &modelsResponse)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response from OpenRouter API: %w", err)
+	}
+
+	return modelsResponse.Data, nil
+}
+
+// filterModels keeps the models matching every non-empty criterion. The query and modality are
+// matched case-insensitively
+func filterModels(models []OpenRouterModel, query, modality string, freeOnly bool) []OpenRouterModel {
+	query = strings.ToLower(query)
+	modality = strings.ToLower(modality)
 
+	var filtered []OpenRouterModel
+	for _, model := range models {
+		if query != "" && !strings.Contains(strings.ToLower(model.ID), query) && !strings.Contains(strings.ToLower(model.Name), query) {
+			continue
+		}
+
+		if modality != "" && !slices.ContainsFunc(model.Architecture.InputModalities, func(m string) bool {
+			return strings.ToLower(m) == modality
+		}) {
+			continue
+		}
+
+		if freeOnly && !isFreeModel(model) {
+			continue
+		}
+
+		filtered = append(filtered, model)
+	}
+
+	return filtered
+}
+
+// sortModels orders models in place. Cheapest and newest come first since that's what people
+// usually hunt for, while context sorts largest first
+func sortModels(models []OpenRouterModel, by string) error {
+	var less func(a, b OpenRouterModel) bool
+
+	switch by {
+	case "":
+		return nil
+	case "name":
+		less = func(a, b OpenRouterModel) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	case "price":
+		less = func(a, b OpenRouterModel) bool { return parsePrice(a.Pricing.Prompt) < parsePrice(b.Pricing.Prompt) }
+	case "context":
+		less = func(a, b OpenRouterModel) bool { return a.ContextLength > b.ContextLength }
+	case "date":
+		less = func(a, b OpenRouterModel) bool { return a.Created > b.Created }
+	default:
+		return fmt.Errorf("invalid sort %q: must be one of name, price, context, date", by)
+	}
+
+	sort.SliceStable(models, func(i, j int) bool { return less(models[i], models[j]) })
 	return nil
 }
 
+// parsePrice turns OpenRouter's string prices into numbers. Missing or malformed prices sort last
+func parsePrice(price string) float64 {
+	value, err := strconv.ParseFloat(price, 64)
+	if err != nil {
+		return math.Inf(1)
+	}
+
+	return value
+}
+
+func isFreeModel(model OpenRouterModel) bool {
+	return parsePrice(model.Pricing.Prompt) == 0 && parsePrice(model.Pricing.Completion) == 0
+}
+
 func truncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {
 		return s
@@ -137,4 +514,18 @@ func truncateString(s string, maxLen int) string {
 
 func init() {
 	rootCmd.AddCommand(ModelsCmd)
+
+	ModelsCmd.Flags().StringVar(&modelsFilterFlag, "filter", "", "Only show models whose ID or name contains this text")
+	ModelsCmd.Flags().StringVar(&modelsSortFlag, "sort", "", "Sort models by name, price, context, or date")
+	ModelsCmd.Flags().BoolVar(&modelsFreeFlag, "free", false, "Only show models that cost nothing to use")
+	ModelsCmd.Flags().StringVar(&modelsModalityFlag, "modality", "", "Only show models accepting this input modality (e.g. image)")
+	ModelsCmd.Flags().StringVarP(&modelsOutputFlag, "output", "o", "text", "Output format: text, json, or table")
+	ModelsCmd.Flags().BoolVar(&modelsRefreshFlag, "refresh", false, "Ignore the cached models list and fetch a fresh one")
+
+	ModelsCmd.AddCommand(modelsShowCmd)
+	modelsShowCmd.Flags().StringVarP(&modelsShowOutputFlag, "output", "o", "text", "Output format: text or json")
+	modelsShowCmd.Flags().BoolVar(&modelsRefreshFlag, "refresh", false, "Ignore the cached models list and fetch a fresh one")
+
+	ModelsCmd.AddCommand(modelsCompareCmd)
+	modelsCompareCmd.Flags().BoolVar(&modelsRefreshFlag, "refresh", false, "Ignore the cached models list and fetch a fresh one")
 }
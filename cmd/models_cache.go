@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/internal/utils"
+	"github.com/flacial/llm/pkg/llm"
+	"github.com/spf13/viper"
+)
+
+type modelsCache struct {
+	FetchedAt time.Time         `json:"fetched_at"`
+	Models    []OpenRouterModel `json:"models"`
+}
+
+// getModelsCachePath returns the cache file for the configured base URL. Each endpoint serves its
+// own models, so OpenRouter's list must never be used to judge a local server's model names
+func getModelsCachePath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory for cache: %w", err)
+	}
+
+	name := "models.json"
+	if baseURL := getBaseURL(); baseURL != llm.OpenRouterBaseURL {
+		sum := sha256.Sum256([]byte(baseURL))
+		name = fmt.Sprintf("models-%x.json", sum[:8])
+	}
+
+	return filepath.Join(homeDir, ".llm", "cache", name), nil
+}
+
+// readModelsCache returns whatever is cached regardless of its age, so offline features like
+// shell completion still have something to work with
+func readModelsCache() (*modelsCache, error) {
+	cachePath, err := getModelsCachePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var cache modelsCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("failed to parse models cache: %w", err)
+	}
+
+	return &cache, nil
+}
+
+func writeModelsCache(models []OpenRouterModel) error {
+	cachePath, err := getModelsCachePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	data, err := json.Marshal(modelsCache{FetchedAt: time.Now(), Models: models})
+	if err != nil {
+		return fmt.Errorf("failed to encode models cache: %w", err)
+	}
+
+	return os.WriteFile(cachePath, data, 0644)
+}
+
+// loadModels serves the models list from the cache while it's younger than models.cache_ttl and
+// goes to the network otherwise. A stale cache still beats failing when OpenRouter is unreachable
+func loadModels(apiKey string, refresh bool) ([]OpenRouterModel, error) {
+	ttl := viper.GetDuration("models.cache_ttl")
+
+	cache, cacheErr := readModelsCache()
+	if cacheErr != nil && !os.IsNotExist(cacheErr) {
+		log.Logger.Debug().Err(cacheErr).Msg("Ignoring unreadable models cache.")
+	}
+
+	if !refresh && ttl > 0 && cacheErr == nil && time.Since(cache.FetchedAt) < ttl {
+		log.Logger.Debug().Time("fetched_at", cache.FetchedAt).Msg("Using cached models list.")
+		return cache.Models, nil
+	}
+
+	models, err := fetchModels(apiKey)
+	if err != nil {
+		if !refresh && cacheErr == nil {
+			log.Logger.Warn().Err(err).Time("fetched_at", cache.FetchedAt).Msg("Failed to fetch models. Using stale cache.")
+			return cache.Models, nil
+		}
+		return nil, err
+	}
+
+	if ttl > 0 {
+		if err := writeModelsCache(models); err != nil {
+			log.Logger.Warn().Err(err).Msg("Failed to write models cache.")
+		}
+	}
+
+	return models, nil
+}
+
+// validateModel rejects a model ID that isn't in the cached models list, suggesting the closest
+// known ID or alias. It never touches the network: without a cache, or with models.validate
+// turned off, every model is accepted and the API gets the final say
+func validateModel(model string) error {
+	if !viper.GetBool("models.validate") {
+		return nil
+	}
+
+	cache, err := readModelsCache()
+	if err != nil || len(cache.Models) == 0 {
+		return nil
+	}
+
+	candidates := make([]string, 0, len(cache.Models))
+	for _, known := range cache.Models {
+		if known.ID == model {
+			return nil
+		}
+		candidates = append(candidates, known.ID)
+	}
+
+	for alias := range viper.GetStringMapString("models.aliases") {
+		candidates = append(candidates, alias)
+	}
+
+	if suggestion := closestMatch(model, candidates); suggestion != "" {
+		return fmt.Errorf("unknown model %q; did you mean %q?", model, suggestion)
+	}
+
+	return fmt.Errorf("unknown model %q; run 'llm models' to see the available ones", model)
+}
+
+// closestMatch picks the candidate with the smallest edit distance, as long as it's close enough
+// to plausibly be what was meant
+func closestMatch(target string, candidates []string) string {
+	best, bestDistance := "", -1
+	for _, candidate := range candidates {
+		distance := utils.Levenshtein(strings.ToLower(target), strings.ToLower(candidate))
+		if bestDistance == -1 || distance < bestDistance || (distance == bestDistance && candidate < best) {
+			best, bestDistance = candidate, distance
+		}
+	}
+
+	if bestDistance == -1 || bestDistance > max(2, len(target)/3) {
+		return ""
+	}
+
+	return best
+}
+
+// findCachedModel looks a model up in the cached models list without touching the network
+func findCachedModel(id string) (*OpenRouterModel, bool) {
+	cache, err := readModelsCache()
+	if err != nil {
+		return nil, false
+	}
+
+	for i := range cache.Models {
+		if cache.Models[i].ID == id {
+			return &cache.Models[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// modelSupportsInput reports whether the cached models list says model accepts the given input
+// modality. known is false when the model (or the cache) isn't there to ask
+func modelSupportsInput(model, modality string) (supported, known bool) {
+	cached, found := findCachedModel(model)
+	if !found {
+		return false, false
+	}
+
+	return slices.Contains(cached.Architecture.InputModalities, modality), true
+}
@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/glamour/styles"
+	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/internal/utils"
+	"github.com/flacial/llm/pkg/llm"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	ansiDim   = "\x1b[2m"
+	ansiReset = "\x1b[0m"
+)
+
+// dimWriter fades everything written through it so reasoning reads as secondary to the answer
+type dimWriter struct {
+	out io.Writer
+}
+
+func (w dimWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, ansiDim); err != nil {
+		return 0, err
+	}
+
+	n, err := w.out.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	_, err = io.WriteString(w.out, ansiReset)
+	return n, err
+}
+
+// reasoningWriter returns where reasoning should be printed, dimmed only when stdout is a terminal
+// so escape codes don't leak into pipes
+func reasoningWriter() io.Writer {
+	return dimmedUnlessPlain(os.Stdout, isatty.IsTerminal(os.Stdout.Fd()))
+}
+
+// dimmedUnlessPlain dims out when it's a terminal, unless colors are disabled
+func dimmedUnlessPlain(out io.Writer, terminal bool) io.Writer {
+	if terminal && !colorDisabled() {
+		return dimWriter{out: out}
+	}
+
+	return out
+}
+
+// resetTerminalColors switches off any color or dimming a cut off stream left on, so the shell
+// prompt comes back looking normal. Only terminals get the escape code
+func resetTerminalColors(f *os.File) {
+	if isatty.IsTerminal(f.Fd()) {
+		io.WriteString(f, ansiReset)
+	}
+}
+
+func printReasoning(w io.Writer, reasoning string) {
+	fmt.Fprintf(w, "Reasoning:\n%s\n\n", reasoning)
+}
+
+// openOutputFile creates the --output-file, along with its parent directories. "-" stands for
+// stdout, which gets the response anyway, so there's no file to open and nil is returned
+func openOutputFile(path string) (*os.File, error) {
+	if path == "" || path == "-" {
+		return nil, nil
+	}
+	path = utils.ExpandPath(path)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for output file %q: %w", path, err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file %q: %w", path, err)
+	}
+
+	return file, nil
+}
+
+// rawOutput reports whether completions should be printed verbatim. --raw (or raw in the config)
+// decides when given, otherwise anything not going to a terminal stays raw
+func rawOutput() bool {
+	if viper.IsSet("raw") {
+		return viper.GetBool("raw")
+	}
+
+	return !isatty.IsTerminal(os.Stdout.Fd())
+}
+
+// streamingMode reports whether the answer is streamed. An explicit --stream-mode decides,
+// otherwise only terminals get it streamed, since a pipe gains nothing from progressive output and
+// is better off with the whole answer at once
+func streamingMode(cmd *cobra.Command) bool {
+	if cmd.Flags().Changed("stream-mode") {
+		return streamingModeFlag
+	}
+
+	return isatty.IsTerminal(os.Stdout.Fd())
+}
+
+// echoPrefill reports whether the --prefill text is printed ahead of the answer, which is the
+// default since the model's reply alone starts mid-sentence
+func echoPrefill() bool {
+	return !viper.IsSet("prefill.echo") || viper.GetBool("prefill.echo")
+}
+
+// renderMarkdown styles a completion for the terminal with the configured glamour style
+func renderMarkdown(content string) (string, error) {
+	return glamour.Render(content, renderStyle())
+}
+
+// renderResponse renders a completion for the terminal. A completion that's entirely a JSON object
+// or array is indented and highlighted as a json code block instead of being treated as markdown.
+// expectJSON (structured output) does that regardless of render.autodetect_json, and prints
+// anything that isn't JSON as is
+func renderResponse(content string, expectJSON bool) (string, error) {
+	if expectJSON || viper.GetBool("render.autodetect_json") {
+		if indented, ok := indentJSON(content); ok {
+			return renderMarkdown("```json\n" + indented + "\n```")
+		}
+	}
+
+	if expectJSON {
+		return content, nil
+	}
+
+	return renderMarkdown(content)
+}
+
+// indentJSON pretty-prints content when it's a JSON object or array. Bare strings and numbers are
+// valid JSON too, but a one word answer shouldn't turn into a code block
+func indentJSON(content string) (string, bool) {
+	trimmed := strings.TrimSpace(content)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return "", false
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(trimmed), "", "  "); err != nil {
+		return "", false
+	}
+
+	return buf.String(), true
+}
+
+// colorDisabled reports whether --no-color, no_color in the config, or a non-empty NO_COLOR
+// (https://no-color.org) asks for output without colors
+func colorDisabled() bool {
+	return viper.GetBool("no_color") || os.Getenv("NO_COLOR") != ""
+}
+
+// renderStyle picks the glamour style from render.style. Without one, piped output gets notty so
+// no escape codes end up in files. Unknown names that aren't a path to a JSON style fall back to
+// auto, and disabling colors overrides any of them with notty
+func renderStyle() string {
+	if colorDisabled() {
+		return styles.NoTTYStyle
+	}
+
+	style := viper.GetString("render.style")
+	if style == "" {
+		if !isatty.IsTerminal(os.Stdout.Fd()) {
+			return styles.NoTTYStyle
+		}
+		return styles.AutoStyle
+	}
+
+	if style == styles.AutoStyle {
+		return style
+	}
+
+	if _, found := styles.DefaultStyles[style]; found {
+		return style
+	}
+
+	if info, err := os.Stat(utils.ExpandPath(style)); err == nil && !info.IsDir() {
+		return utils.ExpandPath(style)
+	}
+
+	log.Logger.Warn().Str("style", style).Strs("available", renderStyleNames()).Msg("Unknown render style, falling back to auto.")
+	return styles.AutoStyle
+}
+
+func renderStyleNames() []string {
+	names := []string{styles.AutoStyle}
+	for name := range styles.DefaultStyles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func completeRenderStyles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	// Custom styles are JSON files, so keep file completion around
+	return renderStyleNames(), cobra.ShellCompDirectiveDefault
+}
+
+// printFinishNotice tells the user when the answer didn't end on its own, since a cut off
+// response is easy to mistake for a complete one. --quiet silences it along with the warnings
+func printFinishNotice(w io.Writer, finishReason string) {
+	if viper.GetBool("quiet") {
+		return
+	}
+
+	switch finishReason {
+	case "length":
+		fmt.Fprintln(w, "Response truncated: it hit the token limit. Increase --max-tokens to get the rest.")
+	case "content_filter":
+		fmt.Fprintln(w, "Response stopped by the provider's content filter.")
+	}
+}
+
+// printModelNote tells the user which model answers, and which alias it came from, since a
+// template or an alias can make that different from what was typed. --quiet silences it
+func printModelNote(w io.Writer, requested, model string) {
+	if viper.GetBool("quiet") {
+		return
+	}
+
+	if requested != model && resolveModel(requested) == model {
+		fmt.Fprintf(w, "Model: %s (alias %s)\n", model, requested)
+		return
+	}
+
+	fmt.Fprintf(w, "Model: %s\n", model)
+}
+
+// printRequestBody shows exactly what --dry-run would have sent. The API key travels in a header,
+// so there's nothing to redact
+func printRequestBody(w io.Writer, body llm.ChatCompletionRequest) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(body); err != nil {
+		return fmt.Errorf("failed to encode request body: %w", err)
+	}
+
+	return nil
+}
+
+// copyResponse copies the response when --copy or --copy-code asked for it. --copy-code copies
+// just the code blocks, joined by newlines so several shell commands paste as a script
+func copyResponse(content string) {
+	if !viper.GetBool("always_copy") && !copyCodeFlag {
+		return
+	}
+
+	if copyCodeFlag {
+		if blocks := utils.ExtractCodeBlocks(content); len(blocks) > 0 {
+			content = strings.Join(blocks, "\n")
+		} else {
+			log.Logger.Warn().Msg("No code blocks in the response, copying all of it.")
+		}
+	}
+
+	log.Logger.Info().Msg("Copying to clipboard...")
+	if err := utils.CopyToClipboard(content, viper.GetString("clipboard.backend")); err != nil {
+		log.Logger.Warn().Err(err).Msg("Error copying to clipboard")
+	}
+}
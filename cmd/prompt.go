@@ -1,66 +1,394 @@
 package cmd
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/internal/utils"
+	"github.com/flacial/llm/pkg/llm"
+	"github.com/spf13/viper"
 )
 
-func getPromptContent(cliArgs []string, promptFilePath string) (string, error) {
-	var finalPrompt string
-	var stdinContent string
+// stdinSource is the part of *os.File that prompt reading needs, so tests can stand in for stdin
+type stdinSource interface {
+	io.Reader
+	Stat() (os.FileInfo, error)
+}
+
+var promptStdin stdinSource = os.Stdin
+
+// promptClipboard reads the clipboard for --from-clipboard, swapped out in tests
+var promptClipboard = utils.ReadFromClipboard
+
+// How long to wait for piped stdin to produce anything when there's already a prompt from the
+// arguments. Some CI runners attach a pipe that never gets written to or closed, and every run
+// there pays this wait, so it's only long enough for a command piping in to get started
+var stdinWaitTimeout = 500 * time.Millisecond
+
+// Values for prompt.merge_order. file_first lets a prompt file replace the arguments and stdin,
+// while args_first treats the arguments as the instruction and appends stdin and the file to it
+const (
+	promptMergeFileFirst = "file_first"
+	promptMergeArgsFirst = "args_first"
+)
+
+// promptContent is the prompt both merged and split by source, so templates can place stdin and
+// the arguments separately
+type promptContent struct {
+	// What's sent when there's no template, combined according to prompt.merge_order
+	Merged string
+	Args   string
+	Stdin  string
+	File   string
+}
 
-	stats, _ := os.Stdin.Stat()
-	// Check if stdin is piped
-	// We doing bitwise ops baby!
-	if (stats.Mode() & os.ModeCharDevice) == 0 {
-		stdinBytes, err := io.ReadAll(os.Stdin)
+// getPromptContent assembles the prompt from the file, stdin, and arguments. forceStdin (--stdin,
+// or a lone "-" argument) reads stdin even when it doesn't look piped. With --from-clipboard, the
+// clipboard counts as input too, following stdin when there's both
+func getPromptContent(cliArgs []string, promptFilePath string, forceStdin bool) (promptContent, error) {
+	if len(cliArgs) == 1 && cliArgs[0] == "-" {
+		cliArgs = nil
+		forceStdin = true
+	}
+
+	cliPrompt := strings.TrimSpace(strings.Join(cliArgs, " "))
+
+	clipboardContent := ""
+	if fromClipboardFlag {
+		content, err := promptClipboard()
 		if err != nil {
-			return "", fmt.Errorf("error reading stdin: %w", err)
+			return promptContent{}, err
 		}
+		clipboardContent = strings.TrimSpace(content)
+	}
 
-		stdinContent = strings.TrimSpace(string(stdinBytes))
+	stdinContent, err := readStdin(forceStdin, cliPrompt != "" || promptFilePath != "" || clipboardContent != "")
+	if err != nil {
+		return promptContent{}, err
+	}
+	if maxBytes := inputByteLimit(); maxBytes > 0 && len(stdinContent) > maxBytes {
+		return promptContent{}, fmt.Errorf("stdin is %d bytes, over the %d byte limit (raise max_input_bytes or pass --force to send it anyway)", len(stdinContent), maxBytes)
+	}
+	if maxBytes := inputByteLimit(); maxBytes > 0 && len(clipboardContent) > maxBytes {
+		return promptContent{}, fmt.Errorf("clipboard is %d bytes, over the %d byte limit (raise max_input_bytes or pass --force to send it anyway)", len(clipboardContent), maxBytes)
 	}
+	stdinContent = joinPromptParts(stdinContent, clipboardContent)
 
 	fileContent := ""
 	if promptFilePath != "" {
-		fileBytes, err := os.ReadFile(promptFilePath)
+		content, err := readInputFile(promptFilePath)
 		if err != nil {
-			return "", fmt.Errorf("error reading prompt file %q: %w", promptFilePath, err)
+			return promptContent{}, fmt.Errorf("error reading prompt file %q: %w", promptFilePath, err)
 		}
 
-		fileContent = strings.TrimSpace(string(fileBytes))
+		fileContent = strings.TrimSpace(content)
 	}
 
-	cliPrompt := strings.TrimSpace(strings.Join(cliArgs, " "))
+	prompt := promptContent{Args: cliPrompt, Stdin: stdinContent, File: fileContent}
+	prompt.Merged, err = mergePrompt(prompt, viper.GetString("prompt.merge_order"))
+	if err != nil {
+		return promptContent{}, err
+	}
+
+	return prompt, nil
+}
+
+// mergePrompt combines the parts of the prompt into what's sent to the model
+func mergePrompt(prompt promptContent, order string) (string, error) {
+	if prompt.File == "" && prompt.Stdin == "" && prompt.Args == "" {
+		return "", &UsageError{errors.New("no prompt provided. Use 'llm \"your prompt\"', pipe input, or specify a file with -f")}
+	}
+
+	switch order {
+	case "", promptMergeFileFirst:
+		// The file replaces everything else, otherwise the arguments lead and stdin follows
+		if prompt.File != "" {
+			if prompt.Args != "" || prompt.Stdin != "" {
+				log.Logger.Warn().Msg("Warning: File content takes precedence. CLI arguments and stdin will be ignored. Set prompt.merge_order to args_first to combine them.")
+			}
+			return prompt.File, nil
+		}
+
+		log.Logger.Info().Bool("args", prompt.Args != "").Bool("stdin", prompt.Stdin != "").Msg("Using CLI prompt and stdin content")
+		return joinPromptParts(prompt.Args, prompt.Stdin), nil
+	case promptMergeArgsFirst:
+		log.Logger.Info().Bool("args", prompt.Args != "").Bool("stdin", prompt.Stdin != "").Bool("file", prompt.File != "").Msg("Using CLI prompt with stdin and file content as context")
+		return joinPromptParts(prompt.Args, prompt.Stdin, prompt.File), nil
+	default:
+		return "", fmt.Errorf("invalid prompt.merge_order %q: must be %s or %s", order, promptMergeFileFirst, promptMergeArgsFirst)
+	}
+}
+
+// joinPromptParts separates the non-empty parts with a blank line
+func joinPromptParts(parts ...string) string {
+	var nonEmpty []string
+	for _, part := range parts {
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+
+	return strings.Join(nonEmpty, "\n\n")
+}
+
+// readStdin returns piped stdin, or nothing when stdin is a terminal. A stdin that can't even be
+// inspected is treated like a terminal. When there's another prompt source to fall back on, a pipe
+// that stays silent past stdinWaitTimeout is ignored instead of hanging forever. A redirected file
+// can't hang, so it's read without the wait
+func readStdin(force, hasOtherPrompt bool) (string, error) {
+	regularFile := false
+	if !force {
+		stats, err := promptStdin.Stat()
+		if err != nil {
+			log.Logger.Debug().Err(err).Msg("Can't inspect stdin, not reading from it.")
+			return "", nil
+		}
 
-	// Determine final prompt based on this order: file > stdin > cli
-	if fileContent != "" {
-		finalPrompt = fileContent
+		// We doing bitwise ops baby!
+		if stats.Mode()&os.ModeCharDevice != 0 {
+			return "", nil
+		}
+		regularFile = stats.Mode().IsRegular()
+	}
 
-		if cliPrompt != "" || stdinContent != "" {
-			log.Logger.Warn().Msg("Warning: File content takes precedence. CLI arguments and stdin will be ignored.")
+	if force || !hasOtherPrompt || regularFile {
+		stdinBytes, err := io.ReadAll(promptStdin)
+		if err != nil {
+			return "", fmt.Errorf("error reading stdin: %w", err)
 		}
-	} else if stdinContent != "" && cliPrompt != "" {
-		log.Logger.Info().Msg("Using stdin content and CLI prompt")
-		finalPrompt = cliPrompt + "\n\n" + stdinContent
-	} else if stdinContent != "" {
-		log.Logger.Info().Msg("Using stdin content")
-		finalPrompt = stdinContent
-	} else if cliPrompt != "" {
-		log.Logger.Info().Msg("Using CLI prompt")
-		finalPrompt = cliPrompt
-	} else {
-		return "", errors.New("no prompt provided. Use 'llm \"your prompt\"', pipe input, or specify a file with -f")
+
+		return strings.TrimSpace(string(stdinBytes)), nil
+	}
+
+	type readResult struct {
+		data []byte
+		err  error
+	}
+
+	// Only the wait for the first bytes is bounded, a slow producer is still read to the end
+	firstRead := make(chan readResult, 1)
+	go func() {
+		buf := make([]byte, 32*1024)
+		n, err := promptStdin.Read(buf)
+		firstRead <- readResult{buf[:n], err}
+	}()
+
+	var first readResult
+	select {
+	case first = <-firstRead:
+	case <-time.After(stdinWaitTimeout):
+		log.Logger.Warn().Dur("waited", stdinWaitTimeout).Msg("Nothing arrived on piped stdin, ignoring it. Pass --stdin to wait for it.")
+		return "", nil
+	}
+
+	if first.err != nil {
+		if errors.Is(first.err, io.EOF) {
+			return strings.TrimSpace(string(first.data)), nil
+		}
+		return "", fmt.Errorf("error reading stdin: %w", first.err)
+	}
+
+	rest, err := io.ReadAll(promptStdin)
+	if err != nil {
+		return "", fmt.Errorf("error reading stdin: %w", err)
+	}
+
+	return strings.TrimSpace(string(first.data) + string(rest)), nil
+}
+
+// getSystemPromptContent returns the --system value as is, unless it starts with @ in which case
+// the rest is treated as a path to read the system prompt from
+func getSystemPromptContent(value string) (string, error) {
+	systemPromptPath, isFile := strings.CutPrefix(value, "@")
+	if !isFile {
+		return strings.TrimSpace(value), nil
+	}
+
+	fileBytes, err := os.ReadFile(utils.ExpandPath(systemPromptPath))
+	if err != nil {
+		return "", fmt.Errorf("error reading system prompt file %q: %w", systemPromptPath, err)
+	}
+
+	systemPrompt := strings.TrimSpace(string(fileBytes))
+	if systemPrompt == "" {
+		return "", fmt.Errorf("system prompt file %q is empty", systemPromptPath)
+	}
+
+	return systemPrompt, nil
+}
+
+// defaultSystemPrompt is the default_system_prompt from the config, which can be an @path too
+func defaultSystemPrompt() (string, error) {
+	value := viper.GetString("default_system_prompt")
+	if value == "" {
+		return "", nil
 	}
 
-	if finalPrompt == "" {
-		return "", errors.New("prompt cannot be empty after combining inputs")
+	systemPrompt, err := getSystemPromptContent(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid default_system_prompt: %w", err)
+	}
+
+	return systemPrompt, nil
+}
+
+// resolveSystemPrompt picks the system prompt to send with a new message. --system replaces the
+// template's system message rather than stacking on top of it, and the configured default only
+// fills in when neither set one and the conversation doesn't already have one
+func resolveSystemPrompt(fromTemplate string, hasSystemPrompt bool) (string, error) {
+	switch {
+	case noSystemFlag:
+		log.Logger.Debug().Msg("Leaving out the system prompt for --no-system.")
+		return "", nil
+	case systemFlag != "":
+		systemPrompt, err := getSystemPromptContent(systemFlag)
+		if err != nil {
+			return "", err
+		}
+		log.Logger.Debug().Msg("Using system prompt from --system.")
+		return systemPrompt, nil
+	case fromTemplate == "" && !hasSystemPrompt:
+		return defaultSystemPrompt()
+	}
+
+	return fromTemplate, nil
+}
+
+// checkSystemFlags rejects asking for a system prompt and for none in the same run
+func checkSystemFlags() error {
+	if noSystemFlag && systemFlag != "" {
+		return &UsageError{errors.New("--system and --no-system can't be used together")}
+	}
+
+	return nil
+}
+
+// readTextFile reads a file that's meant to end up in a prompt, refusing binary files since
+// they'd only waste tokens
+func readTextFile(path string) (string, error) {
+	fileBytes, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	if isBinary(fileBytes) {
+		return "", errors.New("file looks binary")
+	}
+
+	return string(fileBytes), nil
+}
+
+// readInputFile is readTextFile for files given as input on the command line, which are checked
+// against max_input_bytes before being read so a wrong path can't balloon the request
+func readInputFile(path string) (string, error) {
+	path = utils.ExpandPath(path)
+	if maxBytes := inputByteLimit(); maxBytes > 0 {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		if info.Size() > int64(maxBytes) {
+			return "", fmt.Errorf("file is %d bytes, over the %d byte limit (raise max_input_bytes or pass --force to send it anyway)", info.Size(), maxBytes)
+		}
+	}
+
+	return readTextFile(path)
+}
+
+// inputByteLimit is the most a single input file or stdin may hold, 0 for no limit
+func inputByteLimit() int {
+	if forceFlag {
+		return 0
+	}
+
+	return viper.GetInt("max_input_bytes")
+}
+
+// isBinary uses the same heuristic as git: a NUL byte early on means binary. Invalid UTF-8 is
+// treated as binary too since it can't be sent as text anyway
+func isBinary(data []byte) bool {
+	sniff := data
+	if len(sniff) > 8000 {
+		sniff = sniff[:8000]
+	}
+
+	for _, b := range sniff {
+		if b == 0 {
+			return true
+		}
+	}
+
+	return !utf8.Valid(data)
+}
+
+// getAttachments reads the --attach files into fenced blocks headed by their path, one per file.
+// Each file is held to max_input_bytes and maxBytes caps the combined size, both checked before
+// the file is read so a huge one isn't loaded just to be refused
+func getAttachments(paths []string, maxBytes int) ([]string, error) {
+	attachments := make([]string, 0, len(paths))
+	var totalBytes int64
+
+	for _, path := range paths {
+		expandedPath := utils.ExpandPath(path)
+		info, err := os.Stat(expandedPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading attachment %q: %w", path, err)
+		}
+
+		if maxInputBytes := inputByteLimit(); maxInputBytes > 0 && info.Size() > int64(maxInputBytes) {
+			return nil, fmt.Errorf("attachment %q is %d bytes, over the %d byte limit (raise max_input_bytes or pass --force to send it anyway)", path, info.Size(), maxInputBytes)
+		}
+
+		totalBytes += info.Size()
+		if maxBytes > 0 && totalBytes > int64(maxBytes) {
+			return nil, fmt.Errorf("attachments exceed the %d byte limit at %q (raise attach.max_bytes or pass --force to allow more)", maxBytes, path)
+		}
+
+		content, err := readTextFile(expandedPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading attachment %q: %w", path, err)
+		}
+
+		// A fence longer than any inside the file keeps its own code blocks from ending ours
+		fence := "```"
+		for strings.Contains(content, fence) {
+			fence += "`"
+		}
+
+		language := strings.TrimPrefix(filepath.Ext(path), ".")
+		attachments = append(attachments, fmt.Sprintf("File: %s\n%s%s\n%s\n%s", path, fence, language, strings.TrimRight(content, "\n"), fence))
+	}
+
+	return attachments, nil
+}
+
+// getImageParts turns the --image files into base64 data URI parts for vision models
+func getImageParts(paths []string) ([]llm.ContentPart, error) {
+	parts := make([]llm.ContentPart, 0, len(paths))
+
+	for _, path := range paths {
+		imageBytes, err := os.ReadFile(utils.ExpandPath(path))
+		if err != nil {
+			return nil, fmt.Errorf("error reading image %q: %w", path, err)
+		}
+
+		mediaType := http.DetectContentType(imageBytes)
+		if !strings.HasPrefix(mediaType, "image/") {
+			return nil, fmt.Errorf("%q doesn't look like an image (detected %s)", path, mediaType)
+		}
+
+		dataURI := "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(imageBytes)
+		parts = append(parts, llm.NewImagePart(dataURI))
 	}
 
-	return finalPrompt, nil
+	return parts, nil
 }
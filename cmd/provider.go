@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"github.com/flacial/llm/pkg/llm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// providerPreferences merges provider routing from the config, the template, and the flags, in
+// increasing order of precedence. nil means nothing was set, so no provider object is sent
+func providerPreferences(cmd *cobra.Command, fromTemplate *llm.ProviderPreferences) *llm.ProviderPreferences {
+	preferences := llm.ProviderPreferences{
+		Order:          viper.GetStringSlice("provider.order"),
+		DataCollection: viper.GetString("provider.data_collection"),
+	}
+	if viper.IsSet("provider.allow_fallbacks") {
+		allowFallbacks := viper.GetBool("provider.allow_fallbacks")
+		preferences.AllowFallbacks = &allowFallbacks
+	}
+	if viper.IsSet("provider.require_parameters") {
+		requireParameters := viper.GetBool("provider.require_parameters")
+		preferences.RequireParameters = &requireParameters
+	}
+
+	if fromTemplate != nil {
+		if len(fromTemplate.Order) > 0 {
+			preferences.Order = fromTemplate.Order
+		}
+		if fromTemplate.AllowFallbacks != nil {
+			preferences.AllowFallbacks = fromTemplate.AllowFallbacks
+		}
+		if fromTemplate.RequireParameters != nil {
+			preferences.RequireParameters = fromTemplate.RequireParameters
+		}
+		if fromTemplate.DataCollection != "" {
+			preferences.DataCollection = fromTemplate.DataCollection
+		}
+	}
+
+	if cmd.Flags().Changed("provider-order") {
+		preferences.Order = providerOrderFlag
+	}
+	if cmd.Flags().Changed("no-fallbacks") {
+		allowFallbacks := !noFallbacksFlag
+		preferences.AllowFallbacks = &allowFallbacks
+	}
+	if cmd.Flags().Changed("require-params") {
+		requireParameters := requireParamsFlag
+		preferences.RequireParameters = &requireParameters
+	}
+	if cmd.Flags().Changed("data-collection") {
+		preferences.DataCollection = dataCollectionFlag
+	}
+
+	if preferences.IsZero() {
+		return nil
+	}
+
+	return &preferences
+}
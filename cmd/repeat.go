@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/flacial/llm/internal/history"
+	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/pkg/llm"
+)
+
+var repeatFlag bool
+
+// checkRepeatFlags rejects --repeat alongside anything that shapes a new prompt or request, since
+// the saved one is sent as it was. Only -m and --temperature may change it
+func checkRepeatFlags(args []string) error {
+	if len(args) > 0 {
+		return &UsageError{errors.New("--repeat sends the last prompt again and doesn't take a new one")}
+	}
+
+	conflicts := map[string]bool{
+		"--prompt-file":       promptFileFlag != "",
+		"--stdin":             stdinFlag,
+		"--from-clipboard":    fromClipboardFlag,
+		"--template":          templateFlag != "",
+		"--var":               len(templateVarsFlag) > 0,
+		"--attach":            len(attachFlag) > 0,
+		"--image":             len(imageFlag) > 0,
+		"--prefix":            prefixFlag != "",
+		"--suffix":            suffixFlag != "",
+		"--system":            systemFlag != "",
+		"--no-system":         noSystemFlag,
+		"--continue":          continueFlag,
+		"--batch":             batchFlag != "",
+		"--max-tokens":        maxTokensFlag != 0,
+		"--top-p":             topPFlag != 0,
+		"--frequency-penalty": frequencyPenaltyFlag != 0,
+		"--presence-penalty":  presencePenaltyFlag != 0,
+		"--stop":              len(stopFlag) > 0,
+		"--seed":              seedFlag != 0,
+		"--choices":           choicesFlag > 1,
+		"--prefill":           prefillFlag != "",
+		"--json":              jsonFlag,
+		"--json-schema":       jsonSchemaFlag != "",
+		"--tools":             toolsFlag != "",
+		"--web":               webFlag,
+		"--fallback-model":    len(fallbackModelFlag) > 0,
+		"--provider-order":    len(providerOrderFlag) > 0,
+		"--no-fallbacks":      noFallbacksFlag,
+		"--require-params":    requireParamsFlag,
+		"--data-collection":   dataCollectionFlag != "",
+	}
+
+	var names []string
+	for name, set := range conflicts {
+		if set {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		slices.Sort(names)
+		return &UsageError{fmt.Errorf("--repeat can't be combined with %s", strings.Join(names, ", "))}
+	}
+
+	return nil
+}
+
+// loadLastRequest reads back the request saved by the previous run
+func loadLastRequest() (*history.LastRequest, error) {
+	historyDirPath, err := getHistoryDirPath()
+	if err != nil {
+		return nil, err
+	}
+
+	lastRequest, err := history.LoadLastRequest(historyDirPath)
+	if errors.Is(err, history.ErrNoLastRequest) {
+		return nil, &UsageError{errors.New("no previous prompt to repeat yet, send one first")}
+	}
+
+	return lastRequest, err
+}
+
+// saveLastRequest keeps what's about to be sent so --repeat can send it again. Whether it streams
+// is decided again on each run, so that's left out. A failure only costs the next --repeat, so
+// it's logged rather than returned
+func saveLastRequest(request llm.ChatCompletionRequest) {
+	request.Stream = false
+	request.StreamOptions = nil
+
+	historyDirPath, err := getHistoryDirPath()
+	if err == nil {
+		err = history.SaveLastRequest(historyDirPath, &history.LastRequest{ChatCompletionRequest: request})
+	}
+	if err != nil {
+		log.Logger.Warn().Err(err).Msg("Failed to save the request for --repeat")
+	}
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/internal/utils"
+	"github.com/flacial/llm/pkg/llm"
+)
+
+// OpenRouter only accepts these characters in a schema name
+var schemaNameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// getResponseFormat builds the response_format for --json or --json-schema, or nil when neither
+// was given
+func getResponseFormat(jsonMode bool, schemaPath string) (*llm.ResponseFormat, error) {
+	if jsonMode && schemaPath != "" {
+		return nil, errors.New("--json and --json-schema can't be used together, --json-schema already implies JSON")
+	}
+
+	if jsonMode {
+		return llm.NewJSONObjectFormat(), nil
+	}
+
+	if schemaPath == "" {
+		return nil, nil
+	}
+
+	schema, err := os.ReadFile(utils.ExpandPath(schemaPath))
+	if err != nil {
+		return nil, fmt.Errorf("error reading JSON schema %q: %w", schemaPath, err)
+	}
+
+	if !json.Valid(schema) {
+		return nil, fmt.Errorf("JSON schema %q isn't valid JSON", schemaPath)
+	}
+
+	// The file name doubles as the schema name, e.g. person.schema.json becomes "person"
+	name := strings.SplitN(filepath.Base(schemaPath), ".", 2)[0]
+	name = schemaNameUnsafe.ReplaceAllString(name, "_")
+	if name == "" {
+		name = "response"
+	}
+
+	return llm.NewJSONSchemaFormat(name, json.RawMessage(schema)), nil
+}
+
+// warnIfNotJSON flags structured output that didn't come back as JSON, which happens with models
+// that ignore response_format
+func warnIfNotJSON(content string) {
+	if json.Valid([]byte(strings.TrimSpace(content))) {
+		return
+	}
+
+	log.Logger.Warn().Msg("The response isn't valid JSON. The model may not support structured output.")
+}
@@ -6,22 +6,22 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
+	"time"
 
-	"github.com/charmbracelet/glamour"
-	"github.com/flacial/llm/internal/llm"
+	"github.com/flacial/llm/internal/history"
 	"github.com/flacial/llm/internal/log"
 	"github.com/flacial/llm/internal/templating"
 	"github.com/flacial/llm/internal/utils"
+	"github.com/flacial/llm/pkg/llm"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"golang.org/x/net/context"
-	"gopkg.in/yaml.v3"
 )
 
 //go:embed default-templates/*
@@ -32,17 +32,71 @@ var cfgFile string
 var modelFlag string
 var apiKeyFlag string
 var copyToClipboardFlag bool
+var fromClipboardFlag bool
+var copyCodeFlag bool
 var promptFileFlag string
 var streamingModeFlag bool
 var formatOutputFlag bool
 var verboseFlag bool
 var logFileFlag string
 var debugMode bool
+var quietFlag bool
+var noColorFlag bool
+var listAliasesFlag bool
+var showModelFlag bool
+var forceFlag bool
+var caCertFlag string
 var templateFlag string
-
-// It's a global variable to allow easy mocking in tests by direct assignment
-var httpClient llm.HTTPClient = &http.Client{
-	Timeout: llm.DefaultTimeout,
+var maxTokensFlag int
+var temperatureFlag float64
+var topPFlag float64
+var frequencyPenaltyFlag float64
+var presencePenaltyFlag float64
+var timeoutFlag time.Duration
+var requestTimeoutFlag time.Duration
+var continueFlag bool
+var newConversationFlag bool
+var showReasoningFlag bool
+var usageFlag bool
+var systemFlag string
+var noSystemFlag bool
+var templateVarsFlag []string
+var profileFlag string
+var baseURLFlag string
+var styleFlag string
+var rawFlag bool
+var attachFlag []string
+var imageFlag []string
+var stopFlag []string
+var seedFlag int
+var truncateFlag bool
+var outputFileFlag string
+var dryRunFlag bool
+var stdinFlag bool
+var jsonFlag bool
+var jsonSchemaFlag string
+var providerOrderFlag []string
+var noFallbacksFlag bool
+var requireParamsFlag bool
+var dataCollectionFlag string
+var fallbackModelFlag []string
+var batchFlag string
+var batchConcurrencyFlag int
+var batchOutputFlag string
+var batchDelimiterFlag string
+var tempSweepFlag []float64
+var prefixFlag string
+var suffixFlag string
+var prefillFlag string
+var choicesFlag int
+var copyChoiceFlag int
+
+// Built-in aliases, used until the config file defines its own models.aliases
+var defaultModelAliases = map[string]string{
+	"fast":  "openai/gpt-4.1-nano",
+	"10x":   "anthropic/claude-sonnet-4",
+	"smart": "google/gemini-2.5-pro",
+	"gpt4":  "openai/gpt-4o",
 }
 
 var rootCmd = &cobra.Command{
@@ -65,33 +119,125 @@ var rootCmd = &cobra.Command{
 			cancel()
 		}()
 
-		finalPrompt, err := getPromptContent(args, promptFileFlag)
+		if listAliasesFlag {
+			return runAliasListCommand(cmd, args)
+		}
+
+		if repeatFlag {
+			if err := checkRepeatFlags(args); err != nil {
+				return err
+			}
+		} else if batchFlag != "" {
+			return runBatch(ctx, cmd)
+		}
+
+		// A repeat sends the saved messages, so there's no prompt to read
+		var lastRequest *history.LastRequest
+		var prompt promptContent
+		var err error
+		if repeatFlag {
+			lastRequest, err = loadLastRequest()
+			if err != nil {
+				log.Logger.Error().Err(err).Msg("Failed to load the last request")
+				return err
+			}
+		} else {
+			prompt, err = getPromptContent(args, promptFileFlag, stdinFlag)
+			if err != nil {
+				log.Logger.Error().Err(err).Msg("Failed to get prompt content")
+				return err
+			}
+		}
+
+		attachmentByteLimit := viper.GetInt("attach.max_bytes")
+		if forceFlag {
+			attachmentByteLimit = 0
+		}
+
+		attachments, err := getAttachments(attachFlag, attachmentByteLimit)
 		if err != nil {
-			log.Logger.Error().Err(err).Msg("Failed to get prompt content")
+			log.Logger.Error().Err(err).Msg("Failed to read attachments")
 			return err
 		}
 
-		requestedModel := viper.GetString("model")
-		aliases := viper.GetStringMapString("models.aliases")
+		responseFormat, err := getResponseFormat(jsonFlag, jsonSchemaFlag)
+		if err != nil {
+			log.Logger.Error().Err(err).Msg("Failed to set up structured output")
+			return err
+		}
 
-		resolvedModel := requestedModel
-		if aliasToFull, found := aliases[requestedModel]; found {
-			resolvedModel = aliasToFull
+		resolvedModel := resolveModel(viper.GetString("model"))
 
-			if viper.GetBool("verbose") {
-				log.Logger.Info().Str("alias", requestedModel).Str("model", resolvedModel).Msg("Using alias for model.")
+		// A dry run or mock never reaches the API, so it works without a key and doesn't run a key command
+		var apiKey string
+		if !dryRunFlag && !mockMode() {
+			apiKey, err = resolveAPIKey()
+			if err != nil {
+				log.Logger.Error().Err(err).Msg("Failed to resolve API key")
+				return err
 			}
-		} else {
-			log.Logger.Info().Str("model", resolvedModel).Msg("Using model.")
+
+			if apiKey == "" {
+				log.Logger.Fatal().Msg("API key not set. Please provide it via --api-key, environment variable (LLM_API_KEY or OPENROUTER_API_KEY), or in your config file") // Fatal if we want to exit immediately
+				return errors.New("api key not set")
+			}
+		}
+
+		if timeout := viper.GetDuration("timeout"); timeout < 0 {
+			return fmt.Errorf("invalid timeout %s: must not be negative", timeout)
+		}
+
+		requestTimeout := viper.GetDuration("request_timeout")
+		if requestTimeout < 0 {
+			return fmt.Errorf("invalid request timeout %s: must not be negative", requestTimeout)
+		}
+
+		if err := validateChoices(choicesFlag, copyChoiceFlag); err != nil {
+			return err
+		}
+
+		tools, err := loadTools(toolsFlag)
+		if err != nil {
+			return err
 		}
 
-		apiKey := viper.GetString("api_key")
-		if apiKey == "" {
-			log.Logger.Fatal().Msg("API key not set. Please provide it via --api-key, environment variable (OPENROUTER_API_KEY), or in ~/.llmrc.yaml") // Fatal if we want to exit immediately
-			return errors.New("api key not set")
+		// A repeat sends the saved request as it was, so its choices, tools, and response format
+		// decide how the answer comes back and is printed. A prefill it ended with is split off
+		// again to be shown and saved with the answer
+		choices := choicesFlag
+		prefill := prefillFlag
+		if lastRequest != nil {
+			responseFormat = lastRequest.ResponseFormat
+			tools = lastRequest.Tools
+			if lastRequest.N != nil {
+				choices = *lastRequest.N
+			}
+			if last := len(lastRequest.Messages) - 1; last >= 0 && lastRequest.Messages[last].Role == "assistant" {
+				prefill = lastRequest.Messages[last].Content
+				lastRequest.Messages = lastRequest.Messages[:last]
+			}
 		}
 
-		llmClient := llm.NewLLMClient(apiKey, httpClient, "")
+		// Streamed deltas of several choices would interleave, so they're waited for instead
+		useStreaming := streamingMode(cmd)
+		if choices > 1 && useStreaming {
+			log.Logger.Info().Int("choices", choices).Msg("Streaming isn't supported with --choices, waiting for the full answers.")
+			useStreaming = false
+		}
+		// Tool calls arrive in pieces when streamed, so wait for them whole
+		if len(tools) > 0 && useStreaming {
+			log.Logger.Info().Msg("Streaming isn't supported with --tools, waiting for the full answer.")
+			useStreaming = false
+		}
+		// The post-response hook needs the whole answer before anything can be printed
+		if viper.GetString(hookPostResponse) != "" && useStreaming {
+			log.Logger.Info().Msg("Streaming isn't supported with hooks.post_response, waiting for the full answer.")
+			useStreaming = false
+		}
+		llmClient := newLLMClient(apiKey, useStreaming)
+		if viper.GetBool("show_reasoning") {
+			llmClient.ReasoningWriter = reasoningWriter()
+		}
 
 		// 1. Read the template file from templateFlag variable
 		// 2. Use text/template to fill the user_prompt_template
@@ -100,41 +246,73 @@ var rootCmd = &cobra.Command{
 		// 5. (Optional) Add the model and temperature of the completion
 
 		var completionMessages []llm.ChatCompletionMessage
+		var systemMessage string
 		var finalResolvedModel = resolvedModel
-		var finalTemperature *float64
+		finalTemperature := optionalFloat(cmd, "temperature")
+		// The saved model and temperature are sent again unless -m or --temperature pick others
+		if lastRequest != nil {
+			if !cmd.Flags().Changed("model") {
+				finalResolvedModel = lastRequest.Model
+			}
+			if !cmd.Flags().Changed("temperature") {
+				finalTemperature = lastRequest.Temperature
+			}
+		}
+		var finalMaxTokens *int
+
+		if maxTokens := optionalInt(cmd, "max_tokens"); maxTokens != nil {
+			if *maxTokens <= 0 {
+				return fmt.Errorf("invalid max tokens %d: must be a positive integer", *maxTokens)
+			}
+			finalMaxTokens = maxTokens
+		}
+
+		finalTopP := optionalFloat(cmd, "top_p")
+		finalFrequencyPenalty := optionalFloat(cmd, "frequency_penalty")
+		finalPresencePenalty := optionalFloat(cmd, "presence_penalty")
+		finalSeed := optionalInt(cmd, "seed")
+
+		finalStop := viper.GetStringSlice("stop")
+		if cmd.Flags().Changed("stop") {
+			finalStop = stopFlag
+		}
 
+		var templateProvider *llm.ProviderPreferences
+		var selectedTemplate *templating.Template
 		if templateFlag != "" {
-			templateFilePath, err := getTemplateDirPath()
+			selectedTemplate, err = loadTemplate(templateFlag)
 			if err != nil {
-				log.Logger.Error().Err(err).Msg("Error getting template directory path")
+				log.Logger.Error().Err(err).Str("template", templateFlag).Msg("Error loading template.")
 				return err
 			}
 
-			templateFilePathFinal := filepath.Join(templateFilePath, templateFlag+".tmpl.yaml")
-			templateFileBytes, err := os.ReadFile(templateFilePathFinal)
+			systemMessage = selectedTemplate.SystemMessage
+
+			templateVars, err := templating.ParseVars(templateVarsFlag)
 			if err != nil {
-				log.Logger.Fatal().Err(err).Str("template_path", templateFilePath).Msg("Error reading template file.")
 				return err
 			}
 
-			var selectedTemplate templating.Template
-
-			err = yaml.Unmarshal(templateFileBytes, &selectedTemplate)
+			usesAttachments, err := selectedTemplate.UsesAttachments()
 			if err != nil {
-				log.Logger.Fatal().Err(err).Str("template_path", templateFilePath).Msg("Error unmarshalling template file. Check YAML syntax.")
 				return err
 			}
 
-			if selectedTemplate.SystemMessage != "" {
-				completionMessages = append(completionMessages, llm.ChatCompletionMessage{
-					Role:    "system",
-					Content: selectedTemplate.SystemMessage,
-				})
+			promptShape := templating.PromptShape{
+				UserPrompt: prompt.Merged,
+				Args:       prompt.Args,
+				Stdin:      prompt.Stdin,
+				Vars:       templateVars,
+			}
+			// A template that places the attachments itself takes them out of their own messages
+			if usesAttachments {
+				promptShape.Attachments = strings.Join(attachments, "\n\n")
+				attachments = nil
 			}
 
-			processedUserPrompt, err := selectedTemplate.ProcessUserPromptTemplate(finalPrompt)
+			processedUserPrompt, err := selectedTemplate.ProcessUserPromptTemplate(promptShape)
 			if err != nil {
-				log.Logger.Fatal().Err(err).Str("template_path", templateFilePath).Msg("Error processing user prompt template.")
+				log.Logger.Error().Err(err).Str("template", templateFlag).Msg("Error processing user prompt template.")
 				return err
 			}
 
@@ -149,73 +327,509 @@ var rootCmd = &cobra.Command{
 				log.Logger.Debug().Str("model", finalResolvedModel).Msg("Overriding model from template.")
 			}
 
-			if selectedTemplate.Temperature != nil {
+			if selectedTemplate.Temperature != nil && !cmd.Flags().Changed("temperature") {
 				finalTemperature = selectedTemplate.Temperature
 				log.Logger.Debug().Float64("temperature", *finalTemperature).Msg("Overriding temperature from template.")
 			}
 
+			// An explicit --max-tokens wins over the template so a one-off run can still be capped
+			if selectedTemplate.MaxTokens != nil && !cmd.Flags().Changed("max-tokens") {
+				finalMaxTokens = selectedTemplate.MaxTokens
+				log.Logger.Debug().Int("max_tokens", *finalMaxTokens).Msg("Overriding max tokens from template.")
+			}
+
+			if selectedTemplate.TopP != nil && !cmd.Flags().Changed("top-p") {
+				finalTopP = selectedTemplate.TopP
+				log.Logger.Debug().Float64("top_p", *finalTopP).Msg("Overriding top_p from template.")
+			}
+
+			if selectedTemplate.FrequencyPenalty != nil && !cmd.Flags().Changed("frequency-penalty") {
+				finalFrequencyPenalty = selectedTemplate.FrequencyPenalty
+				log.Logger.Debug().Float64("frequency_penalty", *finalFrequencyPenalty).Msg("Overriding frequency penalty from template.")
+			}
+
+			if selectedTemplate.PresencePenalty != nil && !cmd.Flags().Changed("presence-penalty") {
+				finalPresencePenalty = selectedTemplate.PresencePenalty
+				log.Logger.Debug().Float64("presence_penalty", *finalPresencePenalty).Msg("Overriding presence penalty from template.")
+			}
+
+			if len(selectedTemplate.Stop) > 0 && !cmd.Flags().Changed("stop") {
+				finalStop = selectedTemplate.Stop
+				log.Logger.Debug().Strs("stop", finalStop).Msg("Overriding stop sequences from template.")
+			}
+
+			if selectedTemplate.Seed != nil && !cmd.Flags().Changed("seed") {
+				finalSeed = selectedTemplate.Seed
+				log.Logger.Debug().Int("seed", *finalSeed).Msg("Overriding seed from template.")
+			}
+
+			templateProvider = selectedTemplate.Provider
+
 		} else {
 			completionMessages = append(completionMessages, llm.ChatCompletionMessage{
 				Role:    "user",
-				Content: finalPrompt,
+				Content: prompt.Merged,
 			})
 			log.Logger.Debug().Msg("No template used. Using direct user prompt.")
 		}
 
+		// Per-model defaults only fill in what neither a flag nor the template set, so they end up
+		// between those and the global config
+		defaults, err := loadModelDefaults(finalResolvedModel)
+		if err != nil {
+			return err
+		}
+
+		if defaults.Temperature != nil && lastRequest == nil && !cmd.Flags().Changed("temperature") && (selectedTemplate == nil || selectedTemplate.Temperature == nil) {
+			finalTemperature = defaults.Temperature
+			log.Logger.Debug().Float64("temperature", *finalTemperature).Msg("Using temperature from the model defaults.")
+		}
+
+		if defaults.MaxTokens != nil && !cmd.Flags().Changed("max-tokens") && (selectedTemplate == nil || selectedTemplate.MaxTokens == nil) {
+			finalMaxTokens = defaults.MaxTokens
+			log.Logger.Debug().Int("max_tokens", *finalMaxTokens).Msg("Using max tokens from the model defaults.")
+		}
+
+		if defaults.TopP != nil && !cmd.Flags().Changed("top-p") && (selectedTemplate == nil || selectedTemplate.TopP == nil) {
+			finalTopP = defaults.TopP
+			log.Logger.Debug().Float64("top_p", *finalTopP).Msg("Using top_p from the model defaults.")
+		}
+
+		// Wraps whatever the template produced, so an ad-hoc instruction works with any template
+		userMessage := &completionMessages[len(completionMessages)-1]
+		userMessage.Content = joinPromptParts(prefixFlag, userMessage.Content, suffixFlag)
+
+		if len(imageFlag) > 0 {
+			imageParts, err := getImageParts(imageFlag)
+			if err != nil {
+				log.Logger.Error().Err(err).Msg("Failed to read images")
+				return err
+			}
+
+			if supported, known := modelSupportsInput(finalResolvedModel, "image"); known && !supported {
+				log.Logger.Warn().Str("model", finalResolvedModel).Msg("The model doesn't list image input, the request will likely fail. Try 'llm models --modality image'.")
+			}
+
+			userMessage.ContentParts = append([]llm.ContentPart{llm.NewTextPart(userMessage.Content)}, imageParts...)
+		}
+
+		// Each attachment gets its own message ahead of the prompt so they can be dropped one by one
+		// when the context is too small. They're saved to history with it, so --continue keeps them
+		for i := len(attachments) - 1; i >= 0; i-- {
+			completionMessages = append([]llm.ChatCompletionMessage{{
+				Role:    "user",
+				Content: attachments[i],
+			}}, completionMessages...)
+		}
+
+		conversation, err := loadConversation()
+		if err != nil {
+			log.Logger.Error().Err(err).Msg("Failed to load conversation history")
+			return err
+		}
+
+		if err := checkSystemFlags(); err != nil {
+			return err
+		}
+
+		// A continued conversation got the default with its first message, so it isn't added again
+		systemMessage, err = resolveSystemPrompt(systemMessage, len(conversation.Messages) > 0)
+		if err != nil {
+			log.Logger.Error().Err(err).Msg("Failed to get system prompt content")
+			return err
+		}
+
+		if systemMessage != "" {
+			completionMessages = append([]llm.ChatCompletionMessage{{
+				Role:    "system",
+				Content: systemMessage,
+			}}, completionMessages...)
+		}
+
+		// Only this run's messages, a continued conversation or repeated request went through the
+		// hook when it was first sent
+		if lastRequest == nil {
+			if err := runPreRequestHook(ctx, completionMessages); err != nil {
+				log.Logger.Error().Err(err).Msg("Pre-request hook failed")
+				return err
+			}
+		}
+
+		// A system message from this run replaces the one a continued conversation started with,
+		// and goes first like it did there. --no-system drops the old one too
+		earlierMessages := conversation.Messages
+		if systemMessage != "" || noSystemFlag {
+			earlierMessages = withoutSystemMessages(earlierMessages)
+		}
+		if systemMessage != "" {
+			completionMessages = slices.Concat(completionMessages[:1], earlierMessages, completionMessages[1:])
+		} else {
+			completionMessages = append(earlierMessages, completionMessages...)
+		}
+
+		// Any conversation it came from is already in the saved messages, so a repeat starts a new one
+		if lastRequest != nil {
+			conversation = history.NewConversation()
+			completionMessages = lastRequest.Messages
+		}
+
+		// A repeated model was checked when it was first sent, and --web may have added a suffix
+		// that isn't in the models list
+		if lastRequest == nil || cmd.Flags().Changed("model") {
+			if err := validateModel(finalResolvedModel); err != nil {
+				log.Logger.Error().Err(err).Msg("Invalid model.")
+				return err
+			}
+		}
+
+		if viper.GetBool("show_model") {
+			printModelNote(os.Stderr, viper.GetString("model"), finalResolvedModel)
+		}
+
+		var fallbackModels []string
+		for _, fallbackModel := range fallbackModelFlag {
+			fallbackModel = resolveModel(fallbackModel)
+			if err := validateModel(fallbackModel); err != nil {
+				log.Logger.Error().Err(err).Msg("Invalid fallback model.")
+				return err
+			}
+			fallbackModels = append(fallbackModels, fallbackModel)
+		}
+
+		overflowMode := viper.GetString("context.overflow")
+		if truncateFlag {
+			overflowMode = contextOverflowTruncate
+		}
+
+		completionMessages, err = fitContext(completionMessages, finalResolvedModel, finalMaxTokens, overflowMode)
+		if err != nil {
+			log.Logger.Error().Err(err).Msg("Prompt doesn't fit the model's context.")
+			return err
+		}
+
+		// The prefill only goes into the request. History gets it back as part of the answer
+		requestMessages := completionMessages
+		if prefill != "" {
+			requestMessages = append(completionMessages[:len(completionMessages):len(completionMessages)], llm.ChatCompletionMessage{
+				Role:    "assistant",
+				Content: prefill,
+			})
+		}
+
 		completionBody := llm.ChatCompletionRequest{
-			Model:       finalResolvedModel,
-			Messages:    completionMessages,
-			Temperature: finalTemperature,
+			Model:            finalResolvedModel,
+			Models:           fallbackModels,
+			Messages:         requestMessages,
+			Temperature:      finalTemperature,
+			MaxTokens:        finalMaxTokens,
+			TopP:             finalTopP,
+			FrequencyPenalty: finalFrequencyPenalty,
+			PresencePenalty:  finalPresencePenalty,
+			Stop:             finalStop,
+			Seed:             finalSeed,
+			ResponseFormat:   responseFormat,
+			Provider:         providerPreferences(cmd, templateProvider),
+			Tools:            tools,
+		}
+
+		if choices > 1 {
+			completionBody.N = &choices
+		}
+
+		// Everything else about a repeat is sent as it was saved
+		if lastRequest != nil {
+			repeated := lastRequest.ChatCompletionRequest
+			repeated.Model = finalResolvedModel
+			repeated.Temperature = finalTemperature
+			repeated.Messages = requestMessages
+			completionBody = repeated
+		}
+
+		if useStreaming {
+			completionBody.Stream = true
+			if viper.GetBool("show_usage") {
+				completionBody.StreamOptions = &llm.StreamOptions{IncludeUsage: true}
+			}
+		}
+
+		if webFlag {
+			if err := applyWebSearch(&completionBody); err != nil {
+				return err
+			}
+		}
+
+		if err := completionBody.Validate(); err != nil {
+			log.Logger.Error().Err(err).Msg("Invalid completion request.")
+			return err
+		}
+
+		if dryRunFlag {
+			return printRequestBody(os.Stdout, completionBody)
+		}
+
+		if !mockMode() {
+			saveLastRequest(completionBody)
+		}
+
+		// The file always gets the plain markdown, whatever --raw or --format do to the terminal
+		outputFile, err := openOutputFile(outputFileFlag)
+		if err != nil {
+			log.Logger.Error().Err(err).Msg("Failed to open output file")
+			return err
+		}
+		if outputFile != nil {
+			defer outputFile.Close()
+
+			if llmClient.ReasoningWriter != nil {
+				llmClient.ReasoningWriter = io.MultiWriter(llmClient.ReasoningWriter, outputFile)
+			}
+		}
+
+		// Unlike --timeout, this bounds the whole request, a stream that's still flowing included.
+		// It starts here so time spent on the prompt or a dry run doesn't count
+		if requestTimeout > 0 {
+			var cancelTimeout context.CancelFunc
+			ctx, cancelTimeout = context.WithTimeout(ctx, requestTimeout)
+			defer cancelTimeout()
+		}
+
+		// A sweep prints several answers, so none of them is copied or saved to history
+		if len(tempSweepFlag) > 0 {
+			var sweepOutput io.Writer
+			if outputFile != nil {
+				sweepOutput = outputFile
+			}
+			return runTempSweep(ctx, llmClient, completionBody, tempSweepFlag, useStreaming, sweepOutput)
 		}
 
-		if !streamingModeFlag || viper.GetBool("always_format") {
+		var responseContent string
+		var responseUsage *llm.Usage
+		var interruptErr error
+
+		if !useStreaming {
+			waiting := startSpinner("Waiting for " + completionBody.Model)
 			completion, err := llmClient.GetChatCompletion(ctx, completionBody)
+			waiting.Stop()
 			if err != nil {
+				if errors.Is(err, context.Canceled) {
+					// A blocking response arrives all at once, so there's nothing partial to keep
+					log.Logger.Warn().Msg("Interrupted before the response arrived. Streaming mode keeps partial output on Ctrl-C.")
+					return err
+				}
+				err = explainAPIError(err)
 				log.Logger.Error().Err(err).Msg("Error getting chat completion")
 				return err
 			}
 
-			if len(completion.Choices) > 0 {
-				completionContent := completion.Choices[0].Message.Content
+			if len(completion.Choices) > 1 {
+				if viper.GetString(hookPostResponse) != "" {
+					log.Logger.Warn().Msg("hooks.post_response isn't applied to --choices answers.")
+				}
+				responseContent, err = printChoices(completion.Choices, prefill, outputFile, responseFormat != nil)
+				if err != nil {
+					log.Logger.Error().Err(err).Msg("Failed to print choices")
+					return err
+				}
+				responseUsage = completion.Usage
+			} else if len(completion.Choices) > 0 && len(completion.Choices[0].Message.ToolCalls) > 0 {
+				// There's no result to send back yet, so the exchange can't be continued and isn't
+				// saved to history
+				message := completion.Choices[0].Message
+				if message.Content != "" {
+					fmt.Fprintln(os.Stderr, message.Content)
+				}
 
-				// TODO: Allow configuring the code theme/stylesheet
-				// Give the output a glammm 💅
-				renderedOutput, renderErr := glamour.Render(completionContent, "auto")
-				if renderErr != nil {
-					log.Logger.Error().Err(renderErr).Msg("Error rendering output.")
-				} else {
-					fmt.Println(renderedOutput)
+				var toolCallOutput io.Writer = os.Stdout
+				if outputFile != nil {
+					toolCallOutput = io.MultiWriter(os.Stdout, outputFile)
+				}
+				if err := printToolCalls(toolCallOutput, message.ToolCalls); err != nil {
+					return err
 				}
 
-				if viper.GetBool("always_copy") {
-					log.Logger.Info().Msg("Copying to clipboard...")
-					err := utils.CopyToClipboard(completion.Choices[0].Message.Content)
-					if err != nil {
-						log.Logger.Warn().Err(err).Msg("Error copying to clipboard")
+				if viper.GetBool("show_usage") {
+					printUsage(os.Stderr, apiKey, finalResolvedModel, completion.Usage)
+				}
+				return nil
+			} else if len(completion.Choices) > 0 && completion.Choices[0].Message.Content == "" {
+				// A reasoning model can spend all of max_tokens thinking, the notice explains that
+				printFinishNotice(os.Stderr, completion.Choices[0].FinishReason)
+				log.Logger.Warn().Msg("The model returned an empty answer.")
+				return fmt.Errorf("%w: the answer was empty", ErrNoContent)
+			} else if len(completion.Choices) > 0 {
+				content := completion.Choices[0].Message.Content
+				reasoning := completion.Choices[0].Message.Reasoning
+				if viper.GetBool("reasoning.think_tags") {
+					var thought string
+					thought, content = splitThinkTags(content)
+					if thought != "" && !viper.GetBool("show_reasoning") {
+						printHiddenReasoning(thinkNoteWriter(), thought)
 					}
+					reasoning = strings.TrimSpace(reasoning + "\n\n" + thought)
 				}
+
+				content = runPostResponseHook(ctx, content)
+
+				responseContent = prefill + content
+				responseUsage = completion.Usage
+
+				completionContent := content
+				if echoPrefill() {
+					completionContent = responseContent
+				}
+
+				if reasoning != "" && viper.GetBool("show_reasoning") {
+					printReasoning(reasoningWriter(), reasoning)
+					if outputFile != nil {
+						printReasoning(outputFile, reasoning)
+					}
+				}
+
+				if outputFile != nil {
+					if _, err := fmt.Fprintln(outputFile, completionContent); err != nil {
+						log.Logger.Error().Err(err).Msg("Failed to write output file")
+						return err
+					}
+				}
+
+				if rawOutput() {
+					fmt.Println(completionContent)
+				} else {
+					// Give the output a glammm 💅
+					renderedOutput, renderErr := renderResponse(completionContent, responseFormat != nil)
+					if renderErr != nil {
+						log.Logger.Error().Err(renderErr).Msg("Error rendering output.")
+					} else {
+						fmt.Println(renderedOutput)
+					}
+				}
+				printFinishNotice(os.Stderr, completion.Choices[0].FinishReason)
+				annotations := append(completion.Choices[0].Message.Annotations, llm.CitationAnnotations(completion.Citations)...)
+				printCitations(citationOutput(outputFile, responseFormat != nil), annotations)
+				if responseFormat != nil {
+					warnIfNotJSON(responseContent)
+				}
+
+				copyResponse(completionContent)
 			} else {
 				log.Logger.Warn().Msg("OpenRouter responded with no choices!")
-				return errors.New("no completion choices received")
+				return fmt.Errorf("%w: the response had no choices", ErrNoContent)
 			}
 		} else {
-			completionBody.Stream = true
-			fullCompletion, err := llmClient.GetStreamingChatCompletion(ctx, completionBody, os.Stdout)
-			if err != nil {
-				log.Logger.Error().Err(err).Msg("Error getting streaming chat completion")
-				return err
+			var streamOutput io.Writer = os.Stdout
+			var markdownStream *markdownStreamWriter
+			var wrapStream *wordWrapWriter
+			if viper.GetBool("always_format") && !rawOutput() && responseFormat == nil {
+				markdownStream = newMarkdownStreamWriter(os.Stdout)
+				streamOutput = markdownStream
+				if llmClient.ReasoningWriter != nil {
+					llmClient.ReasoningWriter = markdownStream.interrupt(llmClient.ReasoningWriter)
+				}
+			} else if wrapStreamedOutput(responseFormat != nil) {
+				// Only the terminal gets wrapped text, --output-file keeps the answer as sent
+				wrapStream = newWordWrapWriter(os.Stdout)
+				streamOutput = wrapStream
 			}
 
-			if viper.GetBool("always_copy") {
-				log.Logger.Info().Msg("Copying to clipboard...")
-				err := utils.CopyToClipboard(fullCompletion)
-				if err != nil {
-					log.Logger.Warn().Err(err).Msg("Error copying to clipboard")
+			if outputFile != nil {
+				streamOutput = io.MultiWriter(streamOutput, outputFile)
+			}
+
+			var thinkTags *thinkTagWriter
+			if viper.GetBool("reasoning.think_tags") {
+				thinkTags = newThinkTagWriter(streamOutput, llmClient.ReasoningWriter, thinkNoteWriter())
+				streamOutput = thinkTags
+			}
+
+			if echoPrefill() {
+				fmt.Fprint(streamOutput, prefill)
+			}
+
+			streamedCompletion, err := llmClient.GetStreamingChatCompletion(ctx, completionBody, streamOutput)
+			if thinkTags != nil {
+				if flushErr := thinkTags.Flush(); flushErr != nil {
+					log.Logger.Warn().Err(flushErr).Msg("Error writing streamed output.")
+				}
+			}
+			if markdownStream != nil {
+				if flushErr := markdownStream.Flush(); flushErr != nil {
+					log.Logger.Warn().Err(flushErr).Msg("Error rendering streamed output.")
+				}
+			}
+			if wrapStream != nil {
+				if flushErr := wrapStream.Flush(); flushErr != nil {
+					log.Logger.Warn().Err(flushErr).Msg("Error writing streamed output.")
+				}
+			}
+			if err != nil {
+				// Whatever streamed before Ctrl-C or the request timeout is still copied and saved to
+				// history below, so --continue can pick up from it. The run still fails to signal it
+				stopped := errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+				if !stopped || streamedCompletion == nil || streamedCompletion.Content == "" {
+					err = explainAPIError(err)
+					log.Logger.Error().Err(err).Msg("Error getting streaming chat completion")
+					return err
 				}
+
+				resetTerminalColors(os.Stdout)
+				log.Logger.Warn().Msg("Response interrupted. Keeping the partial output.")
+				interruptErr = err
+			}
+			if interruptErr == nil && streamedCompletion.Content == "" {
+				printFinishNotice(os.Stderr, streamedCompletion.FinishReason)
+				log.Logger.Warn().Msg("The model returned an empty answer.")
+				return fmt.Errorf("%w: the stream ended without any text", ErrNoContent)
+			}
+
+			content := streamedCompletion.Content
+			if thinkTags != nil {
+				_, content = splitThinkTags(content)
+			}
+
+			responseContent = prefill + content
+			responseUsage = streamedCompletion.Usage
+			printFinishNotice(os.Stderr, streamedCompletion.FinishReason)
+			printCitations(citationOutput(outputFile, responseFormat != nil), streamedCompletion.Annotations)
+			if responseFormat != nil && interruptErr == nil {
+				warnIfNotJSON(responseContent)
+			}
+
+			if echoPrefill() {
+				copyResponse(responseContent)
+			} else {
+				copyResponse(content)
 			}
 		}
 
-		return nil
+		if countFlag {
+			printResponseStats(os.Stderr, responseContent, finalResolvedModel)
+		}
+		if viper.GetBool("show_usage") {
+			printUsage(os.Stderr, apiKey, finalResolvedModel, responseUsage)
+		}
+		warnIfLowCredits(apiKey)
+
+		conversation.Model = finalResolvedModel
+		conversation.Messages = append(completionMessages, llm.ChatCompletionMessage{
+			Role:    "assistant",
+			Content: responseContent,
+		})
+
+		// Mock answers would only get in the way of --continue later
+		if mockMode() {
+			return interruptErr
+		}
+
+		historyDirPath, err := getHistoryDirPath()
+		if err == nil {
+			err = history.Save(historyDirPath, conversation)
+		}
+		if err != nil {
+			// The answer was already printed, so a failed save shouldn't fail the run
+			log.Logger.Warn().Err(err).Msg("Failed to save conversation history")
+		}
+
+		return interruptErr
 	},
 	Args: func(cmd *cobra.Command, args []string) error {
 		return nil // Allow arbitrary arguments
@@ -223,23 +837,44 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() error {
+	// Done here rather than in init so every subcommand has been added by now
+	markUsageErrors(rootCmd)
+
 	return rootCmd.Execute()
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
 	cobra.OnInitialize(initDefaultTemplates)
+
+	// Inherited by every subcommand, so any unknown or malformed flag exits with ExitUsage
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &UsageError{err}
+	})
 	cobra.OnInitialize(func() {
-		log.InitLggger(viper.GetBool("verbose"), viper.GetBool("debug_mode"), viper.GetString("log_file"))
+		log.InitLggger(viper.GetBool("verbose"), viper.GetBool("debug_mode"), viper.GetBool("quiet"), colorDisabled(), utils.ExpandPath(viper.GetString("log_file")), viper.GetString("log.format"), log.Rotation{
+			MaxSizeMB:  viper.GetInt("log.max_size_mb"),
+			MaxBackups: viper.GetInt("log.max_backups"),
+			MaxAgeDays: viper.GetInt("log.max_age_days"),
+		})
+		log.RegisterSecret(viper.GetString("api_key"))
 	})
 
 	rootCmd.PersistentFlags().BoolVarP(&verboseFlag, "verbose", "v", false, "Enable verbose output for debugging information.")
 	rootCmd.PersistentFlags().StringVar(&logFileFlag, "log-file", "", "Path to the log file (default: ~/.llm/logs/llm.log).")
 	rootCmd.PersistentFlags().BoolVar(&debugMode, "debug", false, "Enable debug logging level (overrides --verbose).")
+	rootCmd.PersistentFlags().BoolVarP(&quietFlag, "quiet", "q", false, "Only print errors to stderr, for clean piping (--verbose and --debug override it).")
 
 	viper.BindPFlag("verbose", rootCmd.PersistentFlags().Lookup("verbose"))
 	viper.BindPFlag("log_file", rootCmd.PersistentFlags().Lookup("log-file"))
 	viper.BindPFlag("debug_mode", rootCmd.PersistentFlags().Lookup("debug"))
+	viper.BindPFlag("quiet", rootCmd.PersistentFlags().Lookup("quiet"))
+
+	rootCmd.PersistentFlags().BoolVar(&noColorFlag, "no-color", false, "Disable colors in logs and rendered output (also set by the NO_COLOR env var)")
+	viper.BindPFlag("no_color", rootCmd.PersistentFlags().Lookup("no-color"))
+
+	rootCmd.PersistentFlags().StringVar(&profileFlag, "profile", "", "Config profile to use from the profiles section of the config file (env: LLM_PROFILE)")
+	viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
 
 	// Store the config file in a variable if provided through a flag
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.llm.yaml)")
@@ -250,29 +885,141 @@ func init() {
 	// Looking for "model" value from the flag first, then env variables, then config file, ..xetc
 	viper.BindPFlag("model", rootCmd.Flags().Lookup("model"))
 
+	rootCmd.Flags().BoolVar(&listAliasesFlag, "list-aliases", false, "Print the model aliases and exit (same as 'llm alias list')")
+	rootCmd.Flags().BoolVar(&showModelFlag, "show-model", false, "Print the model the request goes to on stderr, and the alias it came from")
+	viper.BindPFlag("show_model", rootCmd.Flags().Lookup("show-model"))
+	rootCmd.RegisterFlagCompletionFunc("model", completeModelNames)
+
 	// Store "api-key" flag in a variable
 	rootCmd.PersistentFlags().StringVarP(&apiKeyFlag, "api-key", "k", "", "Your LLM API key (overrides config/env)")
 	viper.BindPFlag("api_key", rootCmd.PersistentFlags().Lookup("api-key"))
 
+	rootCmd.PersistentFlags().StringVar(&baseURLFlag, "base-url", "", "Root of an OpenAI-compatible API to use instead of OpenRouter (e.g. http://localhost:11434/v1)")
+	viper.BindPFlag("base_url", rootCmd.PersistentFlags().Lookup("base-url"))
+
 	rootCmd.Flags().BoolVarP(&copyToClipboardFlag, "copy", "c", false, "Copy the LLM response to the clipboard")
+	rootCmd.Flags().BoolVar(&fromClipboardFlag, "from-clipboard", false, "Read the clipboard as the prompt, or as context after the prompt arguments")
 	viper.BindPFlag("always_copy", rootCmd.Flags().Lookup("copy"))
 
-	rootCmd.Flags().StringVarP(&promptFileFlag, "prompt-file", "f", "", "Path to a file containing the prompt")
+	rootCmd.Flags().BoolVar(&copyCodeFlag, "copy-code", false, "Copy only the fenced code blocks of the response to the clipboard")
 
-	rootCmd.Flags().BoolVarP(&streamingModeFlag, "stream-mode", "s", true, "Show the LLM output in blocking style")
+	rootCmd.Flags().StringVarP(&promptFileFlag, "prompt-file", "f", "", "Path to a file containing the prompt")
+	rootCmd.Flags().BoolVar(&stdinFlag, "stdin", false, "Read the prompt from stdin even if it doesn't look piped (same as passing -)")
+	rootCmd.Flags().StringArrayVarP(&attachFlag, "attach", "a", nil, "Add a file's contents as context ahead of the prompt (repeatable)")
+	rootCmd.Flags().BoolVar(&forceFlag, "force", false, "Send prompt files, stdin, and attachments over max_input_bytes and attach.max_bytes anyway")
+	rootCmd.Flags().BoolVar(&webFlag, "web", false, "Let the model search the web and list the sources it cites (see web.mode)")
+	rootCmd.Flags().StringVar(&toolsFlag, "tools", "", "JSON file of tool definitions the model may call. Requested calls are printed as JSON instead of an answer")
+	rootCmd.Flags().StringVarP(&outputFileFlag, "output-file", "O", "", "Also write the response (and reasoning) to this file, - for stdout only")
+	// Both names set the same variable, the streaming path already writes to the terminal and the file at once
+	rootCmd.Flags().StringVar(&outputFileFlag, "tee", "", "Same as --output-file, named after tee(1)")
+	rootCmd.Flags().StringArrayVar(&fallbackModelFlag, "fallback-model", nil, "Model or alias to fall back to when the main one is unavailable (repeatable, tried in order)")
+	rootCmd.RegisterFlagCompletionFunc("fallback-model", completeModelNames)
+	rootCmd.Flags().StringSliceVar(&providerOrderFlag, "provider-order", nil, "Comma-separated OpenRouter providers to try in order, e.g. anthropic,openai")
+	rootCmd.Flags().BoolVar(&noFallbacksFlag, "no-fallbacks", false, "Don't fall back to other providers when the ones in --provider-order fail")
+	rootCmd.Flags().BoolVar(&requireParamsFlag, "require-params", false, "Only route to providers that support every parameter in the request")
+	rootCmd.Flags().StringVar(&dataCollectionFlag, "data-collection", "", "Allow or deny providers that may store prompts (allow|deny)")
+	rootCmd.RegisterFlagCompletionFunc("data-collection", cobra.FixedCompletions([]string{"allow", "deny"}, cobra.ShellCompDirectiveNoFileComp))
+
+	rootCmd.Flags().StringVar(&prefixFlag, "prefix", "", "Text to put before the prompt, after any template is applied")
+	rootCmd.Flags().StringVar(&suffixFlag, "suffix", "", "Text to put after the prompt, after any template is applied (e.g. \"Answer concisely.\")")
+
+	rootCmd.Flags().Bool("mock", false, "Answer locally with an echo of the prompt (or mock_response) instead of calling the API (env: LLM_MOCK)")
+	viper.BindPFlag("mock", rootCmd.Flags().Lookup("mock"))
+
+	rootCmd.Flags().IntVarP(&choicesFlag, "choices", "n", 1, "Generate this many alternative answers, each printed under its own header")
+	rootCmd.Flags().IntVar(&copyChoiceFlag, "copy-choice", 1, "Which of the --choices answers to copy and save to history")
+
+	rootCmd.Flags().StringVar(&prefillFlag, "prefill", "", "Start the answer with this text and let the model continue it (e.g. \"{\" for JSON)")
+	rootCmd.Flags().Bool("echo-prefill", true, "Print the --prefill text ahead of the answer so it reads in full")
+	viper.BindPFlag("prefill.echo", rootCmd.Flags().Lookup("echo-prefill"))
+
+	rootCmd.Flags().StringVar(&batchFlag, "batch", "", "Answer every prompt in this file, one per line, in parallel")
+	rootCmd.Flags().IntVar(&batchConcurrencyFlag, "concurrency", 4, "How many --batch prompts or --temp-sweep temperatures to send at once")
+	rootCmd.Flags().Float64SliceVar(&tempSweepFlag, "temp-sweep", nil, "Send the prompt once per temperature (e.g. 0,0.5,1) and print the answers grouped by temperature")
+	rootCmd.Flags().StringVar(&batchOutputFlag, "batch-output", "", "Write each --batch answer to a numbered file in this directory instead of printing JSON")
+	rootCmd.Flags().StringVar(&batchDelimiterFlag, "batch-delimiter", "", "Separate --batch prompts by lines equal to this (e.g. ---) instead of one per line")
+
+	rootCmd.Flags().BoolVar(&jsonFlag, "json", false, "Ask the model to answer with a JSON object")
+	rootCmd.Flags().StringVar(&jsonSchemaFlag, "json-schema", "", "Ask the model to answer with JSON matching the schema in this file")
+	rootCmd.Flags().BoolVar(&dryRunFlag, "dry-run", false, "Print the request that would be sent as JSON instead of sending it")
+
+	rootCmd.Flags().BoolVar(&noSpinnerFlag, "no-spinner", false, "Don't show a spinner on stderr while waiting for a blocking response")
+	viper.BindPFlag("no_spinner", rootCmd.Flags().Lookup("no-spinner"))
+	rootCmd.Flags().BoolVar(&truncateFlag, "truncate", false, "Drop the oldest history and attachments when the prompt is too big for the model's context")
+	rootCmd.Flags().StringArrayVar(&imageFlag, "image", nil, "Send an image file along with the prompt to a vision model (repeatable)")
+
+	rootCmd.Flags().BoolVarP(&streamingModeFlag, "stream-mode", "s", true, "Stream the answer as it's written (off by default when stdout is piped)")
 	viper.BindPFlag("use_streaming", rootCmd.Flags().Lookup("stream-mode"))
 
-	rootCmd.Flags().BoolVarP(&formatOutputFlag, "format", "F", false, "Format the LLM output")
+	rootCmd.Flags().BoolVarP(&formatOutputFlag, "format", "F", false, "Render the streamed output as markdown as each block completes")
 	viper.BindPFlag("always_format", rootCmd.Flags().Lookup("format"))
 
 	rootCmd.Flags().StringVarP(&templateFlag, "template", "t", "", "Specify the template to use for the prompt")
 	viper.BindPFlag("template", rootCmd.Flags().Lookup("template"))
+	rootCmd.RegisterFlagCompletionFunc("template", completeTemplateNames)
+
+	rootCmd.Flags().StringArrayVar(&templateVarsFlag, "var", nil, "Set a template variable as key=value, available as {{.Vars.key}} (repeatable)")
+
+	rootCmd.Flags().IntVarP(&maxTokensFlag, "max-tokens", "M", 0, "Maximum number of tokens to generate in the response")
+	viper.BindPFlag("max_tokens", rootCmd.Flags().Lookup("max-tokens"))
+
+	rootCmd.Flags().Float64Var(&temperatureFlag, "temperature", 0, "Sampling temperature, between 0 and 2 (overrides the template and model defaults)")
+	viper.BindPFlag("temperature", rootCmd.Flags().Lookup("temperature"))
+
+	rootCmd.Flags().Float64Var(&topPFlag, "top-p", 0, "Nucleus sampling probability mass, between 0 and 1")
+	viper.BindPFlag("top_p", rootCmd.Flags().Lookup("top-p"))
+
+	rootCmd.Flags().Float64Var(&frequencyPenaltyFlag, "frequency-penalty", 0, "Penalize tokens by how often they appeared so far, between -2 and 2")
+	viper.BindPFlag("frequency_penalty", rootCmd.Flags().Lookup("frequency-penalty"))
+
+	rootCmd.Flags().Float64Var(&presencePenaltyFlag, "presence-penalty", 0, "Penalize tokens that already appeared at all, between -2 and 2")
+	viper.BindPFlag("presence_penalty", rootCmd.Flags().Lookup("presence-penalty"))
+
+	rootCmd.Flags().StringArrayVar(&stopFlag, "stop", nil, "Stop generating when the model outputs this sequence (repeatable)")
+
+	rootCmd.Flags().IntVar(&seedFlag, "seed", 0, "Sample deterministically with this seed, where the provider supports it")
+	viper.BindPFlag("seed", rootCmd.Flags().Lookup("seed"))
+
+	rootCmd.PersistentFlags().DurationVar(&timeoutFlag, "timeout", llm.DefaultTimeout, "HTTP timeout for requests (e.g. 30s, 5m). In streaming mode it only bounds the wait for the first response")
+	viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
+
+	rootCmd.PersistentFlags().StringVar(&caCertFlag, "ca-cert", "", "PEM file with a CA certificate to trust besides the system ones, e.g. for a corporate proxy")
+	viper.BindPFlag("ca_cert", rootCmd.PersistentFlags().Lookup("ca-cert"))
+
+	rootCmd.PersistentFlags().StringVar(&traceFileFlag, "trace-file", "", "Append the raw HTTP requests and responses to this file for bug reports (API key masked)")
+
+	rootCmd.Flags().DurationVar(&requestTimeoutFlag, "request-timeout", 0, "Give up on the whole request after this long, streaming included (e.g. 90s). 0 means no limit")
+	viper.BindPFlag("request_timeout", rootCmd.Flags().Lookup("request-timeout"))
+
+	rootCmd.Flags().BoolVarP(&repeatFlag, "repeat", "r", false, "Send the last prompt again, optionally with another --model or --temperature")
+
+	rootCmd.Flags().BoolVarP(&continueFlag, "continue", "C", false, "Continue the last conversation instead of starting a new one")
+	viper.BindPFlag("continue", rootCmd.Flags().Lookup("continue"))
+
+	rootCmd.Flags().BoolVar(&newConversationFlag, "new", false, "Start a fresh conversation even if continuing is enabled in config")
+
+	rootCmd.Flags().BoolVar(&showReasoningFlag, "show-reasoning", false, "Print the model's reasoning before the answer when the model provides it")
+	viper.BindPFlag("show_reasoning", rootCmd.Flags().Lookup("show-reasoning"))
+
+	rootCmd.Flags().BoolVar(&usageFlag, "usage", false, "Print token usage and estimated cost after the answer")
+	viper.BindPFlag("show_usage", rootCmd.Flags().Lookup("usage"))
+	rootCmd.Flags().BoolVar(&countFlag, "count", false, "Print the answer's character, word, line, and estimated token counts to stderr")
+
+	rootCmd.Flags().StringVarP(&systemFlag, "system", "S", "", "System prompt to send, or @path to read it from a file (overrides the template's system message)")
+	rootCmd.Flags().BoolVar(&noSystemFlag, "no-system", false, "Send no system prompt, not even the template's or default_system_prompt")
+
+	rootCmd.Flags().StringVar(&styleFlag, "style", "", "Glamour style for rendered output: auto, dark, light, notty, dracula, ... or a path to a JSON style")
+	viper.BindPFlag("render.style", rootCmd.Flags().Lookup("style"))
+	rootCmd.RegisterFlagCompletionFunc("style", completeRenderStyles)
+
+	rootCmd.Flags().BoolVar(&rawFlag, "raw", false, "Print the response verbatim without markdown rendering (the default when stdout isn't a terminal)")
+	viper.BindPFlag("raw", rootCmd.Flags().Lookup("raw"))
 }
 
 func initConfig() {
 	var configPath string
 	if cfgFile != "" {
-		configPath = cfgFile
+		configPath = utils.ExpandPath(cfgFile)
 	} else {
 		xdgConfigHome := os.Getenv("XDG_CONFIG_HOME")
 		if xdgConfigHome == "" {
@@ -280,11 +1027,11 @@ func initConfig() {
 			cobra.CheckErr(err)
 			xdgConfigHome = filepath.Join(home, ".config")
 		}
-		configPath = filepath.Join(xdgConfigHome, "llm", "config.yaml")
+		configPath = defaultConfigPath(filepath.Join(xdgConfigHome, "llm"))
 	}
 
 	viper.SetConfigFile(configPath)
-	viper.SetConfigType("yaml")
+	viper.SetConfigType(configType(configPath))
 
 	// Any variables starting with LLM_* are captured for the cli
 	viper.SetEnvPrefix("LLM")
@@ -292,25 +1039,29 @@ func initConfig() {
 	// Auto loads config files from env variables if any matches
 	viper.AutomaticEnv()
 
-	viper.SetDefault("always_format", false)
-	viper.SetDefault("use_streaming", true)
-	viper.SetDefault("always_copy", false)
+	// Nested keys map to underscores, so log.format is read from LLM_LOG_FORMAT
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+
+	// Bound explicitly so they also count as set without a default or flag, and so the key
+	// OpenRouter's own docs tell people to export works too. The first one set wins
+	viper.BindEnv("api_key", "LLM_API_KEY", "OPENROUTER_API_KEY")
+	viper.BindEnv("model", "LLM_MODEL")
+	viper.BindEnv("base_url", "LLM_BASE_URL")
+	viper.BindEnv("profile", "LLM_PROFILE")
+	viper.BindEnv("timeout", "LLM_TIMEOUT")
+
+	// Not among configDefaults, so a key from the environment is never written to a new config
 	viper.SetDefault("api_key", "")
-	viper.SetDefault("model", "google/gemini-2.5-flash")
-	viper.SetDefault("verbose", false)
-	viper.SetDefault("debug_mode", false)
-	viper.SetDefault("log_file", "")
-	viper.SetDefault("models.aliases", map[string]string{
-		"fast":  "openai/gpt-4.1-nano",
-		"10x":   "anthropic/claude-sonnet-4",
-		"smart": "google/gemini-2.5-pro",
-		"gpt4":  "openai/gpt-4o",
-	})
+	for key, value := range configDefaults() {
+		viper.SetDefault(key, value)
+	}
 
 	if err := viper.ReadInConfig(); err == nil {
 		log.Logger.Info().Str("config_file", viper.ConfigFileUsed()).Msg("Using config file.")
 	} else {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+		// SetConfigFile makes viper report a missing file as a plain not-exist error instead of
+		// ConfigFileNotFoundError, so check for both
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok || errors.Is(err, fs.ErrNotExist) {
 			log.Logger.Info().Str("config_path", configPath).Msg("Config file not found. Creating a new one with defaults...")
 
 			// Attempt to write the default config file
@@ -319,7 +1070,7 @@ func initConfig() {
 				return
 			}
 
-			if writeErr := viper.SafeWriteConfigAs(configPath); writeErr != nil {
+			if writeErr := writeDefaultConfig(configPath); writeErr != nil {
 				log.Logger.Error().Err(writeErr).Str("config_path", configPath).Msg("Error creating default config file.")
 			} else {
 				log.Logger.Info().Str("config_path", configPath).Msg("Default config file created.")
@@ -334,6 +1085,105 @@ func initConfig() {
 			os.Exit(1)
 		}
 	}
+
+	// The logger isn't set up yet at this point, so make sure a bad profile name is actually seen
+	cobra.CheckErr(applyProfile(viper.GetString("profile")))
+}
+
+// configDefaults are the settings every run starts from, and all a new config file is written with
+func configDefaults() map[string]any {
+	return map[string]any{
+		"always_format":            false,
+		"use_streaming":            true,
+		"always_copy":              false,
+		"model":                    "google/gemini-2.5-flash",
+		"verbose":                  false,
+		"debug_mode":               false,
+		"log_file":                 "",
+		"log.format":               log.FormatJSON,
+		"log.max_size_mb":          10,
+		"log.max_backups":          3,
+		"log.max_age_days":         28,
+		"timeout":                  llm.DefaultTimeout.String(),
+		"models.cache_ttl":         "24h",
+		"models.validate":          true,
+		"openrouter.title":         "llm-cli",
+		"attach.max_bytes":         512 * 1024,
+		"max_input_bytes":          256 * 1024,
+		"context.overflow":         contextOverflowWarn,
+		"stream.max_line_bytes":    llm.DefaultMaxStreamLineBytes,
+		"stream.wrap":              true,
+		"reasoning.think_tags":     false,
+		templating.SystemAccessKey: false,
+		"hooks.timeout":            "30s",
+		"web.mode":                 webModePlugin,
+		"models.aliases":           defaultModelAliases,
+		"clipboard.backend":        utils.ClipboardAuto,
+		"render.autodetect_json":   true,
+		"credits.warn_below":       0,
+		"prompt.merge_order":       promptMergeFileFirst,
+		"prefill.echo":             true,
+	}
+}
+
+// writeDefaultConfig creates the config file at configPath holding only configDefaults. Writing
+// viper's own settings would also save this run's flags and environment, API key included
+func writeDefaultConfig(configPath string) error {
+	defaults := viper.New()
+	for key, value := range configDefaults() {
+		defaults.Set(key, value)
+	}
+	defaults.SetConfigType(configType(configPath))
+
+	return defaults.SafeWriteConfigAs(configPath)
+}
+
+// defaultConfigPath is the first config.<ext> found in configDir, trying the formats in the order
+// of configExtensions. When there's none yet, it's the config.yaml to create
+func defaultConfigPath(configDir string) string {
+	for _, extension := range configExtensions {
+		configPath := filepath.Join(configDir, "config."+extension)
+		if _, err := os.Stat(configPath); err == nil {
+			return configPath
+		}
+	}
+
+	return filepath.Join(configDir, "config.yaml")
+}
+
+// configExtensions are the config file formats llm reads and writes
+var configExtensions = []string{"yaml", "yml", "toml", "json"}
+
+// configType is the format of the config file at configPath going by its extension. Files without
+// a known one, like ~/.llmrc, are YAML
+func configType(configPath string) string {
+	switch strings.ToLower(strings.TrimPrefix(filepath.Ext(configPath), ".")) {
+	case "toml":
+		return "toml"
+	case "json":
+		return "json"
+	}
+
+	return "yaml"
+}
+
+// applyProfile layers profiles.<name> over the top-level config values. It's merged as config,
+// so flags and env vars still win over whatever the profile sets
+func applyProfile(profile string) error {
+	if profile == "" {
+		return nil
+	}
+
+	if !viper.IsSet("profiles." + profile) {
+		return fmt.Errorf("profile %q not found in config, available profiles: %s", profile, strings.Join(listProfiles(), ", "))
+	}
+
+	if err := viper.MergeConfigMap(viper.GetStringMap("profiles." + profile)); err != nil {
+		return fmt.Errorf("failed to apply profile %q: %w", profile, err)
+	}
+
+	log.Logger.Info().Str("profile", profile).Msg("Using config profile.")
+	return nil
 }
 
 func initDefaultTemplates() {
@@ -412,6 +1262,95 @@ func initDefaultTemplates() {
 	log.Logger.Info().Msg("Default templates initialized successfully.")
 }
 
+// optionalFloat returns nil when the key was never set by a flag, env var, or config so
+// the field is left out of the request body and the provider default applies. A zero that was
+// set is sent like any other value
+func optionalFloat(cmd *cobra.Command, key string) *float64 {
+	if !flagChanged(cmd, key) && !viper.IsSet(key) {
+		return nil
+	}
+
+	value := viper.GetFloat64(key)
+	return &value
+}
+
+// optionalInt is optionalFloat for whole numbers like max_tokens and seed
+func optionalInt(cmd *cobra.Command, key string) *int {
+	if !flagChanged(cmd, key) && !viper.IsSet(key) {
+		return nil
+	}
+
+	value := viper.GetInt(key)
+	return &value
+}
+
+// flagChanged reports whether the flag behind a sampling key was passed on this run
+func flagChanged(cmd *cobra.Command, key string) bool {
+	return cmd.Flags().Changed(strings.ReplaceAll(key, "_", "-"))
+}
+
+// resolveModel maps a configured alias to its full model ID. Anything that isn't an alias is
+// passed through untouched
+func resolveModel(requestedModel string) string {
+	aliases := viper.GetStringMapString("models.aliases")
+
+	if aliasToFull, found := aliases[requestedModel]; found {
+		if viper.GetBool("verbose") {
+			log.Logger.Info().Str("alias", requestedModel).Str("model", aliasToFull).Msg("Using alias for model.")
+		}
+
+		return aliasToFull
+	}
+
+	log.Logger.Info().Str("model", requestedModel).Msg("Using model.")
+	return requestedModel
+}
+
+// loadConversation picks the conversation the new prompt belongs to. It's the most recent one
+// when continuing, or a brand new one otherwise
+func loadConversation() (*history.Conversation, error) {
+	if !viper.GetBool("continue") || newConversationFlag {
+		return history.NewConversation(), nil
+	}
+
+	historyDirPath, err := getHistoryDirPath()
+	if err != nil {
+		return nil, err
+	}
+
+	conversation, err := history.Latest(historyDirPath)
+	if errors.Is(err, history.ErrNoConversations) {
+		log.Logger.Info().Msg("No previous conversation found. Starting a new one.")
+		return history.NewConversation(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Logger.Info().Str("conversation", conversation.ID).Int("messages", len(conversation.Messages)).Msg("Continuing conversation.")
+	return conversation, nil
+}
+
+func withoutSystemMessages(messages []llm.ChatCompletionMessage) []llm.ChatCompletionMessage {
+	var kept []llm.ChatCompletionMessage
+	for _, message := range messages {
+		if message.Role != "system" {
+			kept = append(kept, message)
+		}
+	}
+
+	return kept
+}
+
+func getHistoryDirPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory for history: %w", err)
+	}
+
+	return filepath.Join(homeDir, ".llm", "history"), nil
+}
+
 func getTemplateDirPath() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
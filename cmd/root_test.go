@@ -2,13 +2,33 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/flacial/llm/internal/history"
+	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/internal/tokens"
+	"github.com/flacial/llm/internal/utils"
+	"github.com/flacial/llm/pkg/llm"
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -93,6 +113,40 @@ func newMockStreamingHTTPClient(statusCode int, chunks []string) *http.Client {
 	}
 }
 
+// contextReader blocks until ctx is done, like a response body that stopped sending
+type contextReader struct {
+	ctx context.Context
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	<-r.ctx.Done()
+	return 0, r.ctx.Err()
+}
+
+// newRecordingHTTPClient answers like newMockHTTPClient and keeps the request body for inspection
+func newRecordingHTTPClient(body string, requestBody *string) *http.Client {
+	return &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			sent, _ := io.ReadAll(req.Body)
+			*requestBody = string(sent)
+
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
+				Header:     make(http.Header),
+			}
+		}),
+	}
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
 type roundTripFunc func(req *http.Request) *http.Response
 
 // The HTTP client transport require a function that implements the RoundTrip interface. This is just a mock that
@@ -105,8 +159,19 @@ func TestMain(m *testing.M) {
 	originalZerologGlobalLevel := zerolog.GlobalLevel()
 	zerolog.SetGlobalLevel(zerolog.Disabled)
 
+	// Keep the config, templates, history, and logs the commands create out of the real home
+	tempHome, err := os.MkdirTemp("", "llm-test-home")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", tempHome)
+	os.Setenv("XDG_CONFIG_HOME", filepath.Join(tempHome, ".config"))
+	os.Setenv("XDG_STATE_HOME", filepath.Join(tempHome, ".local", "state"))
+
 	code := m.Run()
 
+	os.RemoveAll(tempHome)
+
 	// Disable all kind of logging
 	zerolog.SetGlobalLevel(originalZerologGlobalLevel)
 
@@ -164,6 +229,64 @@ func TestRootCommand(t *testing.T) {
 		}
 	})
 
+	t.Run("show reasoning", func(t *testing.T) {
+		viper.Set("show_reasoning", true)
+		defer viper.Set("show_reasoning", nil)
+
+		httpClient = newMockStreamingHTTPClient(http.StatusOK, []string{
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"reasoning": "Let me "}}]}`,
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"reasoning": "think."}}]}`,
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"content": "The answer."}}]}`,
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {}, "finish_reason": "stop"}]}`,
+		})
+
+		output, err := executeCommand(rootCmd, "--stream-mode", "--raw", "Tell me a story.")
+		if err != nil {
+			t.Fatalf("streaming command failed: %v", err)
+		}
+		if expected := "Reasoning:\nLet me think.\n\nThe answer."; !strings.Contains(output, expected) {
+			t.Errorf("expected the streamed reasoning ahead of the answer, %q, but got %q", expected, output)
+		}
+
+		httpClient = newMockHTTPClient(http.StatusOK, `{"choices": [{"index": 0, "message": {"role": "assistant", "content": "The answer.", "reasoning": "Let me think."}}]}`)
+		output, err = executeCommand(rootCmd, "--stream-mode=false", "--raw", "Tell me a story.")
+		if err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		if expected := "Reasoning:\nLet me think.\n\nThe answer."; !strings.Contains(output, expected) {
+			t.Errorf("expected the reasoning ahead of the answer, %q, but got %q", expected, output)
+		}
+
+		viper.Set("show_reasoning", false)
+		output, err = executeCommand(rootCmd, "--stream-mode=false", "--raw", "Tell me a story.")
+		if err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		if strings.Contains(output, "Let me think.") {
+			t.Errorf("expected the reasoning to stay hidden, but got %q", output)
+		}
+	})
+
+	t.Run("think tags are split from the answer", func(t *testing.T) {
+		viper.Set("reasoning.think_tags", true)
+		defer viper.Set("reasoning.think_tags", false)
+
+		httpClient = newMockStreamingHTTPClient(http.StatusOK, []string{
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"content": "<thi"}}]}`,
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"content": "nk>Mulling it over</th"}}]}`,
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"content": "ink>\n\nThe answer."}}]}`,
+		})
+
+		output, err := executeCommand(rootCmd, "--stream-mode", "Tell me a story.")
+		if err != nil {
+			t.Fatalf("streaming command failed: %v", err)
+		}
+
+		if !strings.Contains(output, "The answer.") || strings.Contains(output, "Mulling") || strings.Contains(output, "think>") {
+			t.Errorf("expected only the answer, but got %q", output)
+		}
+	})
+
 	t.Run("empty prompt", func(t *testing.T) {
 		httpClient = newMockHTTPClient(http.StatusOK, mockResponse)
 
@@ -174,36 +297,4343 @@ func TestRootCommand(t *testing.T) {
 		}
 	})
 
-	t.Run("blocking prompt", func(t *testing.T) {
-		httpClient = newMockHTTPClient(http.StatusOK, mockResponse)
+	t.Run("custom base url", func(t *testing.T) {
+		viper.Set("base_url", "http://localhost:11434/v1/")
+		defer viper.Set("base_url", "")
 
-		output, err := executeCommand(rootCmd, "--stream-mode=false", "Why do we use Golang instead of Rust?")
+		var requestedURL string
+		httpClient = &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) *http.Response {
+				requestedURL = req.URL.String()
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewBufferString(mockResponse)),
+					Header:     make(http.Header),
+				}
+			}),
+		}
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "Hello"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+
+		expected := "http://localhost:11434/v1/chat/completions"
+		if requestedURL != expected {
+			t.Errorf("expected request to %q, but got %q", expected, requestedURL)
+		}
+	})
+
+	t.Run("hooks", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("the hooks below are sh commands")
+		}
+
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+		defer func() {
+			viper.Set(hookPreRequest, nil)
+			viper.Set(hookPostResponse, nil)
+			viper.Set("hooks.timeout", nil)
+		}()
+
+		viper.Set(hookPreRequest, "tr a-z A-Z")
+		viper.Set(hookPostResponse, "cat; echo ' (formatted)'")
+		attachment := filepath.Join(t.TempDir(), "notes.txt")
+		if err := os.WriteFile(attachment, []byte("secret notes"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			attachFlag = nil
+			systemFlag = ""
+		}()
+
+		output, err := executeCommand(rootCmd, "--stream-mode", "--raw", "--system", "be quiet", "--attach", attachment, "why so loud?")
 		if err != nil {
 			t.Fatalf("root command failed: %v", err)
 		}
+		for _, expected := range []string{`"content":"WHY SO LOUD?"`, `"content":"BE QUIET"`, "SECRET NOTES"} {
+			if !strings.Contains(requestBody, expected) {
+				t.Errorf("expected %q to go through the pre-request hook, but got %q", expected, requestBody)
+			}
+		}
+		attachFlag = nil
+		systemFlag = ""
+		if strings.Contains(requestBody, `"stream":true`) {
+			t.Errorf("expected a post-response hook to wait for the whole answer, but got %q", requestBody)
+		}
+		if expected := "Because we are hardcore typing machines (formatted)"; !strings.Contains(output, expected) {
+			t.Errorf("expected output to contain %q, but got %q", expected, output)
+		}
 
-		expected := "Because we are hardcore typing machines"
+		viper.Set(hookPreRequest, nil)
+		viper.Set(hookPostResponse, "exit 3")
+		output, err = executeCommand(rootCmd, "--raw", "Hello")
+		if err != nil {
+			t.Fatalf("expected a failing post-response hook to keep the answer, but got %v", err)
+		}
+		if !strings.Contains(output, "Because we are hardcore typing machines") || !strings.Contains(output, "Post-response hook failed") {
+			t.Errorf("expected the answer as received and a warning, but got %q", output)
+		}
+
+		viper.Set(hookPostResponse, nil)
+		viper.Set(hookPreRequest, "exit 3")
+		if _, err := executeCommand(rootCmd, "Hello"); err == nil || !strings.Contains(err.Error(), "hooks.pre_request") {
+			t.Errorf("expected a failing hook to stop the request, but got %v", err)
+		}
+
+		viper.Set(hookPreRequest, "sleep 5")
+		viper.Set("hooks.timeout", "50ms")
+		if _, err := executeCommand(rootCmd, "Hello"); err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("expected the hook to time out, but got %v", err)
+		}
+	})
+
+	t.Run("piped output is blocking", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+
+		streamMode := rootCmd.Flags().Lookup("stream-mode")
+		wasChanged, previousValue := streamMode.Changed, streamingModeFlag
+		defer func() {
+			streamMode.Changed, streamingModeFlag = wasChanged, previousValue
+		}()
+		streamMode.Changed = false
+		streamingModeFlag = true
+
+		// executeCommand swaps stdout for a pipe, like running under | or > in a shell
+		output, err := executeCommand(rootCmd, "Any tips?")
+		if err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		if strings.Contains(requestBody, `"stream":true`) {
+			t.Errorf("expected a blocking request when stdout is piped, but got %q", requestBody)
+		}
+		if !strings.Contains(output, "Because we are hardcore typing machines") {
+			t.Errorf("expected the answer, but got %q", output)
+		}
+
+		httpClient = newMockStreamingHTTPClient(http.StatusOK, []string{
+			`{"choices": [{"index": 0, "delta": {"content": "Streamed anyway"}}]}`,
+		})
+		output, err = executeCommand(rootCmd, "--stream-mode", "Any tips?")
+		if err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		if !strings.Contains(output, "Streamed anyway") {
+			t.Errorf("expected an explicit --stream-mode to stream into the pipe, but got %q", output)
+		}
+	})
+
+	t.Run("piped output is raw", func(t *testing.T) {
+		httpClient = newMockHTTPClient(http.StatusOK, `{"choices": [{"index": 0, "message": {"role": "assistant", "content": "Use **go vet**"}}]}`)
+
+		output, err := executeCommand(rootCmd, "--stream-mode=false", "Any tips?")
+		if err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+
+		expected := "Use **go vet**\n"
 		if !strings.Contains(output, expected) {
 			t.Errorf("expected output to contain %q, but got %q", expected, output)
 		}
 	})
-}
 
-func TestModelsCommand(t *testing.T) {
-	viper.Reset()
-	cobra.OnInitialize(initConfig)
+	t.Run("image input", func(t *testing.T) {
+		imagePath := filepath.Join(t.TempDir(), "pixel.png")
+		png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+		if err := os.WriteFile(imagePath, png, 0644); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
 
-	rootCmd.AddCommand(ModelsCmd)
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
 
-	t.Run("list models", func(test *testing.T) {
-		output, err := executeCommand(rootCmd, "models")
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "--image", imagePath, "What's this?"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		imageFlag = nil
+
+		for _, expected := range []string{`"type":"text","text":"What's this?"`, `"url":"data:image/png;base64,`} {
+			if !strings.Contains(requestBody, expected) {
+				t.Errorf("expected request to contain %q, but got %q", expected, requestBody)
+			}
+		}
+	})
+
+	t.Run("stop sequences", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "--stop", "END", "--stop", "\n\n", "Count to ten"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		stopFlag = nil
+
+		expected := `"stop":["END","\n\n"]`
+		if !strings.Contains(requestBody, expected) {
+			t.Errorf("expected request to contain %q, but got %q", expected, requestBody)
+		}
+	})
+
+	t.Run("prefix and suffix wrap the templated prompt", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+
+		attachmentPath := filepath.Join(t.TempDir(), "notes.txt")
+		if err := os.WriteFile(attachmentPath, []byte("attached notes"), 0644); err != nil {
+			t.Fatalf("failed to write attachment: %v", err)
+		}
+
+		viper.Set("templates.inline", map[string]any{"question": map[string]any{"user": "Q: {{.UserPrompt}}"}})
+		defer viper.Set("templates.inline", nil)
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "-t", "question", "--attach", attachmentPath, "--prefix", "Be brief.", "--suffix", "Answer in Spanish.", "Why is the sky blue?"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		templateFlag = ""
+		attachFlag = nil
+		prefixFlag = ""
+		suffixFlag = ""
+
+		attachment := strings.Index(requestBody, "attached notes")
+		expected := `"content":"Be brief.\n\nQ: Why is the sky blue?\n\nAnswer in Spanish."`
+		prompt := strings.Index(requestBody, expected)
+		if prompt == -1 {
+			t.Fatalf("expected request to contain %q, but got %q", expected, requestBody)
+		}
+		if attachment == -1 || attachment > prompt {
+			t.Errorf("expected the attachment to stay ahead of the wrapped prompt, but got %q", requestBody)
+		}
+	})
+
+	t.Run("templates can place attachments", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+
+		attachmentPath := filepath.Join(t.TempDir(), "notes.txt")
+		if err := os.WriteFile(attachmentPath, []byte("attached notes"), 0644); err != nil {
+			t.Fatalf("failed to write attachment: %v", err)
+		}
+
+		viper.Set("templates.inline", map[string]any{"files": map[string]any{"user": "{{.UserPrompt}}\n\nFiles:\n{{.Attachments}}"}})
+		defer viper.Set("templates.inline", nil)
+
+		_, err := executeCommand(rootCmd, "--stream-mode=false", "--no-system", "-t", "files", "--attach", attachmentPath, "Summarize")
+		templateFlag = ""
+		attachFlag = nil
+		noSystemFlag = false
 		if err != nil {
-			test.Fatalf("models command failed: %v", err)
+			t.Fatalf("root command failed: %v", err)
 		}
 
-		expected := "Available models:\ngpt-3.5-turbo\ngpt-4"
-		if !strings.Contains(output, expected) {
-			test.Errorf("expected output to contain %q, but got %q", expected, output)
+		var request llm.ChatCompletionRequest
+		if err := json.Unmarshal([]byte(requestBody), &request); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if len(request.Messages) != 1 {
+			t.Fatalf("expected the attachment inside the prompt rather than a message of its own, but got %+v", request.Messages)
+		}
+		expected := "Summarize\n\nFiles:\nFile: " + attachmentPath + "\n```txt\nattached notes\n```"
+		if request.Messages[0].Content != expected {
+			t.Errorf("expected %q, but got %q", expected, request.Messages[0].Content)
+		}
+	})
+
+	t.Run("max tokens", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+		defer viper.Set("max_tokens", nil)
+
+		// viper.Reset dropped the flag bindings, so the value the flag would set goes in directly
+		viper.Set("max_tokens", 256)
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "Hello"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		if !strings.Contains(requestBody, `"max_tokens":256`) {
+			t.Errorf("expected max_tokens in the request, but got %q", requestBody)
+		}
+
+		viper.Set("max_tokens", -5)
+		requestBody = ""
+		_, err := executeCommand(rootCmd, "--stream-mode=false", "Hello")
+		if err == nil || !strings.Contains(err.Error(), "invalid max tokens -5") {
+			t.Errorf("expected a negative max_tokens to be rejected, but got %v", err)
+		}
+		if requestBody != "" {
+			t.Errorf("expected nothing to be sent, but got %q", requestBody)
+		}
+	})
+
+	t.Run("sampling parameters", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+		keys := []string{"top_p", "frequency_penalty", "presence_penalty"}
+		defer func() {
+			for _, key := range keys {
+				viper.Set(key, nil)
+			}
+		}()
+
+		viper.Set("top_p", 0.9)
+		viper.Set("frequency_penalty", 0.5)
+		viper.Set("presence_penalty", -0.5)
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "Hello"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		for _, expected := range []string{`"top_p":0.9`, `"frequency_penalty":0.5`, `"presence_penalty":-0.5`} {
+			if !strings.Contains(requestBody, expected) {
+				t.Errorf("expected %s in the request, but got %q", expected, requestBody)
+			}
+		}
+
+		viper.Set("top_p", 1.5)
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "Hello"); err == nil || !strings.Contains(err.Error(), "invalid top_p") {
+			t.Errorf("expected an out of range top_p to be rejected, but got %v", err)
+		}
+	})
+
+	t.Run("zeros from the config are sent", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+
+		keys := []string{"temperature", "top_p", "frequency_penalty", "presence_penalty", "seed"}
+		for _, key := range keys {
+			viper.Set(key, 0)
+		}
+		defer func() {
+			for _, key := range keys {
+				viper.Set(key, nil)
+			}
+		}()
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "Hello"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		for _, key := range keys {
+			if !strings.Contains(requestBody, `"`+key+`":0`) {
+				t.Errorf("expected %s to be sent as 0, but got %q", key, requestBody)
+			}
+		}
+
+		t.Setenv("LLM_TEMPERATURE", "0")
+		viper.Set("temperature", nil)
+		viper.AutomaticEnv()
+		viper.SetEnvPrefix("llm")
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "Hello"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		if !strings.Contains(requestBody, `"temperature":0`) {
+			t.Errorf("expected LLM_TEMPERATURE=0 to be sent, but got %q", requestBody)
+		}
+	})
+
+	t.Run("default system prompt", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+
+		systemPromptPath := filepath.Join(t.TempDir(), "system.md")
+		if err := os.WriteFile(systemPromptPath, []byte("Answer in French.\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		viper.Set("default_system_prompt", "Be concise.")
+		viper.Set("templates.inline", map[string]any{
+			"pirate": map[string]any{"system": "Talk like a pirate.", "user": "{{.UserPrompt}}"},
+			"plain":  map[string]any{"user": "{{.UserPrompt}}"},
+		})
+		defer func() {
+			viper.Set("default_system_prompt", nil)
+			viper.Set("templates.inline", nil)
+			viper.Set("continue", nil)
+		}()
+
+		systemMessages := func(args ...string) []string {
+			t.Helper()
+			_, err := executeCommand(rootCmd, append([]string{"--stream-mode=false"}, args...)...)
+			templateFlag = ""
+			systemFlag = ""
+			noSystemFlag = false
+			if err != nil {
+				t.Fatalf("root command failed: %v", err)
+			}
+
+			var request llm.ChatCompletionRequest
+			if err := json.Unmarshal([]byte(requestBody), &request); err != nil {
+				t.Fatalf("failed to decode request: %v", err)
+			}
+
+			var contents []string
+			for _, message := range request.Messages {
+				if message.Role == "system" {
+					contents = append(contents, message.Content)
+				}
+			}
+			return contents
+		}
+
+		tests := []struct {
+			name     string
+			args     []string
+			expected []string
+		}{
+			{"default", []string{"Hello"}, []string{"Be concise."}},
+			{"template without a system message", []string{"-t", "plain", "Hello"}, []string{"Be concise."}},
+			{"template system message", []string{"-t", "pirate", "Hello"}, []string{"Talk like a pirate."}},
+			{"--system", []string{"-S", "Be verbose.", "Hello"}, []string{"Be verbose."}},
+			{"--system over the template", []string{"-t", "pirate", "-S", "Be verbose.", "Hello"}, []string{"Be verbose."}},
+			{"--system @file", []string{"-S", "@" + systemPromptPath, "Hello"}, []string{"Answer in French."}},
+			{"--system @file over the template", []string{"-t", "pirate", "-S", "@" + systemPromptPath, "Hello"}, []string{"Answer in French."}},
+			{"--no-system", []string{"--no-system", "Hello"}, nil},
+			{"--no-system drops the template's", []string{"-t", "pirate", "--no-system", "Hello"}, nil},
+		}
+
+		for _, test := range tests {
+			if got := systemMessages(test.args...); !slices.Equal(got, test.expected) {
+				t.Errorf("%s: expected system messages %q, but got %q", test.name, test.expected, got)
+			}
+		}
+
+		systemMessages("Hello")
+		viper.Set("continue", true)
+		if got := systemMessages("Hello again"); !slices.Equal(got, []string{"Be concise."}) {
+			t.Errorf("expected a continued conversation to keep a single system message, but got %q", got)
+		}
+
+		for _, args := range [][]string{{"-S", "Be verbose.", "And again"}, {"-t", "pirate", "Once more"}} {
+			systemMessages(args...)
+			var request llm.ChatCompletionRequest
+			if err := json.Unmarshal([]byte(requestBody), &request); err != nil {
+				t.Fatalf("failed to decode request: %v", err)
+			}
+			var systemAt []int
+			for i, message := range request.Messages {
+				if message.Role == "system" {
+					systemAt = append(systemAt, i)
+				}
+			}
+			if !slices.Equal(systemAt, []int{0}) || len(request.Messages) < 4 {
+				t.Errorf("%q: expected the new system message to replace the old one at the start, but got %+v", args, request.Messages)
+			}
+		}
+
+		_, err := executeCommand(rootCmd, "-S", "Be verbose.", "--no-system", "Hello")
+		systemFlag = ""
+		noSystemFlag = false
+		if err == nil {
+			t.Error("expected an error for --system with --no-system")
+		}
+	})
+
+	t.Run("conversation history", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+		defer func() {
+			viper.Set("continue", nil)
+			newConversationFlag = false
+		}()
+
+		userPrompts := func() []string {
+			t.Helper()
+			var request llm.ChatCompletionRequest
+			if err := json.Unmarshal([]byte(requestBody), &request); err != nil {
+				t.Fatalf("failed to decode request: %v", err)
+			}
+
+			var contents []string
+			for _, message := range request.Messages {
+				if message.Role != "system" {
+					contents = append(contents, message.Content)
+				}
+			}
+			return contents
+		}
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "First question"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+
+		// viper.Reset dropped the flag bindings, so the value --continue would set goes in directly
+		viper.Set("continue", true)
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "Second question"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		expected := []string{"First question", "Because we are hardcore typing machines", "Second question"}
+		if got := userPrompts(); !slices.Equal(got, expected) {
+			t.Errorf("expected the earlier turn to be sent again, but got %q", got)
+		}
+
+		historyDirPath, err := getHistoryDirPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+		latest, err := history.Latest(historyDirPath)
+		if err != nil {
+			t.Fatalf("failed to load the latest conversation: %v", err)
+		}
+		if last := latest.Messages[len(latest.Messages)-1]; len(latest.Messages) < 4 || last.Role != "assistant" {
+			t.Errorf("expected the continued conversation to be saved with the new answer, but got %+v", latest.Messages)
+		}
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "--new", "Fresh start"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		if got := userPrompts(); !slices.Equal(got, []string{"Fresh start"}) {
+			t.Errorf("expected --new to leave the history out, but got %q", got)
+		}
+	})
+
+	t.Run("per-model defaults", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+
+		viper.Set("max_tokens", 100)
+		viper.Set("models.defaults", map[string]any{
+			"fast":                  map[string]any{"temperature": 0.9},
+			"google/gemini-2.5-pro": map[string]any{"temperature": 0.3, "max_tokens": 500, "top_p": 0.8},
+		})
+		// viper.Reset dropped the flag bindings, so the values flags would set go in directly
+		defer func() {
+			viper.Set("max_tokens", nil)
+			viper.Set("models.defaults", nil)
+			viper.Set("templates.inline", nil)
+			viper.Set("model", nil)
+			viper.Set("top_p", nil)
+			templateFlag = ""
+			topPFlag = 0
+			rootCmd.Flags().Lookup("top-p").Changed = false
+		}()
+
+		sentRequest := func(args ...string) llm.ChatCompletionRequest {
+			t.Helper()
+			if _, err := executeCommand(rootCmd, append([]string{"--stream-mode=false"}, args...)...); err != nil {
+				t.Fatalf("root command failed: %v", err)
+			}
+
+			var request llm.ChatCompletionRequest
+			if err := json.Unmarshal([]byte(requestBody), &request); err != nil {
+				t.Fatalf("failed to decode request: %v", err)
+			}
+			return request
+		}
+
+		viper.Set("model", "google/gemini-2.5-pro")
+		request := sentRequest("Hello")
+		if request.Temperature == nil || *request.Temperature != 0.3 || request.MaxTokens == nil || *request.MaxTokens != 500 || request.TopP == nil || *request.TopP != 0.8 {
+			t.Errorf("expected the model defaults over the global config, but got %q", requestBody)
+		}
+
+		viper.Set("model", "openai/gpt-4.1-nano")
+		request = sentRequest("Hello")
+		if request.Temperature == nil || *request.Temperature != 0.9 || request.MaxTokens == nil || *request.MaxTokens != 100 {
+			t.Errorf("expected defaults keyed by an alias to apply, but got %q", requestBody)
+		}
+
+		viper.Set("templates.inline", map[string]any{"careful": map[string]any{"user": "{{.UserPrompt}}", "temperature": 0.1}})
+		viper.Set("model", "google/gemini-2.5-pro")
+		viper.Set("top_p", 0.5)
+		request = sentRequest("-t", "careful", "--top-p", "0.5", "Hello")
+		if request.Temperature == nil || *request.Temperature != 0.1 {
+			t.Errorf("expected the template to win over the model defaults, but got %q", requestBody)
+		}
+		if request.TopP == nil || *request.TopP != 0.5 {
+			t.Errorf("expected the flag to win over the model defaults, but got %q", requestBody)
+		}
+	})
+
+	t.Run("repeat", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+
+		defer func() {
+			viper.Set("model", nil)
+			viper.Set("temperature", nil)
+			repeatFlag = false
+			modelFlag = ""
+			temperatureFlag = 0
+			for _, name := range []string{"repeat", "model", "temperature"} {
+				rootCmd.Flags().Lookup(name).Changed = false
+			}
+		}()
+
+		sentRequest := func(args ...string) llm.ChatCompletionRequest {
+			t.Helper()
+			if _, err := executeCommand(rootCmd, append([]string{"--stream-mode=false"}, args...)...); err != nil {
+				t.Fatalf("root command failed: %v", err)
+			}
+
+			var request llm.ChatCompletionRequest
+			if err := json.Unmarshal([]byte(requestBody), &request); err != nil {
+				t.Fatalf("failed to decode request: %v", err)
+			}
+			return request
+		}
+		lastPrompt := func(request llm.ChatCompletionRequest) string {
+			return request.Messages[len(request.Messages)-1].Content
+		}
+
+		viper.Set("model", "openai/gpt-4o")
+		viper.Set("temperature", 0.4)
+		sentRequest("Tell me a joke.")
+
+		viper.Set("model", nil)
+		viper.Set("temperature", nil)
+		request := sentRequest("--repeat")
+		if request.Model != "openai/gpt-4o" || lastPrompt(request) != "Tell me a joke." {
+			t.Errorf("expected the last prompt and model to be sent again, but got %q", requestBody)
+		}
+		if request.Temperature == nil || *request.Temperature != 0.4 {
+			t.Errorf("expected the last temperature to be kept, but got %q", requestBody)
+		}
+
+		// viper.Reset dropped the flag bindings, so the values flags would set go in directly
+		viper.Set("model", "anthropic/claude-sonnet-4")
+		viper.Set("temperature", 1.2)
+		request = sentRequest("-r", "-m", "anthropic/claude-sonnet-4", "--temperature", "1.2")
+		if request.Model != "anthropic/claude-sonnet-4" || lastPrompt(request) != "Tell me a joke." {
+			t.Errorf("expected the last prompt with the new model, but got %q", requestBody)
+		}
+		if request.Temperature == nil || *request.Temperature != 1.2 {
+			t.Errorf("expected the new temperature, but got %q", requestBody)
+		}
+
+		// The rest of the request goes out again as it was, prefill included
+		repeatFlag = false
+		for _, name := range []string{"repeat", "model", "temperature"} {
+			rootCmd.Flags().Lookup(name).Changed = false
+		}
+		defer func() {
+			stopFlag = nil
+			prefillFlag = ""
+			seedFlag = 0
+		}()
+		viper.Set("max_tokens", 100)
+		sentRequest("--stop", "END", "--prefill", "Knock knock.", "Tell me a knock-knock joke.")
+		viper.Set("max_tokens", nil)
+		stopFlag = nil
+		prefillFlag = ""
+
+		request = sentRequest("--repeat")
+		if request.MaxTokens == nil || *request.MaxTokens != 100 || !slices.Equal(request.Stop, []string{"END"}) {
+			t.Errorf("expected the last max tokens and stop sequences to be kept, but got %q", requestBody)
+		}
+		if len(request.Messages) < 2 || lastPrompt(request) != "Knock knock." || request.Messages[len(request.Messages)-2].Content != "Tell me a knock-knock joke." {
+			t.Errorf("expected the last prompt and its prefill, but got %q", requestBody)
+		}
+		if request.Temperature == nil || *request.Temperature != 1.2 {
+			t.Errorf("expected the last temperature to be kept, but got %q", requestBody)
+		}
+
+		if _, err := executeCommand(rootCmd, "--repeat", "--seed", "3"); err == nil || !strings.Contains(err.Error(), "--seed") {
+			t.Errorf("expected --repeat to reject --seed, but got %v", err)
+		}
+		seedFlag = 0
+
+		if _, err := executeCommand(rootCmd, "--repeat", "Another prompt"); err == nil {
+			t.Error("expected an error when --repeat gets a new prompt")
+		}
+
+		defer func() { batchFlag = "" }()
+		if _, err := executeCommand(rootCmd, "--repeat", "--batch", "prompts.txt"); err == nil || !strings.Contains(err.Error(), "--batch") {
+			t.Errorf("expected --repeat to reject --batch, but got %v", err)
+		}
+	})
+
+	t.Run("prefill", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+		defer func() { prefillFlag = "" }()
+
+		output, err := executeCommand(rootCmd, "--stream-mode=false", "--prefill", "Well, ", "Why?")
+		if err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+
+		expected := `{"role":"user","content":"Why?"},{"role":"assistant","content":"Well, "}]`
+		if !strings.Contains(requestBody, expected) {
+			t.Errorf("expected request to end with %q, but got %q", expected, requestBody)
+		}
+
+		if !strings.Contains(output, "Well, Because we are hardcore typing machines") {
+			t.Errorf("expected the prefill ahead of the answer, but got %q", output)
+		}
+
+		historyDirPath, err := getHistoryDirPath()
+		if err != nil {
+			t.Fatalf("failed to get history dir: %v", err)
+		}
+		conversation, err := history.Latest(historyDirPath)
+		if err != nil {
+			t.Fatalf("failed to load history: %v", err)
+		}
+		lastMessages := conversation.Messages[len(conversation.Messages)-2:]
+		if lastMessages[0].Role != "user" || lastMessages[1].Content != "Well, Because we are hardcore typing machines" {
+			t.Errorf("expected the prefill to be saved as part of the answer, but got %+v", lastMessages)
+		}
+
+		viper.Set("prefill.echo", false)
+		defer viper.Set("prefill.echo", nil)
+
+		output, err = executeCommand(rootCmd, "--stream-mode=false", "--prefill", "Well, ", "Why?")
+		if err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		if strings.Contains(output, "Well, ") {
+			t.Errorf("expected the prefill to be left out, but got %q", output)
+		}
+	})
+
+	t.Run("multiple choices", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(`{
+			"id": "chat-choices-test",
+			"choices": [
+				{"index": 0, "message": {"role": "assistant", "content": "First take"}, "finish_reason": "stop"},
+				{"index": 1, "message": {"role": "assistant", "content": "Second take"}, "finish_reason": "stop"}
+			]
+		}`, &requestBody)
+		defer func() {
+			choicesFlag = 1
+			copyChoiceFlag = 1
+		}()
+
+		// Streaming is left on to check that it's turned off for several choices
+		output, err := executeCommand(rootCmd, "-n", "2", "--copy-choice", "2", "Name a cat")
+		if err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+
+		if !strings.Contains(requestBody, `"n":2`) || strings.Contains(requestBody, `"stream":true`) {
+			t.Errorf("expected a blocking request for 2 choices, but got %q", requestBody)
+		}
+
+		expected := "## Choice 1\n\nFirst take\n\n## Choice 2\n\nSecond take\n\n"
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q, but got %q", expected, output)
+		}
+
+		historyDirPath, err := getHistoryDirPath()
+		if err != nil {
+			t.Fatalf("failed to get history dir: %v", err)
+		}
+		conversation, err := history.Latest(historyDirPath)
+		if err != nil {
+			t.Fatalf("failed to load history: %v", err)
+		}
+		if answer := conversation.Messages[len(conversation.Messages)-1].Content; answer != "Second take" {
+			t.Errorf("expected the picked choice to be saved, but got %q", answer)
+		}
+
+		if _, err := executeCommand(rootCmd, "-n", "2", "--copy-choice", "3", "Name a cat"); err == nil {
+			t.Errorf("expected an error for a copy choice out of range")
+		}
+	})
+
+	t.Run("no content", func(t *testing.T) {
+		httpClient = newMockHTTPClient(http.StatusOK, `{"id": "chat-empty-test", "choices": []}`)
+
+		_, err := executeCommand(rootCmd, "--stream-mode=false", "Hello")
+		if !errors.Is(err, ErrNoContent) || ExitCode(err) != ExitNoContent {
+			t.Errorf("expected a no content error for empty choices, but got %v", err)
+		}
+
+		httpClient = newMockStreamingHTTPClient(http.StatusOK, []string{
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {}, "finish_reason": "length"}]}`,
+		})
+
+		output, err := executeCommand(rootCmd, "--stream-mode=true", "Hello")
+		if !errors.Is(err, ErrNoContent) || ExitCode(err) != ExitNoContent {
+			t.Errorf("expected a no content error for an empty stream, but got %v", err)
+		}
+		if !strings.Contains(output, "Response truncated") {
+			t.Errorf("expected the finish reason to be explained, but got %q", output)
+		}
+
+		if ExitCode(errors.New("boom")) != 1 {
+			t.Errorf("expected other errors to exit with 1")
+		}
+	})
+
+	t.Run("trace file", func(t *testing.T) {
+		tracePath := filepath.Join(t.TempDir(), "trace.log")
+		defer func() { traceFileFlag = "" }()
+
+		httpClient = newMockStreamingHTTPClient(http.StatusOK, []string{
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"content": "Traced"}}]}`,
+		})
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=true", "--trace-file", tracePath, "Trace me"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+
+		trace, err := os.ReadFile(tracePath)
+		if err != nil {
+			t.Fatalf("failed to read trace file: %v", err)
+		}
+
+		for _, expected := range []string{
+			"POST https://openrouter.ai/api/v1/chat/completions",
+			"Authorization: Bearer ****_key",
+			`"content":"Trace me"`,
+			"<<< 200 OK",
+			`data: {"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"content": "Traced"}}]}`,
+			"data: [DONE]",
+		} {
+			if !strings.Contains(string(trace), expected) {
+				t.Errorf("expected trace to contain %q, but got %q", expected, trace)
+			}
+		}
+		if strings.Contains(string(trace), "super_secret_key") {
+			t.Errorf("expected the API key to be masked, but got %q", trace)
+		}
+	})
+
+	t.Run("provider routing", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "Hello"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+
+		if strings.Contains(requestBody, `"provider"`) {
+			t.Errorf("expected no provider object without routing options, but got %q", requestBody)
+		}
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "--provider-order", "anthropic,openai", "--no-fallbacks", "--data-collection", "deny", "Hello"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		providerOrderFlag = nil
+		noFallbacksFlag = false
+		dataCollectionFlag = ""
+
+		expected := `"provider":{"order":["anthropic","openai"],"allow_fallbacks":false,"data_collection":"deny"}`
+		if !strings.Contains(requestBody, expected) {
+			t.Errorf("expected request to contain %q, but got %q", expected, requestBody)
+		}
+	})
+
+	t.Run("fallback models", func(t *testing.T) {
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "--fallback-model", "fast", "--fallback-model", "openai/gpt-4o", "Hello"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		fallbackModelFlag = nil
+
+		expected := `"models":["` + defaultModelAliases["fast"] + `","openai/gpt-4o"]`
+		if !strings.Contains(requestBody, expected) {
+			t.Errorf("expected request to contain %q, but got %q", expected, requestBody)
+		}
+	})
+
+	t.Run("api key never reaches the logs", func(t *testing.T) {
+		logPath := filepath.Join(t.TempDir(), "llm.log")
+		viper.Set("debug_mode", true)
+		viper.Set("log_file", logPath)
+		defer func() {
+			viper.Set("debug_mode", false)
+			viper.Set("log_file", "")
+			zerolog.SetGlobalLevel(zerolog.Disabled)
+		}()
+
+		// Some APIs echo the rejected key back in the error body
+		httpClient = newMockHTTPClient(http.StatusUnauthorized, `{"error": {"message": "invalid key super_secret_key"}}`)
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "Hello"); err == nil {
+			t.Fatalf("expected the request to fail")
+		}
+
+		logged, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatalf("failed to read log file: %v", err)
+		}
+
+		if !strings.Contains(string(logged), "invalid key ****_key") {
+			t.Errorf("expected the masked key in the logs, but got %q", logged)
+		}
+
+		if strings.Contains(string(logged), "super_secret_key") {
+			t.Errorf("expected the api key to be redacted, but got %q", logged)
+		}
+	})
+
+	t.Run("json schema", func(t *testing.T) {
+		schemaPath := filepath.Join(t.TempDir(), "person.schema.json")
+		if err := os.WriteFile(schemaPath, []byte(`{"type": "object", "properties": {"name": {"type": "string"}}}`), 0644); err != nil {
+			t.Fatalf("failed to write schema: %v", err)
+		}
+
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "--json-schema", schemaPath, "Extract the person"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		jsonSchemaFlag = ""
+
+		for _, expected := range []string{`"type":"json_schema"`, `"name":"person"`, `"properties":{"name":{"type":"string"}}`} {
+			if !strings.Contains(requestBody, expected) {
+				t.Errorf("expected request to contain %q, but got %q", expected, requestBody)
+			}
+		}
+	})
+
+	t.Run("json and json schema together", func(t *testing.T) {
+		_, err := executeCommand(rootCmd, "--json", "--json-schema", "schema.json", "Extract the person")
+		jsonFlag = false
+		jsonSchemaFlag = ""
+
+		if err == nil || !strings.Contains(err.Error(), "can't be used together") {
+			t.Errorf("expected a conflicting flags error, but got %v", err)
+		}
+	})
+
+	t.Run("interrupted stream keeps partial output", func(t *testing.T) {
+		httpClient = &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) *http.Response {
+				chunk := `data: {"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"content": "Once upon a"}}]}` + "\n\n"
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					// Reading past the first chunk fails like a body read cut short by Ctrl-C
+					Body:   io.NopCloser(io.MultiReader(strings.NewReader(chunk), errorReader{context.Canceled})),
+					Header: make(http.Header),
+				}
+			}),
+		}
+
+		output, err := executeCommand(rootCmd, "--stream-mode", "Tell me a story.")
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the run to fail with context.Canceled, but got %v", err)
+		}
+
+		if !strings.Contains(output, "Once upon a") {
+			t.Errorf("expected the partial output to be printed, but got %q", output)
+		}
+
+		historyDirPath, err := getHistoryDirPath()
+		if err != nil {
+			t.Fatalf("failed to get history dir: %v", err)
+		}
+
+		conversation, err := history.Latest(historyDirPath)
+		if err != nil {
+			t.Fatalf("failed to load history: %v", err)
+		}
+
+		lastMessage := conversation.Messages[len(conversation.Messages)-1]
+		if lastMessage.Role != "assistant" || lastMessage.Content != "Once upon a" {
+			t.Errorf("expected the partial answer to be saved, but got %+v", lastMessage)
+		}
+	})
+
+	t.Run("request timeout bounds the stream", func(t *testing.T) {
+		viper.Set("request_timeout", "50ms")
+		defer viper.Set("request_timeout", 0)
+
+		httpClient = &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) *http.Response {
+				chunk := `data: {"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"content": "Once upon a"}}]}` + "\n\n"
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					// The stream stalls after the first chunk until the deadline hits
+					Body:   io.NopCloser(io.MultiReader(strings.NewReader(chunk), contextReader{req.Context()})),
+					Header: make(http.Header),
+				}
+			}),
+		}
+
+		output, err := executeCommand(rootCmd, "--stream-mode", "Tell me a story.")
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the run to fail with context.DeadlineExceeded, but got %v", err)
+		}
+
+		if !strings.Contains(output, "Once upon a") {
+			t.Errorf("expected the partial output to be printed, but got %q", output)
+		}
+	})
+
+	t.Run("output file", func(t *testing.T) {
+		defer func() { outputFileFlag = "" }()
+
+		outputPath := filepath.Join(t.TempDir(), "nested", "answer.md")
+
+		httpClient = newMockStreamingHTTPClient(http.StatusOK, []string{
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"content": "Streamed"}}]}`,
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"content": " answer"}}]}`,
+		})
+
+		if _, err := executeCommand(rootCmd, "--stream-mode", "-O", outputPath, "Hi"); err != nil {
+			t.Fatalf("streaming command failed: %v", err)
+		}
+
+		written, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatalf("failed to read output file: %v", err)
+		}
+		if string(written) != "Streamed answer" {
+			t.Errorf("expected %q, but got %q", "Streamed answer", written)
+		}
+
+		httpClient = newMockHTTPClient(http.StatusOK, mockResponse)
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "-O", outputPath, "Hi"); err != nil {
+			t.Fatalf("blocking command failed: %v", err)
+		}
+
+		written, err = os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatalf("failed to read output file: %v", err)
+		}
+		if string(written) != "Because we are hardcore typing machines\n" {
+			t.Errorf("expected the blocking answer, but got %q", written)
+		}
+	})
+
+	t.Run("tee", func(t *testing.T) {
+		defer func() { outputFileFlag = "" }()
+
+		teePath := filepath.Join(t.TempDir(), "answer.md")
+
+		httpClient = newMockStreamingHTTPClient(http.StatusOK, []string{
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"content": "Same"}}]}`,
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"content": " bytes\n\nin both"}}]}`,
+		})
+
+		output, err := executeCommand(rootCmd, "--stream-mode", "--tee", teePath, "Hi")
+		if err != nil {
+			t.Fatalf("streaming command failed: %v", err)
+		}
+
+		written, err := os.ReadFile(teePath)
+		if err != nil {
+			t.Fatalf("failed to read tee file: %v", err)
+		}
+		if string(written) != "Same bytes\n\nin both" || !strings.HasPrefix(output, string(written)) {
+			t.Errorf("expected the terminal and the file to get the same answer, but got %q and %q", output, written)
+		}
+	})
+
+	t.Run("paths expand ~ and environment variables", func(t *testing.T) {
+		defer func() {
+			outputFileFlag = ""
+			promptFileFlag = ""
+		}()
+
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		t.Setenv("LLM_TEST_NOTES", filepath.Join(home, "notes"))
+		if err := os.MkdirAll(filepath.Join(home, "notes"), 0755); err != nil {
+			t.Fatalf("failed to create notes dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(home, "notes", "prompt.txt"), []byte("Hi from a file"), 0644); err != nil {
+			t.Fatalf("failed to write prompt file: %v", err)
+		}
+
+		var requestBody string
+		httpClient = newRecordingHTTPClient(mockResponse, &requestBody)
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "-f", "$LLM_TEST_NOTES/prompt.txt", "-O", "~/answer.md"); err != nil {
+			t.Fatalf("command failed: %v", err)
+		}
+
+		if !strings.Contains(requestBody, "Hi from a file") {
+			t.Errorf("expected the prompt file to be sent, but got %s", requestBody)
+		}
+		if _, err := os.Stat(filepath.Join(home, "answer.md")); err != nil {
+			t.Errorf("expected the output file in the home directory: %v", err)
+		}
+	})
+
+	t.Run("tools", func(t *testing.T) {
+		toolsPath := filepath.Join(t.TempDir(), "tools.json")
+		toolsJSON := `[
+			{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}}}},
+			{"name": "get_time", "description": "Current time in a timezone"}
+		]`
+		if err := os.WriteFile(toolsPath, []byte(toolsJSON), 0644); err != nil {
+			t.Fatalf("failed to write tools: %v", err)
+		}
+		defer func() { toolsFlag = "" }()
+
+		var requestBody string
+		httpClient = newRecordingHTTPClient(`{
+			"id": "chat-tools-test",
+			"choices": [{
+				"index": 0,
+				"message": {
+					"role": "assistant",
+					"content": null,
+					"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\": \"Paris\"}"}}]
+				},
+				"finish_reason": "tool_calls"
+			}]
+		}`, &requestBody)
+
+		output, err := executeCommand(rootCmd, "--stream-mode=true", "--tools", toolsPath, "Weather in Paris?")
+		if err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+
+		var request llm.ChatCompletionRequest
+		if err := json.Unmarshal([]byte(requestBody), &request); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if request.Stream {
+			t.Errorf("expected tools to force a blocking request")
+		}
+		if len(request.Tools) != 2 || request.Tools[1].Type != "function" || request.Tools[1].Function.Name != "get_time" {
+			t.Errorf("expected both tools to be sent as functions, but got %q", requestBody)
+		}
+
+		for _, expected := range []string{`"name": "get_weather"`, `"arguments": "{\"city\": \"Paris\"}"`, `"id": "call_1"`} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("expected output to contain %q, but got %q", expected, output)
+			}
+		}
+
+		if err := os.WriteFile(toolsPath, []byte(`[{"description": "nameless"}]`), 0644); err != nil {
+			t.Fatalf("failed to write tools: %v", err)
+		}
+		if _, err := executeCommand(rootCmd, "--tools", toolsPath, "Hi"); err == nil || !strings.Contains(err.Error(), "missing a function name") {
+			t.Errorf("expected an error for a tool without a name, but got %v", err)
+		}
+	})
+
+	t.Run("web search", func(t *testing.T) {
+		defer func() { webFlag = false }()
+		defer viper.Set("web.mode", webModePlugin)
+
+		citedResponse := `{
+			"id": "chat-web-test",
+			"choices": [{
+				"index": 0,
+				"message": {
+					"role": "assistant",
+					"content": "Go 1.24 is out.",
+					"annotations": [
+						{"type": "url_citation", "url_citation": {"url": "https://go.dev/doc/go1.24", "title": "Go 1.24 Release Notes"}},
+						{"type": "url_citation", "url_citation": {"url": "https://go.dev/doc/go1.24", "title": "Go 1.24 Release Notes"}}
+					]
+				},
+				"finish_reason": "stop"
+			}]
+		}`
+
+		var requestBody string
+		httpClient = newRecordingHTTPClient(citedResponse, &requestBody)
+
+		output, err := executeCommand(rootCmd, "--stream-mode=false", "--web", "Latest Go?")
+		if err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+
+		var request llm.ChatCompletionRequest
+		if err := json.Unmarshal([]byte(requestBody), &request); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if len(request.Plugins) != 1 || request.Plugins[0].ID != "web" || request.Model != "google/gemini-2.5-flash" {
+			t.Errorf("expected the web plugin on an unchanged model, but got %q", requestBody)
+		}
+
+		expected := "Sources:\n[1] Go 1.24 Release Notes - https://go.dev/doc/go1.24\n"
+		if !strings.HasSuffix(output, expected) {
+			t.Errorf("expected output to end with %q, but got %q", expected, output)
+		}
+
+		viper.Set("web.mode", webModeOnline)
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "--web", "Latest Go?"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+
+		request = llm.ChatCompletionRequest{}
+		if err := json.Unmarshal([]byte(requestBody), &request); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if request.Model != "google/gemini-2.5-flash:online" || len(request.Plugins) != 0 {
+			t.Errorf("expected the :online model without plugins, but got %q", requestBody)
+		}
+
+		viper.Set("web.mode", "bing")
+		if _, err := executeCommand(rootCmd, "--web", "Latest Go?"); ExitCode(err) != ExitUsage {
+			t.Errorf("expected a usage error for an unknown web.mode, but got %v", err)
+		}
+	})
+
+	t.Run("streamed citations", func(t *testing.T) {
+		httpClient = newMockStreamingHTTPClient(http.StatusOK, []string{
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"content": "Cited answer."}}]}`,
+			`{"id": "chat-stream-test", "choices": [{"index": 0, "delta": {"annotations": [{"type": "url_citation", "url_citation": {"url": "https://example.com/a", "title": "A"}}]}}]}`,
+			`{"id": "chat-stream-test", "citations": ["https://example.com/b"], "choices": [{"index": 0, "delta": {}, "finish_reason": "stop"}]}`,
+		})
+
+		output, err := executeCommand(rootCmd, "--stream-mode", "Cite something")
+		if err != nil {
+			t.Fatalf("streaming command failed: %v", err)
+		}
+
+		expected := "Cited answer.\n\nSources:\n[1] A - https://example.com/a\n[2] https://example.com/b\n"
+		if !strings.HasSuffix(output, expected) {
+			t.Errorf("expected output to end with %q, but got %q", expected, output)
+		}
+	})
+
+	t.Run("api errors get a hint", func(t *testing.T) {
+		httpClient = newMockHTTPClient(http.StatusPaymentRequired, `{"error": {"message": "Insufficient credits", "code": 402}}`)
+
+		_, err := executeCommand(rootCmd, "--stream-mode=false", "Hi")
+
+		var apiErr *llm.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusPaymentRequired {
+			t.Fatalf("expected a wrapped 402 APIError, but got %v", err)
+		}
+
+		if !strings.Contains(err.Error(), "insufficient credits") {
+			t.Errorf("expected a credits hint, but got %q", err)
+		}
+	})
+
+	t.Run("finish reasons", func(t *testing.T) {
+		tests := []struct {
+			finishReason string
+			notice       string
+		}{
+			{"stop", ""},
+			{"length", "Response truncated"},
+			{"content_filter", "content filter"},
+		}
+
+		for _, test := range tests {
+			httpClient = newMockHTTPClient(http.StatusOK, `{"choices": [{"index": 0, "message": {"role": "assistant", "content": "Partial"}, "finish_reason": "`+test.finishReason+`"}]}`)
+
+			output, err := executeCommand(rootCmd, "--stream-mode=false", "Hi")
+			if err != nil {
+				t.Fatalf("root command failed: %v", err)
+			}
+
+			hasNotice := strings.Contains(output, "Response truncated") || strings.Contains(output, "content filter")
+			if test.notice == "" && hasNotice {
+				t.Errorf("expected no notice for %q, but got %q", test.finishReason, output)
+			}
+			if test.notice != "" && !strings.Contains(output, test.notice) {
+				t.Errorf("expected notice %q for %q, but got %q", test.notice, test.finishReason, output)
+			}
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		defer func() { dryRunFlag = false }()
+
+		httpClient = &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) *http.Response {
+				t.Error("expected no request to be sent")
+				return nil
+			}),
+		}
+
+		for _, streamMode := range []string{"--stream-mode", "--stream-mode=false"} {
+			output, err := executeCommand(rootCmd, streamMode, "--dry-run", "--system", "Be terse.", "What is Go?")
+			if err != nil {
+				t.Fatalf("dry run failed: %v", err)
+			}
+
+			var body llm.ChatCompletionRequest
+			if err := json.Unmarshal([]byte(output), &body); err != nil {
+				t.Fatalf("expected the request as JSON, but got %q: %v", output, err)
+			}
+
+			if body.Stream != (streamMode == "--stream-mode") {
+				t.Errorf("expected stream to match %s, but got %v", streamMode, body.Stream)
+			}
+
+			last := body.Messages[len(body.Messages)-1]
+			if body.Messages[0].Content != "Be terse." || last.Content != "What is Go?" {
+				t.Errorf("expected the system message and prompt, but got %+v", body.Messages)
+			}
+		}
+		systemFlag = ""
+	})
+
+	t.Run("attribution headers", func(t *testing.T) {
+		viper.Set("openrouter.referer", "https://example.com")
+		defer viper.Set("openrouter.referer", "")
+
+		var headers http.Header
+		httpClient = &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) *http.Response {
+				headers = req.Header
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewBufferString(mockResponse)),
+					Header:     make(http.Header),
+				}
+			}),
+		}
+
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "Hello"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+
+		if got := headers.Get("X-Title"); got != "llm-cli" {
+			t.Errorf("expected X-Title %q, but got %q", "llm-cli", got)
+		}
+
+		if got := headers.Get("HTTP-Referer"); got != "https://example.com" {
+			t.Errorf("expected HTTP-Referer %q, but got %q", "https://example.com", got)
+		}
+
+		viper.Set("base_url", "http://localhost:11434/v1")
+		defer viper.Set("base_url", "")
+		if _, err := executeCommand(rootCmd, "--stream-mode=false", "Hello"); err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		if headers.Get("X-Title") != "" || headers.Get("HTTP-Referer") != "" {
+			t.Errorf("expected no OpenRouter headers for another endpoint, but got %v", headers)
+		}
+	})
+
+	t.Run("blocking prompt", func(t *testing.T) {
+		httpClient = newMockHTTPClient(http.StatusOK, mockResponse)
+
+		output, err := executeCommand(rootCmd, "--stream-mode=false", "Why do we use Golang instead of Rust?")
+		if err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+
+		expected := "Because we are hardcore typing machines"
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected output to contain %q, but got %q", expected, output)
+		}
+	})
+}
+
+func TestModelsCommand(t *testing.T) {
+	viper.Reset()
+	cobra.OnInitialize(initConfig)
+
+	rootCmd.AddCommand(ModelsCmd)
+
+	mockModelsResponse := `{
+        "data": [
+            {
+                "id": "openai/gpt-4o",
+                "name": "OpenAI: GPT-4o",
+                "created": 1715558400,
+                "context_length": 128000,
+                "architecture": {"input_modalities": ["text", "image"]},
+                "pricing": {"prompt": "0.0000025", "completion": "0.00001"}
+            },
+            {
+                "id": "meta-llama/llama-3-8b-instruct:free",
+                "name": "Meta: Llama 3 8B Instruct (free)",
+                "created": 1713398400,
+                "context_length": 8192,
+                "architecture": {"input_modalities": ["text"]},
+                "pricing": {"prompt": "0", "completion": "0"}
+            },
+            {
+                "id": "google/gemini-2.5-pro",
+                "name": "Google: Gemini 2.5 Pro",
+                "created": 1750169544,
+                "context_length": 1048576,
+                "architecture": {"input_modalities": ["text", "image", "file"]},
+                "pricing": {"prompt": "0.00000125", "completion": "0.00001"}
+            }
+        ]
+    }`
+
+	originalHttpClient := httpClient
+	defer func() {
+		httpClient = originalHttpClient
+	}()
+
+	httpClient = newMockHTTPClient(http.StatusOK, mockModelsResponse)
+
+	viper.Set("api_key", "super_secret_key")
+
+	resetModelsFlags := func() {
+		modelsFilterFlag = ""
+		modelsSortFlag = ""
+		modelsFreeFlag = false
+		modelsModalityFlag = ""
+		modelsOutputFlag = "text"
+		modelsRefreshFlag = false
+	}
+
+	t.Run("list models", func(test *testing.T) {
+		resetModelsFlags()
+
+		output, err := executeCommand(rootCmd, "models")
+		if err != nil {
+			test.Fatalf("models command failed: %v", err)
+		}
+
+		for _, expected := range []string{"ID: openai/gpt-4o", "ID: meta-llama/llama-3-8b-instruct:free", "ID: google/gemini-2.5-pro"} {
+			if !strings.Contains(output, expected) {
+				test.Errorf("expected output to contain %q, but got %q", expected, output)
+			}
+		}
+	})
+
+	t.Run("filter by id or name", func(test *testing.T) {
+		resetModelsFlags()
+
+		output, err := executeCommand(rootCmd, "models", "--filter", "GEMINI")
+		if err != nil {
+			test.Fatalf("models command failed: %v", err)
+		}
+
+		if !strings.Contains(output, "ID: google/gemini-2.5-pro") || strings.Contains(output, "ID: openai/gpt-4o") {
+			test.Errorf("expected only the gemini model, but got %q", output)
+		}
+	})
+
+	t.Run("free and modality filters", func(test *testing.T) {
+		resetModelsFlags()
+
+		output, err := executeCommand(rootCmd, "models", "--free")
+		if err != nil {
+			test.Fatalf("models command failed: %v", err)
+		}
+
+		if strings.Count(output, "ID: ") != 1 || !strings.Contains(output, "ID: meta-llama/llama-3-8b-instruct:free") {
+			test.Errorf("expected only the free model, but got %q", output)
+		}
+
+		resetModelsFlags()
+
+		output, err = executeCommand(rootCmd, "models", "--modality", "image")
+		if err != nil {
+			test.Fatalf("models command failed: %v", err)
+		}
+
+		if strings.Count(output, "ID: ") != 2 || strings.Contains(output, "llama") {
+			test.Errorf("expected only the image models, but got %q", output)
+		}
+	})
+
+	t.Run("sort models", func(test *testing.T) {
+		cases := map[string][]string{
+			"price":   {"meta-llama/llama-3-8b-instruct:free", "google/gemini-2.5-pro", "openai/gpt-4o"},
+			"context": {"google/gemini-2.5-pro", "openai/gpt-4o", "meta-llama/llama-3-8b-instruct:free"},
+			"date":    {"google/gemini-2.5-pro", "openai/gpt-4o", "meta-llama/llama-3-8b-instruct:free"},
+			"name":    {"google/gemini-2.5-pro", "meta-llama/llama-3-8b-instruct:free", "openai/gpt-4o"},
+		}
+
+		for sortBy, expectedOrder := range cases {
+			resetModelsFlags()
+
+			output, err := executeCommand(rootCmd, "models", "--sort", sortBy)
+			if err != nil {
+				test.Fatalf("models command failed: %v", err)
+			}
+
+			lastIndex := -1
+			for _, id := range expectedOrder {
+				index := strings.Index(output, "ID: "+id+"\n")
+				if index < lastIndex {
+					test.Errorf("sort by %s: expected %q to come after the previous model, got %q", sortBy, id, output)
+				}
+				lastIndex = index
+			}
+		}
+	})
+
+	t.Run("json output", func(test *testing.T) {
+		resetModelsFlags()
+
+		output, err := executeCommand(rootCmd, "models", "--output", "json", "--free")
+		if err != nil {
+			test.Fatalf("models command failed: %v", err)
+		}
+
+		var models []OpenRouterModel
+		if err := json.Unmarshal([]byte(output), &models); err != nil {
+			test.Fatalf("expected valid JSON output, got %q: %v", output, err)
+		}
+
+		if len(models) != 1 || models[0].ID != "meta-llama/llama-3-8b-instruct:free" {
+			test.Errorf("expected only the free model, got %+v", models)
+		}
+	})
+
+	t.Run("table output", func(test *testing.T) {
+		resetModelsFlags()
+
+		output, err := executeCommand(rootCmd, "models", "--output", "table", "--filter", "gpt-4o")
+		if err != nil {
+			test.Fatalf("models command failed: %v", err)
+		}
+
+		for _, expected := range []string{"ID", "CONTEXT", "openai/gpt-4o", "128000", "2.5", "text,image"} {
+			if !strings.Contains(output, expected) {
+				test.Errorf("expected table to contain %q, but got %q", expected, output)
+			}
+		}
+	})
+
+	t.Run("cached models", func(test *testing.T) {
+		resetModelsFlags()
+		httpClient = newMockHTTPClient(http.StatusOK, mockModelsResponse)
+
+		if _, err := executeCommand(rootCmd, "models", "--refresh"); err != nil {
+			test.Fatalf("models command failed: %v", err)
+		}
+
+		// The cache is fresh, so the broken API shouldn't even be called
+		httpClient = newMockHTTPClient(http.StatusInternalServerError, "boom")
+		defer func() {
+			httpClient = newMockHTTPClient(http.StatusOK, mockModelsResponse)
+		}()
+
+		resetModelsFlags()
+		output, err := executeCommand(rootCmd, "models")
+		if err != nil {
+			test.Fatalf("expected models to be served from cache, got: %v", err)
+		}
+		if !strings.Contains(output, "ID: openai/gpt-4o") {
+			test.Errorf("expected cached models in output, but got %q", output)
+		}
+
+		resetModelsFlags()
+		if _, err := executeCommand(rootCmd, "models", "--refresh"); err == nil {
+			test.Errorf("expected --refresh to hit the API and fail, got nil")
+		}
+	})
+
+	t.Run("show one model", func(test *testing.T) {
+		resetModelsFlags()
+		modelsShowOutputFlag = "text"
+		viper.Set("models.aliases", map[string]string{"omni": "openai/gpt-4o"})
+		defer viper.Set("models.aliases", nil)
+
+		output, err := executeCommand(rootCmd, "models", "show", "omni")
+		if err != nil {
+			test.Fatalf("models show failed: %v", err)
+		}
+
+		for _, expected := range []string{
+			"ID: openai/gpt-4o (alias omni)\n",
+			"Added: 2024-05-13\n",
+			"Context Length: 128000 tokens\n",
+			"Input Modalities: text, image\n",
+			"Output Modalities: -\n",
+			"  Input: $2.5 / 1M tokens\n",
+			"  Output: $10 / 1M tokens\n",
+		} {
+			if !strings.Contains(output, expected) {
+				test.Errorf("expected output to contain %q, but got %q", expected, output)
+			}
+		}
+
+		output, err = executeCommand(rootCmd, "models", "show", "google/gemini-2.5-pro", "-o", "json")
+		if err != nil {
+			test.Fatalf("models show failed: %v", err)
+		}
+		var model OpenRouterModel
+		if err := json.Unmarshal([]byte(output), &model); err != nil || model.ContextLength != 1048576 {
+			test.Errorf("expected the model as JSON, but got %q: %v", output, err)
+		}
+
+		_, err = executeCommand(rootCmd, "models", "show", "openai/gpt-4p", "-o", "text")
+		if err == nil || !strings.Contains(err.Error(), `did you mean "openai/gpt-4o"?`) {
+			test.Errorf("expected a suggestion for an unknown model, but got %v", err)
+		}
+	})
+
+	t.Run("compare models", func(test *testing.T) {
+		resetModelsFlags()
+		viper.Set("models.aliases", map[string]string{"omni": "openai/gpt-4o"})
+		defer viper.Set("models.aliases", nil)
+
+		output, err := executeCommand(rootCmd, "models", "compare", "omni", "google/gemini-2.5-pro")
+		if err != nil {
+			test.Fatalf("models compare failed: %v", err)
+		}
+
+		lines := strings.Split(output, "\n")
+		if len(lines) < 3 || !strings.HasPrefix(lines[1], "openai/gpt-4o ") || !strings.HasPrefix(lines[2], "google/gemini-2.5-pro ") {
+			test.Fatalf("expected a row per model in the given order, but got %q", output)
+		}
+		if !strings.Contains(lines[2], "1048576 *") || !strings.Contains(lines[2], "1.25 *") || strings.Contains(lines[1], "2.5 *") {
+			test.Errorf("expected gemini's context and input price to be marked, but got %q", output)
+		}
+		// Both cost the same per output token
+		if strings.Count(output, "10 *") != 2 {
+			test.Errorf("expected the tied output prices to both be marked, but got %q", output)
+		}
+
+		if _, err := executeCommand(rootCmd, "models", "compare", "omni"); err == nil {
+			test.Errorf("expected an error for a single model, got nil")
+		}
+	})
+
+	t.Run("invalid sort", func(test *testing.T) {
+		resetModelsFlags()
+
+		_, err := executeCommand(rootCmd, "models", "--sort", "vibes")
+		if err == nil {
+			test.Fatalf("expected an error for an invalid sort, got nil")
+		}
+	})
+}
+
+func TestCompleteModelNames(t *testing.T) {
+	viper.Reset()
+	viper.Set("models.aliases", map[string]string{"fast": "openai/gpt-4.1-nano", "smart": "google/gemini-2.5-pro"})
+
+	if err := writeModelsCache([]OpenRouterModel{
+		{ID: "openai/gpt-4o", Name: "OpenAI: GPT-4o"},
+		{ID: "google/gemini-2.5-pro", Name: "Google: Gemini 2.5 Pro"},
+	}); err != nil {
+		t.Fatalf("failed to write models cache: %v", err)
+	}
+
+	suggestions, directive := completeModelNames(rootCmd, nil, "")
+	if directive != cobra.ShellCompDirectiveNoFileComp {
+		t.Errorf("expected file completion to be disabled, got %v", directive)
+	}
+
+	expected := []string{
+		"fast\talias for openai/gpt-4.1-nano",
+		"smart\talias for google/gemini-2.5-pro",
+		"openai/gpt-4o\tOpenAI: GPT-4o",
+		"google/gemini-2.5-pro\tGoogle: Gemini 2.5 Pro",
+	}
+	if strings.Join(suggestions, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected suggestions %q, got %q", expected, suggestions)
+	}
+
+	suggestions, _ = completeModelNames(rootCmd, nil, "open")
+	if len(suggestions) != 1 || !strings.HasPrefix(suggestions[0], "openai/gpt-4o") {
+		t.Errorf("expected only openai/gpt-4o for prefix \"open\", got %q", suggestions)
+	}
+}
+
+func TestTemplatesCommand(t *testing.T) {
+	viper.Reset()
+
+	t.Run("list templates", func(t *testing.T) {
+		output, err := executeCommand(rootCmd, "templates", "list")
+		if err != nil {
+			t.Fatalf("templates list failed: %v", err)
+		}
+
+		// The default templates are installed on first run
+		for _, expected := range []string{"NAME", "brainstorm", "summarize"} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("expected output to contain %q, but got %q", expected, output)
+			}
+		}
+	})
+
+	t.Run("show template", func(t *testing.T) {
+		output, err := executeCommand(rootCmd, "templates", "show", "summarize")
+		if err != nil {
+			t.Fatalf("templates show failed: %v", err)
+		}
+
+		if !strings.Contains(output, "{{.UserPrompt}}") {
+			t.Errorf("expected the user prompt template in output, but got %q", output)
+		}
+	})
+
+	t.Run("new template", func(t *testing.T) {
+		t.Setenv("VISUAL", "")
+		t.Setenv("EDITOR", "true")
+
+		if _, err := executeCommand(rootCmd, "templates", "new", "translate"); err != nil {
+			t.Fatalf("templates new failed: %v", err)
+		}
+
+		tmpl, err := loadTemplate("translate")
+		if err != nil {
+			t.Fatalf("expected the scaffold to parse, got: %v", err)
+		}
+		if tmpl.Name != "translate" || tmpl.UserPromptTemplate == "" {
+			t.Errorf("unexpected scaffold: %+v", tmpl)
+		}
+
+		if _, err := executeCommand(rootCmd, "templates", "new", "translate"); err == nil {
+			t.Errorf("expected an error when the template already exists, got nil")
+		}
+	})
+
+	t.Run("show missing template", func(t *testing.T) {
+		_, err := executeCommand(rootCmd, "templates", "show", "does-not-exist")
+		if err == nil {
+			t.Fatalf("expected an error for a missing template, got nil")
+		}
+	})
+
+	t.Run("inline template", func(t *testing.T) {
+		viper.Set("templates.inline", map[string]any{
+			"terse":     map[string]any{"system": "Answer in one sentence.", "user": "Explain {{.UserPrompt}}"},
+			"summarize": map[string]any{"system": "Inline wins."},
+		})
+		viper.Set("models.validate", false)
+		defer func() {
+			viper.Set("templates.inline", nil)
+			viper.Set("models.validate", true)
+			templateFlag = ""
+			dryRunFlag = false
+		}()
+
+		output, err := executeCommand(rootCmd, "templates", "list")
+		if err != nil {
+			t.Fatalf("templates list failed: %v", err)
+		}
+		if !strings.Contains(output, "terse") {
+			t.Errorf("expected the inline template to be listed, but got %q", output)
+		}
+
+		output, err = executeCommand(rootCmd, "--dry-run", "--template", "Terse", "goroutines")
+		if err != nil {
+			t.Fatalf("dry run failed: %v", err)
+		}
+		for _, expected := range []string{"Answer in one sentence.", "Explain goroutines"} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("expected request to contain %q, but got %q", expected, output)
+			}
+		}
+
+		tmpl, err := loadTemplate("summarize")
+		if err != nil {
+			t.Fatalf("failed to load template: %v", err)
+		}
+		if tmpl.SystemMessage != "Inline wins." {
+			t.Errorf("expected the inline template to win over the file, but got %q", tmpl.SystemMessage)
+		}
+	})
+}
+
+func TestConfigProfiles(t *testing.T) {
+	viper.Reset()
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configPath, []byte(`model: fast
+api_key: personal-key
+profiles:
+  work:
+    api_key: work-key
+    model: smart
+  oss:
+    model: 10x
+`), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	defer func() {
+		cfgFile = ""
+		viper.Reset()
+	}()
+
+	// viper.Reset drops the flag bindings, so select the profile through the env instead
+	t.Setenv("LLM_PROFILE", "work")
+
+	output, err := executeCommand(rootCmd, "--config", configPath, "config", "profiles")
+	if err != nil {
+		t.Fatalf("config profiles failed: %v", err)
+	}
+
+	if !strings.Contains(output, "* work") || !strings.Contains(output, "  oss") {
+		t.Errorf("expected both profiles with work marked active, but got %q", output)
+	}
+
+	if viper.GetString("api_key") != "work-key" || viper.GetString("model") != "smart" {
+		t.Errorf("expected the work profile to override the top-level values, got api_key=%q model=%q", viper.GetString("api_key"), viper.GetString("model"))
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	viper.Reset()
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte("api_key: sk-or-secret-1234\n"), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	defer func() {
+		cfgFile = ""
+		viper.Reset()
+	}()
+
+	t.Run("get masks the api key", func(t *testing.T) {
+		output, err := executeCommand(rootCmd, "--config", configPath, "config", "get", "api_key")
+		if err != nil {
+			t.Fatalf("config get failed: %v", err)
+		}
+
+		if strings.Contains(output, "secret") || !strings.Contains(output, "****1234") {
+			t.Errorf("expected a masked api key, but got %q", output)
+		}
+	})
+
+	t.Run("set persists typed values", func(t *testing.T) {
+		if _, err := executeCommand(rootCmd, "--config", configPath, "config", "set", "always_copy", "true"); err != nil {
+			t.Fatalf("config set failed: %v", err)
+		}
+
+		written, err := os.ReadFile(configPath)
+		if err != nil {
+			t.Fatalf("failed to read config: %v", err)
+		}
+
+		if !strings.Contains(string(written), "always_copy: true") {
+			t.Errorf("expected always_copy to be saved as a boolean, got %q", written)
+		}
+
+		// Defaults and flags must not be dumped into the file
+		if strings.Contains(string(written), "timeout") {
+			t.Errorf("expected only file values to be written, got %q", written)
+		}
+	})
+
+	t.Run("path", func(t *testing.T) {
+		output, err := executeCommand(rootCmd, "--config", configPath, "config", "path")
+		if err != nil {
+			t.Fatalf("config path failed: %v", err)
+		}
+
+		if strings.TrimSpace(output) != configPath {
+			t.Errorf("expected %q, but got %q", configPath, output)
+		}
+	})
+
+	t.Run("toml config", func(t *testing.T) {
+		tomlPath := filepath.Join(t.TempDir(), "config.toml")
+		if err := os.WriteFile(tomlPath, []byte("model = \"openai/gpt-4.1-nano\"\n"), 0644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+
+		output, err := executeCommand(rootCmd, "--config", tomlPath, "config", "get", "model")
+		if err != nil {
+			t.Fatalf("config get failed: %v", err)
+		}
+		if !strings.Contains(output, "openai/gpt-4.1-nano") {
+			t.Errorf("expected the model from the TOML file, but got %q", output)
+		}
+
+		if _, err := executeCommand(rootCmd, "--config", tomlPath, "config", "set", "always_copy", "true"); err != nil {
+			t.Fatalf("config set failed: %v", err)
+		}
+
+		written, err := os.ReadFile(tomlPath)
+		if err != nil {
+			t.Fatalf("failed to read config: %v", err)
+		}
+		if !strings.Contains(string(written), "always_copy = true") {
+			t.Errorf("expected the config to stay TOML, got %q", written)
+		}
+	})
+}
+
+func TestDefaultConfigPath(t *testing.T) {
+	configDir := t.TempDir()
+
+	expected := filepath.Join(configDir, "config.yaml")
+	if configPath := defaultConfigPath(configDir); configPath != expected {
+		t.Errorf("expected %q, but got %q", expected, configPath)
+	}
+
+	expected = filepath.Join(configDir, "config.toml")
+	if err := os.WriteFile(expected, nil, 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if configPath := defaultConfigPath(configDir); configPath != expected {
+		t.Errorf("expected %q, but got %q", expected, configPath)
+	}
+
+	if configType("/home/user/.llmrc") != "yaml" || configType("config.JSON") != "json" {
+		t.Errorf("expected extensionless files to be YAML and extensions to be case insensitive")
+	}
+}
+
+func TestAliasCommand(t *testing.T) {
+	viper.Reset()
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte("model: fast\n"), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	defer func() {
+		cfgFile = ""
+		viper.Reset()
+	}()
+
+	t.Run("add keeps the built-in aliases", func(t *testing.T) {
+		if _, err := executeCommand(rootCmd, "--config", configPath, "alias", "add", "quick", "google/gemini-flash-1.5"); err != nil {
+			t.Fatalf("alias add failed: %v", err)
+		}
+
+		written, err := os.ReadFile(configPath)
+		if err != nil {
+			t.Fatalf("failed to read config: %v", err)
+		}
+
+		for _, expected := range []string{"quick: google/gemini-flash-1.5", "fast: openai/gpt-4.1-nano"} {
+			if !strings.Contains(string(written), expected) {
+				t.Errorf("expected config to contain %q, got %q", expected, written)
+			}
+		}
+	})
+
+	t.Run("rm removes the alias", func(t *testing.T) {
+		if _, err := executeCommand(rootCmd, "--config", configPath, "alias", "rm", "gpt4"); err != nil {
+			t.Fatalf("alias rm failed: %v", err)
+		}
+
+		written, err := os.ReadFile(configPath)
+		if err != nil {
+			t.Fatalf("failed to read config: %v", err)
+		}
+
+		if strings.Contains(string(written), "gpt4") {
+			t.Errorf("expected gpt4 to be removed, got %q", written)
+		}
+
+		if _, err := executeCommand(rootCmd, "--config", configPath, "alias", "rm", "gpt4"); err == nil {
+			t.Error("expected an error when removing a missing alias")
+		}
+	})
+
+	t.Run("--list-aliases", func(t *testing.T) {
+		defer func() { listAliasesFlag = false }()
+
+		output, err := executeCommand(rootCmd, "--config", configPath, "--list-aliases")
+		if err != nil {
+			t.Fatalf("--list-aliases failed: %v", err)
+		}
+
+		if !strings.Contains(output, "quick") || !strings.Contains(output, "google/gemini-flash-1.5") {
+			t.Errorf("expected the aliases to be listed, but got %q", output)
+		}
+	})
+
+	t.Run("list flags unknown models", func(t *testing.T) {
+		if err := writeModelsCache([]OpenRouterModel{{ID: "openai/gpt-4.1-nano"}}); err != nil {
+			t.Fatalf("failed to write models cache: %v", err)
+		}
+		defer os.Remove(mustModelsCachePath(t))
+
+		output, err := executeCommand(rootCmd, "--config", configPath, "alias", "list")
+		if err != nil {
+			t.Fatalf("alias list failed: %v", err)
+		}
+
+		for _, line := range strings.Split(output, "\n") {
+			if strings.HasPrefix(line, "fast ") && strings.Contains(line, "not in models list") {
+				t.Errorf("expected fast to be a known model, got %q", line)
+			}
+			if strings.HasPrefix(line, "quick ") && !strings.Contains(line, "not in models list") {
+				t.Errorf("expected quick to be flagged, got %q", line)
+			}
+		}
+	})
+}
+
+func mustModelsCachePath(t *testing.T) string {
+	t.Helper()
+
+	path, err := getModelsCachePath()
+	if err != nil {
+		t.Fatalf("failed to get models cache path: %v", err)
+	}
+
+	return path
+}
+
+func TestValidateModel(t *testing.T) {
+	viper.Reset()
+	viper.Set("models.validate", true)
+	viper.Set("models.aliases", map[string]string{"smart": "google/gemini-2.5-pro"})
+	defer viper.Reset()
+
+	if err := writeModelsCache([]OpenRouterModel{{ID: "openai/gpt-4o"}, {ID: "google/gemini-2.5-pro"}}); err != nil {
+		t.Fatalf("failed to write models cache: %v", err)
+	}
+	defer os.Remove(mustModelsCachePath(t))
+
+	t.Run("known model", func(t *testing.T) {
+		if err := validateModel("openai/gpt-4o"); err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+	})
+
+	t.Run("typo gets a suggestion", func(t *testing.T) {
+		err := validateModel("openai/gpt-4p")
+		if err == nil || !strings.Contains(err.Error(), `did you mean "openai/gpt-4o"`) {
+			t.Errorf("expected a suggestion for openai/gpt-4o, but got %v", err)
+		}
+	})
+
+	t.Run("misspelled alias gets a suggestion", func(t *testing.T) {
+		err := validateModel("smrt")
+		if err == nil || !strings.Contains(err.Error(), `did you mean "smart"`) {
+			t.Errorf("expected a suggestion for smart, but got %v", err)
+		}
+	})
+
+	t.Run("other base url has its own cache", func(t *testing.T) {
+		viper.Set("base_url", "http://localhost:11434/v1")
+		defer viper.Set("base_url", "")
+
+		if err := validateModel("llama3"); err != nil {
+			t.Errorf("expected OpenRouter's models not to judge a local server's, but got %v", err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		viper.Set("models.validate", false)
+		defer viper.Set("models.validate", true)
+
+		if err := validateModel("nonsense"); err != nil {
+			t.Errorf("expected validation to be skipped, but got %v", err)
+		}
+	})
+}
+
+func TestRenderStyle(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	customStylePath := filepath.Join(t.TempDir(), "style.json")
+	if err := os.WriteFile(customStylePath, []byte(`{}`), 0644); err != nil {
+		t.Fatalf("failed to write style: %v", err)
+	}
+
+	tests := []struct {
+		style    string
+		expected string
+	}{
+		// Tests don't run on a terminal
+		{"", "notty"},
+		{"dracula", "dracula"},
+		{"auto", "auto"},
+		{customStylePath, customStylePath},
+		{"no-such-style", "auto"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.style, func(t *testing.T) {
+			viper.Set("render.style", test.style)
+
+			if got := renderStyle(); got != test.expected {
+				t.Errorf("expected %q, but got %q", test.expected, got)
+			}
+		})
+	}
+
+	t.Run("no_color overrides the style", func(t *testing.T) {
+		viper.Set("render.style", "dracula")
+		viper.Set("no_color", true)
+		defer viper.Set("no_color", false)
+
+		if got := renderStyle(); got != "notty" {
+			t.Errorf("expected %q, but got %q", "notty", got)
+		}
+	})
+
+	t.Run("NO_COLOR overrides the style", func(t *testing.T) {
+		viper.Set("render.style", "dracula")
+		t.Setenv("NO_COLOR", "1")
+
+		if got := renderStyle(); got != "notty" {
+			t.Errorf("expected %q, but got %q", "notty", got)
+		}
+	})
+
+	t.Run("empty NO_COLOR is ignored", func(t *testing.T) {
+		viper.Set("render.style", "dracula")
+		t.Setenv("NO_COLOR", "")
+
+		if got := renderStyle(); got != "dracula" {
+			t.Errorf("expected %q, but got %q", "dracula", got)
+		}
+	})
+}
+
+func TestReasoningDimming(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	write := func(terminal bool) string {
+		var out bytes.Buffer
+		fmt.Fprint(dimmedUnlessPlain(&out, terminal), "Thinking it over.")
+		return out.String()
+	}
+
+	if got := write(true); got != ansiDim+"Thinking it over."+ansiReset {
+		t.Errorf("expected dimmed reasoning on a terminal, but got %q", got)
+	}
+	if got := write(false); got != "Thinking it over." {
+		t.Errorf("expected plain reasoning in a pipe, but got %q", got)
+	}
+
+	t.Run("NO_COLOR keeps it plain", func(t *testing.T) {
+		t.Setenv("NO_COLOR", "1")
+
+		if got := write(true); got != "Thinking it over." {
+			t.Errorf("expected plain reasoning, but got %q", got)
+		}
+	})
+
+	t.Run("no_color keeps it plain", func(t *testing.T) {
+		viper.Set("no_color", true)
+		defer viper.Set("no_color", false)
+
+		if got := write(true); got != "Thinking it over." {
+			t.Errorf("expected plain reasoning, but got %q", got)
+		}
+	})
+}
+
+func TestRenderResponse(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("render.style", "notty")
+
+	t.Run("json is indented", func(t *testing.T) {
+		viper.Set("render.autodetect_json", true)
+
+		output, err := renderResponse(`{"name":"Ada","langs":["en"]}`, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !strings.Contains(output, `"name": "Ada"`) {
+			t.Errorf("expected indented json, but got %q", output)
+		}
+	})
+
+	t.Run("autodetect disabled", func(t *testing.T) {
+		viper.Set("render.autodetect_json", false)
+
+		output, err := renderResponse(`{"name":"Ada"}`, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if strings.Contains(output, `"name": "Ada"`) {
+			t.Errorf("expected json to be left alone, but got %q", output)
+		}
+	})
+
+	t.Run("structured output that isn't json", func(t *testing.T) {
+		output, err := renderResponse("Sorry, I can't do that", true)
+		if err != nil || output != "Sorry, I can't do that" {
+			t.Errorf("expected %q, but got %q, %v", "Sorry, I can't do that", output, err)
+		}
+	})
+
+	t.Run("bare values aren't json documents", func(t *testing.T) {
+		if _, ok := indentJSON("42"); ok {
+			t.Errorf("expected a bare number not to count as json")
+		}
+	})
+}
+
+func TestMarkdownStreamWriter(t *testing.T) {
+	t.Run("block boundaries", func(t *testing.T) {
+		tests := []struct {
+			text     string
+			expected int
+		}{
+			{"# Title", 0},
+			{"# Title\n\nSome", len("# Title\n\n")},
+			{"```go\nfunc main() {\n\n", 0},
+			{"```go\n\n```\nafter", len("```go\n\n```\n")},
+		}
+
+		for _, test := range tests {
+			if got := completedBlockEnd(test.text); got != test.expected {
+				t.Errorf("expected block end %d for %q, but got %d", test.expected, test.text, got)
+			}
+		}
+	})
+
+	t.Run("completed blocks are re-rendered", func(t *testing.T) {
+		viper.Reset()
+		// notty keeps the markdown markers, so use a style that actually styles
+		viper.Set("render.style", "dark")
+		defer viper.Reset()
+
+		var out bytes.Buffer
+		w := &markdownStreamWriter{out: &out, width: 80}
+
+		for _, chunk := range []string{"# Ti", "tle\n", "\nSome **bold** text"} {
+			if _, err := w.Write([]byte(chunk)); err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+		}
+
+		if !strings.Contains(out.String(), "\x1b[J") {
+			t.Errorf("expected the raw title to be erased, got %q", out.String())
+		}
+
+		if err := w.Flush(); err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+
+		if strings.Contains(out.String()[strings.LastIndex(out.String(), "\x1b[J"):], "**bold**") {
+			t.Errorf("expected the last block to be rendered, got %q", out.String())
+		}
+	})
+
+	t.Run("reasoning in between renders the block first", func(t *testing.T) {
+		viper.Reset()
+		viper.Set("render.style", "dark")
+		defer viper.Reset()
+
+		var out bytes.Buffer
+		w := &markdownStreamWriter{out: &out, width: 80}
+		reasoning := w.interrupt(&out)
+
+		w.Write([]byte("Some **bold** text"))
+		reasoning.Write([]byte("Reasoning:\nStill thinking\n\n"))
+		w.Write([]byte("More text\n\n"))
+
+		output := out.String()
+		reasoningAt := strings.Index(output, "Still thinking")
+		if renderAt := strings.Index(output, "\x1b[J"); renderAt == -1 || renderAt > reasoningAt {
+			t.Fatalf("expected the block to be rendered before the reasoning, got %q", output)
+		}
+
+		// Only the rows after the reasoning may be erased, the reasoning stays on screen
+		if after := output[reasoningAt:]; strings.Contains(after, "\x1b[3A") || strings.Contains(after, "\x1b[4A") {
+			t.Errorf("expected the cursor not to move up over the reasoning, got %q", after)
+		}
+	})
+}
+
+func TestGetAttachments(t *testing.T) {
+	dir := t.TempDir()
+
+	goFile := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(goFile, []byte("package main\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	binaryFile := filepath.Join(dir, "image.png")
+	if err := os.WriteFile(binaryFile, []byte{0x89, 'P', 'N', 'G', 0x00, 0x01}, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	t.Run("fenced with a header", func(t *testing.T) {
+		attachments, err := getAttachments([]string{goFile}, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expected := "File: " + goFile + "\n```go\npackage main\n```"
+		if len(attachments) != 1 || attachments[0] != expected {
+			t.Errorf("expected [%q], but got %q", expected, attachments)
+		}
+	})
+
+	t.Run("binary files are refused", func(t *testing.T) {
+		if _, err := getAttachments([]string{binaryFile}, 0); err == nil {
+			t.Error("expected an error for a binary file")
+		}
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		_, err := getAttachments([]string{goFile, goFile}, 20)
+		if err == nil || !strings.Contains(err.Error(), "attach.max_bytes") {
+			t.Errorf("expected a size limit error, but got %v", err)
+		}
+	})
+}
+
+func TestTokensCommand(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	if err := writeModelsCache([]OpenRouterModel{{
+		ID:            "openai/gpt-4o",
+		ContextLength: 128000,
+		Architecture:  ModelArchitecture{Tokenizer: "GPT"},
+	}}); err != nil {
+		t.Fatalf("failed to write models cache: %v", err)
+	}
+	defer os.Remove(mustModelsCachePath(t))
+
+	output, err := executeCommand(rootCmd, "tokens", "-m", "openai/gpt-4o", "abcdefghijklmnop")
+	if err != nil {
+		t.Fatalf("tokens command failed: %v", err)
+	}
+
+	for _, expected := range []string{"Estimated tokens: 4", "128000 tokens, ~127996 left"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected output to contain %q, but got %q", expected, output)
+		}
+	}
+}
+
+func TestFitContext(t *testing.T) {
+	if err := writeModelsCache([]OpenRouterModel{{
+		ID:            "tiny/model",
+		ContextLength: 40,
+		Architecture:  ModelArchitecture{Tokenizer: "GPT"},
+	}}); err != nil {
+		t.Fatalf("failed to write models cache: %v", err)
+	}
+	defer os.Remove(mustModelsCachePath(t))
+
+	messages := []llm.ChatCompletionMessage{
+		{Role: "user", Content: strings.Repeat("old question ", 8)},
+		{Role: "assistant", Content: strings.Repeat("old answer ", 8)},
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "New question?"},
+	}
+
+	t.Run("fits", func(t *testing.T) {
+		fitted, err := fitContext(messages[2:], "tiny/model", nil, contextOverflowError)
+		if err != nil || len(fitted) != 2 {
+			t.Errorf("expected messages to be kept as is, but got %v, %v", fitted, err)
+		}
+	})
+
+	t.Run("warn", func(t *testing.T) {
+		fitted, err := fitContext(messages, "tiny/model", nil, contextOverflowWarn)
+		if err != nil || len(fitted) != len(messages) {
+			t.Errorf("expected messages to be kept as is, but got %v, %v", fitted, err)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		if _, err := fitContext(messages, "tiny/model", nil, contextOverflowError); err == nil {
+			t.Error("expected an error for an oversized prompt")
+		}
+	})
+
+	t.Run("truncate drops the oldest messages", func(t *testing.T) {
+		fitted, err := fitContext(messages, "tiny/model", nil, contextOverflowTruncate)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(fitted) != 2 || fitted[0].Role != "system" || fitted[1].Content != "New question?" {
+			t.Errorf("expected only the system message and prompt to remain, but got %v", fitted)
+		}
+	})
+
+	t.Run("max tokens are kept free", func(t *testing.T) {
+		maxTokens := 35
+		if _, err := fitContext(messages[2:], "tiny/model", &maxTokens, contextOverflowError); err == nil {
+			t.Error("expected an error when there's no room left for the answer")
+		}
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		if _, err := fitContext(messages, "tiny/model", nil, "shrug"); err == nil {
+			t.Error("expected an error for an invalid mode")
+		}
+	})
+}
+
+type erroringStdin struct{}
+
+func (erroringStdin) Read(p []byte) (int, error) {
+	return 0, errors.New("expected stdin not to be read")
+}
+
+func (erroringStdin) Stat() (os.FileInfo, error) {
+	return nil, errors.New("bad file descriptor")
+}
+
+func TestGetPromptContentStdin(t *testing.T) {
+	originalStdin := promptStdin
+	defer func() { promptStdin = originalStdin }()
+
+	t.Run("errored stat is treated as a terminal", func(t *testing.T) {
+		promptStdin = erroringStdin{}
+
+		prompt, err := getPromptContent([]string{"hello"}, "", false)
+		if err != nil || prompt.Merged != "hello" {
+			t.Errorf("expected %q, but got %q, %v", "hello", prompt.Merged, err)
+		}
+	})
+
+	t.Run("empty pipe", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to create pipe: %v", err)
+		}
+		defer r.Close()
+		w.Close()
+		promptStdin = r
+
+		prompt, err := getPromptContent([]string{"hello"}, "", false)
+		if err != nil || prompt.Merged != "hello" {
+			t.Errorf("expected %q, but got %q, %v", "hello", prompt.Merged, err)
+		}
+	})
+
+	t.Run("silent pipe doesn't hang", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to create pipe: %v", err)
+		}
+		defer r.Close()
+		defer w.Close()
+		promptStdin = r
+
+		originalTimeout, originalLogger, originalLevel := stdinWaitTimeout, log.Logger, zerolog.GlobalLevel()
+		defer func() {
+			stdinWaitTimeout, log.Logger = originalTimeout, originalLogger
+			zerolog.SetGlobalLevel(originalLevel)
+		}()
+		stdinWaitTimeout = 20 * time.Millisecond
+		var logs bytes.Buffer
+		log.Logger = zerolog.New(&logs)
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+		prompt, err := getPromptContent([]string{"hello"}, "", false)
+		if err != nil || prompt.Merged != "hello" {
+			t.Errorf("expected %q, but got %q, %v", "hello", prompt.Merged, err)
+		}
+		if !strings.Contains(logs.String(), "ignoring it") {
+			t.Errorf("expected a warning about the ignored stdin, but got %q", logs.String())
+		}
+	})
+
+	t.Run("slow pipe is still read", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to create pipe: %v", err)
+		}
+		defer r.Close()
+		promptStdin = r
+
+		go func() {
+			time.Sleep(300 * time.Millisecond)
+			w.WriteString("late output\n")
+			w.Close()
+		}()
+
+		prompt, err := getPromptContent([]string{"explain"}, "", false)
+		if err != nil || prompt.Stdin != "late output" {
+			t.Errorf("expected stdin %q, but got %q, %v", "late output", prompt.Stdin, err)
+		}
+	})
+
+	t.Run("redirected file is read without waiting", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "notes.txt")
+		if err := os.WriteFile(path, []byte("from a file\n"), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("failed to open file: %v", err)
+		}
+		defer file.Close()
+		promptStdin = file
+
+		originalTimeout := stdinWaitTimeout
+		defer func() { stdinWaitTimeout = originalTimeout }()
+		stdinWaitTimeout = 0
+
+		prompt, err := getPromptContent([]string{"explain"}, "", false)
+		if err != nil || prompt.Stdin != "from a file" {
+			t.Errorf("expected stdin %q, but got %q, %v", "from a file", prompt.Stdin, err)
+		}
+	})
+
+	t.Run("dash forces stdin", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to create pipe: %v", err)
+		}
+		defer r.Close()
+		w.WriteString("from stdin\n")
+		w.Close()
+		promptStdin = r
+
+		prompt, err := getPromptContent([]string{"-"}, "", false)
+		if err != nil || prompt.Merged != "from stdin" {
+			t.Errorf("expected %q, but got %q, %v", "from stdin", prompt.Merged, err)
+		}
+	})
+
+	t.Run("sources are kept apart", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to create pipe: %v", err)
+		}
+		defer r.Close()
+		w.WriteString("from stdin\n")
+		w.Close()
+		promptStdin = r
+
+		prompt, err := getPromptContent([]string{"explain", "this"}, "", false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if prompt.Args != "explain this" || prompt.Stdin != "from stdin" {
+			t.Errorf("expected args %q and stdin %q, but got %q and %q", "explain this", "from stdin", prompt.Args, prompt.Stdin)
+		}
+
+		expected := "explain this\n\nfrom stdin"
+		if prompt.Merged != expected {
+			t.Errorf("expected %q, but got %q", expected, prompt.Merged)
+		}
+	})
+}
+
+func TestGetPromptContentClipboard(t *testing.T) {
+	originalStdin, originalClipboard := promptStdin, promptClipboard
+	defer func() {
+		promptStdin, promptClipboard = originalStdin, originalClipboard
+		fromClipboardFlag = false
+	}()
+	promptStdin = erroringStdin{}
+	fromClipboardFlag = true
+
+	t.Run("clipboard as the prompt", func(t *testing.T) {
+		promptClipboard = func() (string, error) { return "What does this regex match? ^a+$\n", nil }
+
+		prompt, err := getPromptContent(nil, "", false)
+		if err != nil || prompt.Merged != "What does this regex match? ^a+$" {
+			t.Errorf("expected the clipboard as the prompt, but got %q, %v", prompt.Merged, err)
+		}
+	})
+
+	t.Run("clipboard after the arguments", func(t *testing.T) {
+		promptClipboard = func() (string, error) { return "panic: runtime error", nil }
+
+		prompt, err := getPromptContent([]string{"explain"}, "", false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expected := "explain\n\npanic: runtime error"
+		if prompt.Merged != expected {
+			t.Errorf("expected %q, but got %q", expected, prompt.Merged)
+		}
+	})
+
+	t.Run("empty clipboard", func(t *testing.T) {
+		promptClipboard = func() (string, error) { return "", utils.ErrClipboardEmpty }
+
+		if _, err := getPromptContent([]string{"explain"}, "", false); !errors.Is(err, utils.ErrClipboardEmpty) {
+			t.Errorf("expected ErrClipboardEmpty, but got %v", err)
+		}
+	})
+}
+
+func TestGetSystemPromptContent(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("inline", func(t *testing.T) {
+		content, err := getSystemPromptContent("  Be brief.  ")
+		if err != nil || content != "Be brief." {
+			t.Errorf("expected %q, but got %q, %v", "Be brief.", content, err)
+		}
+	})
+
+	t.Run("from a file", func(t *testing.T) {
+		content, err := getSystemPromptContent("@" + writeFile("system.md", "\nYou review Go code.\n"))
+		if err != nil || content != "You review Go code." {
+			t.Errorf("expected %q, but got %q, %v", "You review Go code.", content, err)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := getSystemPromptContent("@" + filepath.Join(dir, "missing.md")); err == nil || !strings.Contains(err.Error(), "error reading system prompt file") {
+			t.Errorf("expected a read error, but got %v", err)
+		}
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		if _, err := getSystemPromptContent("@" + writeFile("empty.md", "  \n")); err == nil || !strings.Contains(err.Error(), "is empty") {
+			t.Errorf("expected an empty file error, but got %v", err)
+		}
+	})
+}
+
+func TestResolveAPIKey(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	t.Run("plain key", func(t *testing.T) {
+		viper.Set("api_key", "sk-or-plain")
+
+		apiKey, err := resolveAPIKey()
+		if err != nil || apiKey != "sk-or-plain" {
+			t.Errorf("expected %q, but got %q, %v", "sk-or-plain", apiKey, err)
+		}
+	})
+
+	t.Run("from a file", func(t *testing.T) {
+		keyPath := filepath.Join(t.TempDir(), "openrouter")
+		if err := os.WriteFile(keyPath, []byte("sk-or-from-file\n"), 0600); err != nil {
+			t.Fatalf("failed to write key file: %v", err)
+		}
+		viper.Set("api_key", "file:"+keyPath)
+
+		apiKey, err := resolveAPIKey()
+		if err != nil || apiKey != "sk-or-from-file" {
+			t.Errorf("expected %q, but got %q, %v", "sk-or-from-file", apiKey, err)
+		}
+	})
+
+	t.Run("from a command", func(t *testing.T) {
+		viper.Set("api_key", "cmd:echo '  sk-or-from-cmd  '")
+
+		apiKey, err := resolveAPIKey()
+		if err != nil || apiKey != "sk-or-from-cmd" {
+			t.Errorf("expected %q, but got %q, %v", "sk-or-from-cmd", apiKey, err)
+		}
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		keyPath := filepath.Join(t.TempDir(), "openrouter")
+		if err := os.WriteFile(keyPath, []byte("\n"), 0600); err != nil {
+			t.Fatalf("failed to write key file: %v", err)
+		}
+		viper.Set("api_key", "file:"+keyPath)
+
+		if _, err := resolveAPIKey(); err == nil || !strings.Contains(err.Error(), "is empty") {
+			t.Errorf("expected an empty key error, but got %v", err)
+		}
+	})
+
+	t.Run("failing command", func(t *testing.T) {
+		viper.Set("api_key", "cmd:exit 1")
+
+		if _, err := resolveAPIKey(); err == nil {
+			t.Errorf("expected an error from the failing command, got nil")
+		}
+	})
+}
+
+func TestAuthCommand(t *testing.T) {
+	viper.Reset()
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte("model: openai/gpt-4o\n"), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	originalHttpClient := httpClient
+	originalStdin := promptStdin
+	defer func() {
+		cfgFile = ""
+		httpClient = originalHttpClient
+		promptStdin = originalStdin
+		viper.Reset()
+	}()
+
+	pipeKey := func(t *testing.T, key string) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to create pipe: %v", err)
+		}
+		t.Cleanup(func() { r.Close() })
+		w.WriteString(key + "\n")
+		w.Close()
+		promptStdin = r
+	}
+
+	t.Run("login rejects a bad key", func(t *testing.T) {
+		pipeKey(t, "sk-or-wrong-0000")
+		httpClient = newMockHTTPClient(http.StatusUnauthorized, `{"error": {"message": "No auth credentials found"}}`)
+
+		_, err := executeCommand(rootCmd, "--config", configPath, "auth", "login")
+		if !errors.Is(err, errAPIKeyRejected) {
+			t.Fatalf("expected the key to be rejected, but got %v", err)
+		}
+
+		written, _ := os.ReadFile(configPath)
+		if strings.Contains(string(written), "sk-or-wrong") {
+			t.Errorf("expected a rejected key not to be saved, but got %q", written)
+		}
+	})
+
+	t.Run("login saves a working key", func(t *testing.T) {
+		pipeKey(t, "sk-or-good-1234")
+		httpClient = newMockHTTPClient(http.StatusOK, `{"data": {"label": "sk-or-...1234"}}`)
+
+		if _, err := executeCommand(rootCmd, "--config", configPath, "auth", "login"); err != nil {
+			t.Fatalf("auth login failed: %v", err)
+		}
+
+		written, err := os.ReadFile(configPath)
+		if err != nil {
+			t.Fatalf("failed to read config: %v", err)
+		}
+
+		if !strings.Contains(string(written), "api_key: sk-or-good-1234") || !strings.Contains(string(written), "model: openai/gpt-4o") {
+			t.Errorf("expected the key to be added to the config, but got %q", written)
+		}
+	})
+
+	t.Run("status shows the masked key and credits", func(t *testing.T) {
+		httpClient = &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) *http.Response {
+				body := `{"data": {"label": "sk-or-...1234"}}`
+				if strings.HasSuffix(req.URL.Path, "/credits") {
+					body = `{"data": {"total_credits": 10, "total_usage": 2.5}}`
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}
+			}),
+		}
+
+		output, err := executeCommand(rootCmd, "--config", configPath, "auth", "status")
+		if err != nil {
+			t.Fatalf("auth status failed: %v", err)
+		}
+
+		for _, expected := range []string{"****1234", "Status: valid", "Credits: $7.50 remaining"} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("expected output to contain %q, but got %q", expected, output)
+			}
+		}
+
+		if strings.Contains(output, "sk-or-good") {
+			t.Errorf("expected the key to be masked, but got %q", output)
+		}
+	})
+}
+
+func TestCreditsCommand(t *testing.T) {
+	viper.Reset()
+	viper.Set("api_key", "super_secret_key")
+
+	originalHttpClient := httpClient
+	defer func() {
+		httpClient = originalHttpClient
+		viper.Reset()
+	}()
+
+	httpClient = newMockHTTPClient(http.StatusOK, `{"data": {"total_credits": 20, "total_usage": 4.25}}`)
+
+	output, err := executeCommand(rootCmd, "credits")
+	if err != nil {
+		t.Fatalf("credits command failed: %v", err)
+	}
+
+	for _, expected := range []string{"Total:     $20.00", "Used:      $4.25", "Remaining: $15.75"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected output to contain %q, but got %q", expected, output)
+		}
+	}
+}
+
+// newEchoHTTPClient answers each chat request with its last message, so concurrent requests can
+// be told apart. A prompt of "fail" gets a 400, and "busy" is rate limited once before succeeding
+func newEchoHTTPClient() *http.Client {
+	var mu sync.Mutex
+	rateLimited := false
+
+	return &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			var request llm.ChatCompletionRequest
+			_ = json.NewDecoder(req.Body).Decode(&request)
+			prompt := request.Messages[len(request.Messages)-1].Content
+
+			statusCode := http.StatusOK
+			body := fmt.Sprintf(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": %q}}]}`, "echo: "+prompt)
+
+			mu.Lock()
+			switch {
+			case prompt == "fail":
+				statusCode, body = http.StatusBadRequest, `{"error": {"message": "bad prompt"}}`
+			case prompt == "busy" && !rateLimited:
+				rateLimited = true
+				statusCode, body = http.StatusTooManyRequests, `{"error": {"message": "slow down"}}`
+			}
+			mu.Unlock()
+
+			return &http.Response{
+				StatusCode: statusCode,
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
+				Header:     make(http.Header),
+			}
+		}),
+	}
+}
+
+func TestBatch(t *testing.T) {
+	viper.Reset()
+	viper.Set("api_key", "super_secret_key")
+
+	originalHttpClient := httpClient
+	originalBackoff := batchRetryBackoff
+	defer func() {
+		httpClient = originalHttpClient
+		batchRetryBackoff = originalBackoff
+		viper.Reset()
+	}()
+
+	httpClient = newEchoHTTPClient()
+	batchRetryBackoff = time.Millisecond
+
+	resetBatchFlags := func() {
+		batchFlag = ""
+		batchConcurrencyFlag = 4
+		batchOutputFlag = ""
+		batchDelimiterFlag = ""
+	}
+
+	dir := t.TempDir()
+	writeBatchFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write batch file: %v", err)
+		}
+		return path
+	}
+
+	t.Run("prints JSON in prompt order", func(t *testing.T) {
+		defer resetBatchFlags()
+		path := writeBatchFile("prompts.txt", "first\n\nsecond\nbusy\nthird\n")
+
+		output, err := executeCommand(rootCmd, "--batch", path, "--concurrency", "2")
+		if err != nil {
+			t.Fatalf("batch failed: %v", err)
+		}
+
+		start := strings.Index(output, "[")
+		end := strings.LastIndex(output, "]")
+		if start == -1 || end == -1 {
+			t.Fatalf("expected a JSON array, but got %q", output)
+		}
+
+		var results []batchResult
+		if err := json.Unmarshal([]byte(output[start:end+1]), &results); err != nil {
+			t.Fatalf("failed to parse batch output %q: %v", output, err)
+		}
+
+		expected := []string{"echo: first", "echo: second", "echo: busy", "echo: third"}
+		if len(results) != len(expected) {
+			t.Fatalf("expected %d results, but got %d", len(expected), len(results))
+		}
+		for i, result := range results {
+			if result.Index != i+1 || result.Response != expected[i] {
+				t.Errorf("expected result %d to be %q, but got %+v", i+1, expected[i], result)
+			}
+		}
+
+		if !strings.Contains(output, "Answered 4 of 4 prompts") {
+			t.Errorf("expected a summary, but got %q", output)
+		}
+	})
+
+	t.Run("writes numbered files and reports failures", func(t *testing.T) {
+		defer resetBatchFlags()
+		path := writeBatchFile("delimited.txt", "line one\nline two\n---\nfail\n---\nlast")
+		outputDir := filepath.Join(dir, "answers")
+
+		output, err := executeCommand(rootCmd, "--batch", path, "--batch-delimiter", "---", "--batch-output", outputDir)
+		if err == nil {
+			t.Fatalf("expected an error for the failed prompt, but got none")
+		}
+
+		if !strings.Contains(output, "Answered 2 of 3 prompts, 1 failed") {
+			t.Errorf("expected a summary with the failure, but got %q", output)
+		}
+
+		answer, err := os.ReadFile(filepath.Join(outputDir, "1.md"))
+		if err != nil {
+			t.Fatalf("expected the first answer to be saved: %v", err)
+		}
+		if string(answer) != "echo: line one\nline two\n" {
+			t.Errorf("expected %q, but got %q", "echo: line one\nline two\n", string(answer))
+		}
+
+		if _, err := os.Stat(filepath.Join(outputDir, "2.md")); !os.IsNotExist(err) {
+			t.Errorf("expected no file for the failed prompt")
+		}
+	})
+
+	t.Run("runs hooks on every prompt", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("the hooks below are sh commands")
+		}
+		defer resetBatchFlags()
+		defer func() {
+			viper.Set(hookPreRequest, nil)
+			viper.Set(hookPostResponse, nil)
+		}()
+		viper.Set(hookPreRequest, "tr a-z A-Z")
+		viper.Set(hookPostResponse, "rev")
+		path := writeBatchFile("hooked.txt", "first\nsecond\n")
+
+		output, err := executeCommand(rootCmd, "--batch", path)
+		if err != nil {
+			t.Fatalf("batch failed: %v", err)
+		}
+		for _, expected := range []string{"TSRIF :ohce", "DNOCES :ohce"} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("expected %q in the output, but got %q", expected, output)
+			}
+		}
+	})
+
+	t.Run("dry run prints every request", func(t *testing.T) {
+		defer resetBatchFlags()
+		defer func() { dryRunFlag = false }()
+		httpClient = &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) *http.Response {
+				t.Errorf("expected --dry-run not to send anything, but got a request to %s", req.URL)
+				return &http.Response{StatusCode: http.StatusInternalServerError, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}
+			}),
+		}
+		defer func() { httpClient = newEchoHTTPClient() }()
+		path := writeBatchFile("dry.txt", "first\nsecond\n")
+
+		output, err := executeCommand(rootCmd, "--batch", path, "--dry-run")
+		if err != nil {
+			t.Fatalf("batch failed: %v", err)
+		}
+		for _, expected := range []string{`"content": "first"`, `"content": "second"`} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("expected %q in the printed requests, but got %q", expected, output)
+			}
+		}
+	})
+
+	t.Run("uses the configured temperature", func(t *testing.T) {
+		defer resetBatchFlags()
+		defer func() {
+			dryRunFlag = false
+			viper.Set("temperature", nil)
+		}()
+		viper.Set("temperature", 0.3)
+		path := writeBatchFile("warm.txt", "hello\n")
+
+		output, err := executeCommand(rootCmd, "--batch", path, "--dry-run")
+		if err != nil {
+			t.Fatalf("batch failed: %v", err)
+		}
+		if !strings.Contains(output, `"temperature": 0.3`) {
+			t.Errorf("expected the configured temperature in the request, but got %q", output)
+		}
+	})
+
+	t.Run("rejects templates", func(t *testing.T) {
+		defer resetBatchFlags()
+		defer func() { templateFlag = "" }()
+		path := writeBatchFile("one.txt", "hello")
+
+		_, err := executeCommand(rootCmd, "--batch", path, "--template", "review")
+		if err == nil || !strings.Contains(err.Error(), "--template") {
+			t.Errorf("expected a --template error, but got %v", err)
+		}
+	})
+
+	t.Run("rejects flags it doesn't apply", func(t *testing.T) {
+		defer resetBatchFlags()
+		defer func() {
+			attachFlag = nil
+			prefillFlag = ""
+			choicesFlag = 1
+		}()
+		path := writeBatchFile("one.txt", "hello")
+
+		_, err := executeCommand(rootCmd, "--batch", path, "--attach", path, "--prefill", "{", "-n", "2")
+		var usageErr *UsageError
+		if !errors.As(err, &usageErr) || !strings.Contains(err.Error(), "--attach, --choices, --prefill") {
+			t.Errorf("expected a usage error naming the flags, but got %v", err)
+		}
+	})
+}
+
+func TestTempSweep(t *testing.T) {
+	viper.Reset()
+	viper.Set("api_key", "super_secret_key")
+	viper.Set("raw", true)
+
+	originalHttpClient := httpClient
+	defer func() {
+		httpClient = originalHttpClient
+		tempSweepFlag = nil
+		viper.Reset()
+	}()
+
+	// Answers with the temperature it was asked at, so each answer can be matched to its header
+	httpClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			var request llm.ChatCompletionRequest
+			_ = json.NewDecoder(req.Body).Decode(&request)
+
+			body := fmt.Sprintf(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": "answered at %v"}}]}`, *request.Temperature)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
+				Header:     make(http.Header),
+			}
+		}),
+	}
+
+	t.Run("groups answers by temperature", func(t *testing.T) {
+		output, err := executeCommand(rootCmd, "--stream-mode=false", "--temp-sweep", "0,0.5,1.2", "Write a haiku")
+		if err != nil {
+			t.Fatalf("sweep failed: %v", err)
+		}
+
+		expected := "## Temperature 0\n\nanswered at 0\n\n## Temperature 0.5\n\nanswered at 0.5\n\n## Temperature 1.2\n\nanswered at 1.2\n\n"
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q, but got %q", expected, output)
+		}
+	})
+
+	t.Run("rejects out of range temperatures", func(t *testing.T) {
+		_, err := executeCommand(rootCmd, "--stream-mode=false", "--temp-sweep", "0.5,3", "Write a haiku")
+		if err == nil || !strings.Contains(err.Error(), "invalid temperature 3") {
+			t.Errorf("expected an invalid temperature error, but got %v", err)
+		}
+	})
+}
+
+func TestHistoryExport(t *testing.T) {
+	historyDirPath, err := getHistoryDirPath()
+	if err != nil {
+		t.Fatalf("failed to get history dir: %v", err)
+	}
+
+	conversation := history.NewConversation()
+	conversation.ID = "20260101-120000.000"
+	conversation.Model = "openai/gpt-4o"
+	conversation.Messages = []llm.ChatCompletionMessage{
+		{Role: "user", Content: "How do I print in Go?"},
+		{Role: "assistant", Content: "Like this:\n\n```go\nfmt.Println(\"hi\")\n```"},
+	}
+	if err := history.Save(historyDirPath, conversation); err != nil {
+		t.Fatalf("failed to save conversation: %v", err)
+	}
+
+	defer func() { historyExportFormatFlag = "md" }()
+
+	t.Run("markdown", func(t *testing.T) {
+		output, err := executeCommand(rootCmd, "history", "export", conversation.ID)
+		if err != nil {
+			t.Fatalf("history export failed: %v", err)
+		}
+
+		for _, expected := range []string{
+			"# Conversation 20260101-120000.000",
+			"- Model: openai/gpt-4o",
+			"## User\n\nHow do I print in Go?\n",
+			"## Assistant\n\nLike this:\n\n```go\nfmt.Println(\"hi\")\n```\n",
+		} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("expected output to contain %q, but got %q", expected, output)
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		output, err := executeCommand(rootCmd, "history", "export", conversation.ID, "--format", "json")
+		if err != nil {
+			t.Fatalf("history export failed: %v", err)
+		}
+
+		var exported history.Conversation
+		if err := json.Unmarshal([]byte(output), &exported); err != nil {
+			t.Fatalf("failed to parse exported JSON %q: %v", output, err)
+		}
+		if len(exported.Messages) != 2 || exported.Messages[0].Content != "How do I print in Go?" {
+			t.Errorf("expected the saved messages, but got %+v", exported.Messages)
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		_, err := executeCommand(rootCmd, "history", "export", "--format", "pdf")
+		if err == nil || !strings.Contains(err.Error(), "invalid format") {
+			t.Errorf("expected an invalid format error, but got %v", err)
+		}
+	})
+}
+
+func TestHistoryCommands(t *testing.T) {
+	viper.Reset()
+	viper.Set("api_key", "super_secret_key")
+	defer viper.Reset()
+
+	historyDirPath, err := getHistoryDirPath()
+	if err != nil {
+		t.Fatalf("failed to get history dir: %v", err)
+	}
+	if _, err := history.Clear(historyDirPath); err != nil {
+		t.Fatalf("failed to clear history: %v", err)
+	}
+
+	for i, prompt := range []string{"First question", "Second   question\nwith two lines"} {
+		conversation := history.NewConversation()
+		conversation.ID = fmt.Sprintf("2026010%d-120000.000", i+1)
+		conversation.Model = "openai/gpt-4o"
+		conversation.Messages = []llm.ChatCompletionMessage{
+			{Role: "user", Content: prompt},
+			{Role: "assistant", Content: "An answer"},
+		}
+		if err := history.Save(historyDirPath, conversation); err != nil {
+			t.Fatalf("failed to save conversation: %v", err)
+		}
+	}
+
+	t.Run("list", func(t *testing.T) {
+		output, err := executeCommand(rootCmd, "history", "list")
+		if err != nil {
+			t.Fatalf("history list failed: %v", err)
+		}
+
+		second := strings.Index(output, "20260102-120000.000")
+		first := strings.Index(output, "20260101-120000.000")
+		if second == -1 || first == -1 || second > first {
+			t.Errorf("expected both conversations, most recent first, but got %q", output)
+		}
+
+		if !strings.Contains(output, "Second question with two lines") {
+			t.Errorf("expected a one line preview of the first prompt, but got %q", output)
+		}
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		rootCmd.SetIn(strings.NewReader("/exit\n"))
+		defer rootCmd.SetIn(nil)
+
+		output, err := executeCommand(rootCmd, "history", "resume", "20260101-120000.000")
+		if err != nil {
+			t.Fatalf("history resume failed: %v", err)
+		}
+
+		if !strings.Contains(output, "Resuming 20260101-120000.000 (2 messages).") || !strings.Contains(output, "Chatting with openai/gpt-4o") {
+			t.Errorf("expected the session to resume, but got %q", output)
+		}
+	})
+
+	t.Run("rm", func(t *testing.T) {
+		if _, err := executeCommand(rootCmd, "history", "rm", "20260101-120000.000"); err != nil {
+			t.Fatalf("history rm failed: %v", err)
+		}
+
+		if _, err := history.Load(historyDirPath, "20260101-120000.000"); err == nil {
+			t.Errorf("expected the conversation to be deleted")
+		}
+
+		if _, err := executeCommand(rootCmd, "history", "rm", "../config"); err == nil {
+			t.Errorf("expected an error for an ID outside the history directory")
+		}
+	})
+
+	t.Run("last request isn't a conversation", func(t *testing.T) {
+		if err := history.SaveLastRequest(historyDirPath, &history.LastRequest{ChatCompletionRequest: llm.ChatCompletionRequest{Model: "openai/gpt-4o"}}); err != nil {
+			t.Fatalf("failed to save the last request: %v", err)
+		}
+
+		for _, command := range []string{"resume", "rm"} {
+			if _, err := executeCommand(rootCmd, "history", command, "last"); err == nil || !strings.Contains(err.Error(), "invalid conversation ID") {
+				t.Errorf("expected history %s to reject the last request, but got %v", command, err)
+			}
+		}
+		if _, err := history.LoadLastRequest(historyDirPath); err != nil {
+			t.Errorf("expected the last request to be kept, but got %v", err)
+		}
+
+		ids, _ := completeConversationIDs(historyRmCmd, nil, "")
+		if slices.Contains(ids, "last") || len(ids) != 1 {
+			t.Errorf("expected only the remaining conversation to be completed, but got %v", ids)
+		}
+	})
+
+	t.Run("clear", func(t *testing.T) {
+		originalStdin := promptStdin
+		defer func() {
+			promptStdin = originalStdin
+			historyClearYesFlag = false
+		}()
+
+		r, w, _ := os.Pipe()
+		w.WriteString("n\n")
+		w.Close()
+		promptStdin = r
+
+		output, err := executeCommand(rootCmd, "history", "clear")
+		if err != nil {
+			t.Fatalf("history clear failed: %v", err)
+		}
+		if !strings.Contains(output, "Nothing deleted.") {
+			t.Errorf("expected clear to be called off, but got %q", output)
+		}
+
+		output, err = executeCommand(rootCmd, "history", "clear", "--yes")
+		if err != nil {
+			t.Fatalf("history clear failed: %v", err)
+		}
+		if !strings.Contains(output, "Deleted 1 conversations") {
+			t.Errorf("expected the remaining conversation to be deleted, but got %q", output)
+		}
+	})
+}
+
+func TestMergePrompt(t *testing.T) {
+	all := promptContent{Args: "Summarize this", Stdin: "piped log", File: "file notes"}
+
+	tests := []struct {
+		name     string
+		prompt   promptContent
+		order    string
+		expected string
+	}{
+		{"file first lets the file win", all, promptMergeFileFirst, "file notes"},
+		{"unset order behaves like file first", all, "", "file notes"},
+		{"file first joins args and stdin", promptContent{Args: "Summarize this", Stdin: "piped log"}, promptMergeFileFirst, "Summarize this\n\npiped log"},
+		{"args first appends stdin and the file", all, promptMergeArgsFirst, "Summarize this\n\npiped log\n\nfile notes"},
+		{"args first with only a file", promptContent{File: "file notes"}, promptMergeArgsFirst, "file notes"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, err := mergePrompt(test.prompt, test.order)
+			if err != nil {
+				t.Fatalf("mergePrompt failed: %v", err)
+			}
+			if merged != test.expected {
+				t.Errorf("expected %q, but got %q", test.expected, merged)
+			}
+		})
+	}
+
+	t.Run("empty prompt", func(t *testing.T) {
+		if _, err := mergePrompt(promptContent{}, promptMergeFileFirst); err == nil {
+			t.Errorf("expected an error for an empty prompt")
+		}
+	})
+
+	t.Run("invalid order", func(t *testing.T) {
+		if _, err := mergePrompt(all, "stdin_first"); err == nil || !strings.Contains(err.Error(), "invalid prompt.merge_order") {
+			t.Errorf("expected an invalid merge order error, but got %v", err)
+		}
+	})
+}
+
+func TestMockMode(t *testing.T) {
+	viper.Reset()
+	viper.Set("mock", true)
+	viper.Set("raw", true)
+	defer viper.Reset()
+
+	t.Run("echoes the templated prompt without an API key", func(t *testing.T) {
+		viper.Set("templates.inline", map[string]any{"question": map[string]any{"user": "Q: {{.UserPrompt}}"}})
+		defer func() {
+			viper.Set("templates.inline", nil)
+			templateFlag = ""
+		}()
+
+		output, err := executeCommand(rootCmd, "-t", "question", "Why is the sky blue?")
+		if err != nil {
+			t.Fatalf("mock run failed: %v", err)
+		}
+
+		expected := "**Mock response** from `google/gemini-2.5-flash` to:\n\n> Q: Why is the sky blue?"
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q, but got %q", expected, output)
+		}
+	})
+
+	t.Run("blocking with a configured response and usage", func(t *testing.T) {
+		viper.Set("mock_response", "Canned answer")
+		viper.Set("show_usage", true)
+		defer func() {
+			viper.Set("mock_response", "")
+			viper.Set("show_usage", false)
+		}()
+
+		output, err := executeCommand(rootCmd, "--stream-mode=false", "Anything")
+		if err != nil {
+			t.Fatalf("mock run failed: %v", err)
+		}
+
+		for _, expected := range []string{"Canned answer", "Tokens: "} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("expected output to contain %q, but got %q", expected, output)
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		defer func() { jsonFlag = false }()
+
+		output, err := executeCommand(rootCmd, "--stream-mode=false", "--json", "List colors")
+		if err != nil {
+			t.Fatalf("mock run failed: %v", err)
+		}
+
+		expected := `{"mock":true,"model":"google/gemini-2.5-flash","prompt":"List colors"}`
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q, but got %q", expected, output)
+		}
+	})
+
+	t.Run("isn't saved to history", func(t *testing.T) {
+		historyDirPath, err := getHistoryDirPath()
+		if err != nil {
+			t.Fatalf("failed to get history dir: %v", err)
+		}
+		before, _ := history.List(historyDirPath)
+
+		if _, err := executeCommand(rootCmd, "Remember me?"); err != nil {
+			t.Fatalf("mock run failed: %v", err)
+		}
+
+		after, _ := history.List(historyDirPath)
+		if len(after) != len(before) {
+			t.Errorf("expected %d saved conversations, but got %d", len(before), len(after))
+		}
+	})
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"success", nil, 0},
+		{"usage", &UsageError{errors.New("no prompt provided")}, ExitUsage},
+		{"api error", fmt.Errorf("rate limited: %w", &llm.APIError{StatusCode: http.StatusTooManyRequests}), ExitAPIError},
+		{"no content", fmt.Errorf("%w: the answer was empty", ErrNoContent), ExitNoContent},
+		{"interrupted", fmt.Errorf("error reading stream: %w", context.Canceled), ExitInterrupted},
+		{"other", errors.New("boom"), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := ExitCode(tt.err); code != tt.expected {
+				t.Errorf("expected %d, but got %d", tt.expected, code)
+			}
+		})
+	}
+
+	t.Run("bad flag", func(t *testing.T) {
+		_, err := executeCommand(rootCmd, "--no-such-flag", "Hello")
+		if code := ExitCode(err); code != ExitUsage {
+			t.Errorf("expected %d, but got %d (%v)", ExitUsage, code, err)
+		}
+	})
+
+	t.Run("bad arguments", func(t *testing.T) {
+		markUsageErrors(rootCmd)
+
+		_, err := executeCommand(rootCmd, "history", "resume")
+		if code := ExitCode(err); code != ExitUsage {
+			t.Errorf("expected %d, but got %d (%v)", ExitUsage, code, err)
+		}
+	})
+}
+
+func TestEnvironmentVariables(t *testing.T) {
+	viper.Reset()
+
+	cfgFile = filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(cfgFile, []byte("model: fast\nlog:\n  format: json\n"), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	defer func() {
+		cfgFile = ""
+		viper.Reset()
+	}()
+
+	t.Setenv("LLM_MODEL", "openai/gpt-4.1-nano")
+	t.Setenv("LLM_BASE_URL", "http://localhost:11434/v1")
+	t.Setenv("LLM_LOG_FORMAT", "console")
+	t.Setenv("LLM_API_KEY", "")
+	t.Setenv("OPENROUTER_API_KEY", "sk-or-from-openrouter")
+
+	initConfig()
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"model", "openai/gpt-4.1-nano"},
+		{"base_url", "http://localhost:11434/v1"},
+		{"log.format", "console"},
+		{"api_key", "sk-or-from-openrouter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if value := viper.GetString(tt.key); value != tt.expected {
+				t.Errorf("expected %q, but got %q", tt.expected, value)
+			}
+		})
+	}
+
+	t.Run("LLM_API_KEY wins", func(t *testing.T) {
+		t.Setenv("LLM_API_KEY", "sk-or-from-llm")
+
+		if value := viper.GetString("api_key"); value != "sk-or-from-llm" {
+			t.Errorf("expected %q, but got %q", "sk-or-from-llm", value)
+		}
+	})
+}
+
+func TestOpenRouterAPIKeyEnv(t *testing.T) {
+	viper.Reset()
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte("model: openai/gpt-4.1-nano\nmodels:\n  validate: false\n"), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	originalHttpClient := httpClient
+	defer func() {
+		httpClient = originalHttpClient
+		cfgFile = ""
+		viper.Reset()
+	}()
+
+	var authorization string
+	httpClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			authorization = req.Header.Get("Authorization")
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{"id": "chat-env-test", "choices": [{"index": 0, "message": {"role": "assistant", "content": "Hi"}}]}`)),
+				Header:     make(http.Header),
+			}
+		}),
+	}
+
+	t.Setenv("LLM_API_KEY", "")
+	t.Setenv("OPENROUTER_API_KEY", "sk-or-from-env")
+
+	if _, err := executeCommand(rootCmd, "--config", configPath, "--stream-mode=false", "Hello"); err != nil {
+		t.Fatalf("root command failed: %v", err)
+	}
+
+	if expected := "Bearer sk-or-from-env"; authorization != expected {
+		t.Errorf("expected %q, but got %q", expected, authorization)
+	}
+}
+
+func TestSpinner(t *testing.T) {
+	t.Run("draws and clears its line", func(t *testing.T) {
+		var out bytes.Buffer
+		s := newSpinner(&out, "Waiting for fast", true)
+		time.Sleep(150 * time.Millisecond)
+		s.Stop()
+		s.Stop()
+
+		if !strings.Contains(out.String(), "Waiting for fast 0s") {
+			t.Errorf("expected the message with the elapsed time, but got %q", out.String())
+		}
+		if !strings.HasSuffix(out.String(), "\r\x1b[K") {
+			t.Errorf("expected the line to be cleared, but got %q", out.String())
+		}
+	})
+
+	t.Run("disabled writes nothing", func(t *testing.T) {
+		var out bytes.Buffer
+		newSpinner(&out, "Waiting for fast", false).Stop()
+
+		if out.Len() != 0 {
+			t.Errorf("expected no output, but got %q", out.String())
+		}
+	})
+}
+
+func TestWordWrapWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunks   []string
+		expected string
+	}{
+		{
+			name:     "breaks between words",
+			chunks:   []string{"The quick br", "own fox jumps over", " the lazy dog"},
+			expected: "The quick brown fox\njumps over the lazy\ndog",
+		},
+		{
+			name:     "keeps short lines and newlines",
+			chunks:   []string{"Short line\n", "Another one"},
+			expected: "Short line\nAnother one",
+		},
+		{
+			name:     "leaves code fences alone",
+			chunks:   []string{"```\nfmt.Println(\"a very long line of code\")\n```\n"},
+			expected: "```\nfmt.Println(\"a very long line of code\")\n```\n",
+		},
+		{
+			name:     "doesn't split long words",
+			chunks:   []string{"see https://example.com/a/very/long/path"},
+			expected: "see\nhttps://example.com/a/very/long/path",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := &wordWrapWriter{out: &out, width: 20}
+			for _, chunk := range tt.chunks {
+				if _, err := w.Write([]byte(chunk)); err != nil {
+					t.Fatalf("write failed: %v", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("flush failed: %v", err)
+			}
+
+			if out.String() != tt.expected {
+				t.Errorf("expected %q, but got %q", tt.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestPrintModelNote(t *testing.T) {
+	viper.Reset()
+	viper.Set("models.aliases", map[string]string{"fast": "openai/gpt-4.1-nano"})
+	defer viper.Reset()
+
+	tests := []struct {
+		requested string
+		model     string
+		expected  string
+	}{
+		{"fast", "openai/gpt-4.1-nano", "Model: openai/gpt-4.1-nano (alias fast)\n"},
+		{"google/gemini-2.5-pro", "google/gemini-2.5-pro", "Model: google/gemini-2.5-pro\n"},
+		// A template picked another model, so the alias typed isn't where it came from
+		{"fast", "google/gemini-2.5-pro", "Model: google/gemini-2.5-pro\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.requested+" "+tt.model, func(t *testing.T) {
+			var out bytes.Buffer
+			printModelNote(&out, tt.requested, tt.model)
+
+			if out.String() != tt.expected {
+				t.Errorf("expected %q, but got %q", tt.expected, out.String())
+			}
+		})
+	}
+
+	t.Run("quiet", func(t *testing.T) {
+		viper.Set("quiet", true)
+
+		var out bytes.Buffer
+		printModelNote(&out, "fast", "openai/gpt-4.1-nano")
+		if out.Len() != 0 {
+			t.Errorf("expected no note, but got %q", out.String())
+		}
+	})
+}
+
+func TestInputSizeLimit(t *testing.T) {
+	viper.Reset()
+	viper.Set("max_input_bytes", 16)
+	defer viper.Reset()
+
+	originalStdin := promptStdin
+	defer func() { promptStdin = originalStdin }()
+	promptStdin = erroringStdin{}
+
+	bigFile := filepath.Join(t.TempDir(), "big.txt")
+	if err := os.WriteFile(bigFile, []byte(strings.Repeat("too much text ", 10)), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	t.Run("prompt file", func(t *testing.T) {
+		_, err := getPromptContent(nil, bigFile, false)
+		if err == nil || !strings.Contains(err.Error(), "--force") {
+			t.Errorf("expected a size limit error suggesting --force, but got %v", err)
+		}
+	})
+
+	t.Run("attachment", func(t *testing.T) {
+		_, err := getAttachments([]string{bigFile}, 0)
+		if err == nil || !strings.Contains(err.Error(), "max_input_bytes") {
+			t.Errorf("expected a max_input_bytes error, but got %v", err)
+		}
+
+		// Both limits are checked before reading, so the size is what gets this one refused
+		binaryFile := filepath.Join(t.TempDir(), "big.bin")
+		if err := os.WriteFile(binaryFile, make([]byte, 64), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		_, err = getAttachments([]string{binaryFile}, 0)
+		if err == nil || !strings.Contains(err.Error(), "max_input_bytes") {
+			t.Errorf("expected the size to be checked before the content, but got %v", err)
+		}
+
+		viper.Set("max_input_bytes", 0)
+		defer viper.Set("max_input_bytes", 16)
+		_, err = getAttachments([]string{binaryFile}, 32)
+		if err == nil || !strings.Contains(err.Error(), "attach.max_bytes") {
+			t.Errorf("expected an attach.max_bytes error before reading, but got %v", err)
+		}
+	})
+
+	t.Run("stdin", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to create pipe: %v", err)
+		}
+		defer r.Close()
+		w.WriteString(strings.Repeat("too much text ", 10))
+		w.Close()
+		promptStdin = r
+		defer func() { promptStdin = erroringStdin{} }()
+
+		_, err = getPromptContent(nil, "", false)
+		if err == nil || !strings.Contains(err.Error(), "stdin is") {
+			t.Errorf("expected a size limit error, but got %v", err)
+		}
+	})
+
+	t.Run("--force lifts the limit", func(t *testing.T) {
+		forceFlag = true
+		defer func() { forceFlag = false }()
+
+		prompt, err := getPromptContent(nil, bigFile, false)
+		if err != nil || !strings.HasPrefix(prompt.Merged, "too much text") {
+			t.Errorf("expected the whole file, but got %q, %v", prompt.Merged, err)
+		}
+	})
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	originalHttpClient := httpClient
+	httpClient = nil
+	defer func() { httpClient = originalHttpClient }()
+
+	viper.Set("timeout", 50*time.Millisecond)
+
+	t.Run("blocking requests time out as a whole", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		_, err := newHTTPClient(false).Do(req)
+		if !errors.Is(err, context.DeadlineExceeded) && !os.IsTimeout(err) {
+			t.Errorf("expected a timeout, but got %v", err)
+		}
+	})
+
+	t.Run("streams outlive the timeout once started", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			for range 3 {
+				time.Sleep(50 * time.Millisecond)
+				fmt.Fprint(w, "chunk ")
+				w.(http.Flusher).Flush()
+			}
+		}))
+		defer server.Close()
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := newHTTPClient(true).Do(req)
+		if err != nil {
+			t.Fatalf("expected the stream to start, but got %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil || string(body) != "chunk chunk chunk " {
+			t.Errorf("expected the whole stream, but got %q, %v", body, err)
+		}
+	})
+
+	t.Run("streams still time out waiting for headers", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		if _, err := newHTTPClient(true).Do(req); err == nil || !strings.Contains(err.Error(), "timeout") {
+			t.Errorf("expected a response header timeout, but got %v", err)
+		}
+	})
+
+	t.Run("negative timeout is rejected", func(t *testing.T) {
+		viper.Set("api_key", "super_secret_key")
+		viper.Set("timeout", -time.Second)
+		defer viper.Set("timeout", 50*time.Millisecond)
+
+		if _, err := executeCommand(rootCmd, "Hello"); err == nil || !strings.Contains(err.Error(), "invalid timeout") {
+			t.Errorf("expected a negative timeout to be rejected, but got %v", err)
+		}
+	})
+}
+
+func TestHTTPTransport(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	originalHttpClient := httpClient
+	httpClient = nil
+	defer func() { httpClient = originalHttpClient }()
+
+	t.Run("proxy comes from the environment", func(t *testing.T) {
+		transport, err := httpTransport()
+		if err != nil {
+			t.Fatalf("failed to build transport: %v", err)
+		}
+
+		if transport.Proxy == nil || reflect.ValueOf(transport.Proxy).Pointer() != reflect.ValueOf(http.ProxyFromEnvironment).Pointer() {
+			t.Error("expected the transport to use http.ProxyFromEnvironment")
+		}
+	})
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	t.Run("untrusted certificate is refused", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		_, err := newHTTPClient(false).Do(req)
+		var unknownAuthority x509.UnknownAuthorityError
+		if !errors.As(err, &unknownAuthority) {
+			t.Errorf("expected the self-signed certificate to be refused, but got %v", err)
+		}
+	})
+
+	t.Run("ca_cert is trusted", func(t *testing.T) {
+		caCertPath := filepath.Join(t.TempDir(), "ca.pem")
+		caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		if err := os.WriteFile(caCertPath, caCert, 0644); err != nil {
+			t.Fatalf("failed to write CA certificate: %v", err)
+		}
+		viper.Set("ca_cert", caCertPath)
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := newHTTPClient(false).Do(req)
+		if err != nil {
+			t.Fatalf("expected the request to succeed, but got %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("expected %d, but got %d", http.StatusNoContent, resp.StatusCode)
+		}
+	})
+
+	t.Run("bad ca_cert fails requests", func(t *testing.T) {
+		viper.Set("ca_cert", filepath.Join(t.TempDir(), "missing.pem"))
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		if _, err := newHTTPClient(false).Do(req); err == nil || !strings.Contains(err.Error(), "CA certificate") {
+			t.Errorf("expected a CA certificate error, but got %v", err)
+		}
+	})
+}
+
+func TestSplitThinkTags(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		reasoning string
+		answer    string
+	}{
+		{"no tags", "Just the answer\n", "", "Just the answer\n"},
+		{"leading block", "<think>\nWeigh the options.\n</think>\n\nThe answer", "Weigh the options.", "The answer"},
+		{"several blocks", "<think>One</think>A<think>Two</think>B", "One\n\nTwo", "AB"},
+		{"unclosed block", "<think>Still going", "Still going", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reasoning, answer := splitThinkTags(tt.content)
+			if reasoning != tt.reasoning {
+				t.Errorf("expected reasoning %q, but got %q", tt.reasoning, reasoning)
+			}
+			if answer != tt.answer {
+				t.Errorf("expected answer %q, but got %q", tt.answer, answer)
+			}
+		})
+	}
+}
+
+func TestThinkTagWriter(t *testing.T) {
+	content := "<think>\nCount the r's.\n</think>\n\nThere are 3. <thin> isn't a tag"
+
+	// Every split point, so tags cut across chunks at any byte are covered
+	for split := 0; split <= len(content); split++ {
+		var answer, reasoning bytes.Buffer
+		w := newThinkTagWriter(&answer, &reasoning, nil)
+		w.Write([]byte(content[:split]))
+		w.Write([]byte(content[split:]))
+		if err := w.Flush(); err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+
+		if answer.String() != "There are 3. <thin> isn't a tag" {
+			t.Fatalf("split at %d: expected the answer without the block, but got %q", split, answer.String())
+		}
+		if reasoning.String() != "Reasoning:\nCount the r's.\n\n\n" {
+			t.Fatalf("split at %d: expected the block as reasoning, but got %q", split, reasoning.String())
+		}
+	}
+
+	t.Run("hidden reasoning leaves a note", func(t *testing.T) {
+		var answer, note bytes.Buffer
+		w := newThinkTagWriter(&answer, nil, &note)
+		w.Write([]byte("<think>Two words</think>Answer"))
+		w.Flush()
+
+		if answer.String() != "Answer" {
+			t.Errorf("expected %q, but got %q", "Answer", answer.String())
+		}
+		if note.String() != "Reasoning hidden (2 words), --show-reasoning shows it\n" {
+			t.Errorf("expected a note about the hidden reasoning, but got %q", note.String())
+		}
+	})
+}
+
+func TestPrintCitations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations []llm.Annotation
+		expected    string
+	}{
+		{"none", nil, ""},
+		{
+			name: "numbered in order, repeats listed once",
+			annotations: []llm.Annotation{
+				{Type: "url_citation", URLCitation: &llm.URLCitation{URL: "https://go.dev/blog", Title: "The Go Blog"}},
+				{Type: "file_citation"},
+				{Type: "url_citation", URLCitation: &llm.URLCitation{URL: "https://pkg.go.dev"}},
+				{Type: "url_citation", URLCitation: &llm.URLCitation{URL: "https://go.dev/blog", Title: "The Go Blog"}},
+			},
+			expected: "Sources:\n[1] The Go Blog - https://go.dev/blog\n[2] https://pkg.go.dev\n",
+		},
+		{
+			name:        "bare citation urls",
+			annotations: llm.CitationAnnotations([]string{"https://example.com"}),
+			expected:    "Sources:\n[1] https://example.com\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			printCitations(&out, tt.annotations)
+
+			if out.String() != tt.expected {
+				t.Errorf("expected %q, but got %q", tt.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestPrintResponseStats(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"empty", "", "Stats: 0 characters, 0 words, 0 lines"},
+		{"unterminated last line", "Héllo there\nGeneral Kenobi", "Stats: 26 characters, 4 words, 2 lines"},
+		{"trailing newline", "One line\n", "Stats: 9 characters, 2 words, 1 lines"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			printResponseStats(&out, tt.content, "no/such-model")
+
+			expected := fmt.Sprintf("%s, ~%d tokens\n", tt.expected, tokens.Estimate(tt.content, ""))
+			if out.String() != expected {
+				t.Errorf("expected %q, but got %q", expected, out.String())
+			}
+		})
+	}
+}
+
+func TestVersionCommand(t *testing.T) {
+	defer func() {
+		versionFlag = false
+		rootCmd.Flags().Lookup("version").Changed = false
+	}()
+
+	for _, args := range [][]string{{"version"}, {"--version"}, {"-V"}} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			output, err := executeCommand(rootCmd, args...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			versionFlag = false
+
+			for _, expected := range []string{"Version:  dev", "Commit:   dev", "Built:    dev", runtime.Version(), runtime.GOOS + "/" + runtime.GOARCH} {
+				if !strings.Contains(output, expected) {
+					t.Errorf("expected %q in the output, but got %q", expected, output)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"v1.2.3", "v1.2.3", 0},
+		{"1.2.3", "v1.2.3", 0},
+		{"v1.2.4", "v1.2.3", 1},
+		{"v1.10.0", "v1.9.9", 1},
+		{"v2.0.0", "v10.0.0", -1},
+		{"v1.2", "v1.2.0", 0},
+		{"v1.2.3-rc.1", "v1.2.3", -1},
+		{"v1.2.3", "v1.2.3-rc.1", 1},
+		{"v1.2.3-rc.10", "v1.2.3-rc.9", 1},
+		{"v1.2.3-alpha", "v1.2.3-beta", -1},
+		{"v1.2.3-alpha", "v1.2.3-alpha.1", -1},
+		{"v1.2.3+build.5", "v1.2.3", 0},
+	}
+
+	for _, test := range tests {
+		if got := compareVersions(test.a, test.b); got != test.expected {
+			t.Errorf("compareVersions(%q, %q): expected %d, but got %d", test.a, test.b, test.expected, got)
+		}
+	}
+
+	for _, v := range []string{"dev", "", "v1.x", "1.2.3.4"} {
+		if isReleaseVersion(v) {
+			t.Errorf("expected %q not to be a release version", v)
+		}
+	}
+}
+
+func TestUpdateCommand(t *testing.T) {
+	newBinary := []byte("new llm binary")
+	sum := sha256.Sum256(newBinary)
+	assetName := releaseAssetName(runtime.GOOS, runtime.GOARCH)
+
+	originalHTTPClient, originalVersion, originalExecutablePath := httpClient, version, executablePath
+	defer func() {
+		httpClient, version, executablePath = originalHTTPClient, originalVersion, originalExecutablePath
+		updateCheckFlag = false
+		updateForceFlag = false
+	}()
+
+	serveRelease := func(checksums string) {
+		httpClient = &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) *http.Response {
+				body := ""
+				switch req.URL.Path {
+				case "/repos/flacial/llm/releases/latest":
+					body = `{"tag_name": "v1.1.0", "assets": [
+						{"name": "` + assetName + `", "browser_download_url": "https://example.com/binary"},
+						{"name": "checksums.txt", "browser_download_url": "https://example.com/checksums.txt"}
+					]}`
+				case "/binary":
+					body = string(newBinary)
+				case "/checksums.txt":
+					body = checksums
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}
+			}),
+		}
+	}
+
+	installed := filepath.Join(t.TempDir(), "llm")
+	executablePath = func() (string, error) { return installed, nil }
+	install := func() {
+		t.Helper()
+		if err := os.WriteFile(installed, []byte("old llm binary"), 0755); err != nil {
+			t.Fatalf("failed to write the installed binary: %v", err)
+		}
+	}
+	installedContent := func() string {
+		t.Helper()
+		data, err := os.ReadFile(installed)
+		if err != nil {
+			t.Fatalf("failed to read the installed binary: %v", err)
+		}
+		return string(data)
+	}
+
+	validChecksums := hex.EncodeToString(sum[:]) + "  " + assetName + "\n"
+
+	t.Run("up to date", func(t *testing.T) {
+		install()
+		serveRelease(validChecksums)
+		version = "v1.1.0"
+
+		output, err := executeCommand(rootCmd, "update")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(output, "llm v1.1.0 is up to date") || installedContent() != "old llm binary" {
+			t.Errorf("expected nothing to be installed, but got %q", output)
+		}
+	})
+
+	t.Run("check ignores --force", func(t *testing.T) {
+		install()
+		serveRelease(validChecksums)
+		version = "v1.1.0"
+
+		output, err := executeCommand(rootCmd, "update", "--check", "--force")
+		updateCheckFlag = false
+		updateForceFlag = false
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(output, "llm v1.1.0 is up to date") || installedContent() != "old llm binary" {
+			t.Errorf("expected the check to report it's up to date, but got %q", output)
+		}
+	})
+
+	t.Run("check only reports", func(t *testing.T) {
+		install()
+		serveRelease(validChecksums)
+		version = "v1.0.0"
+
+		output, err := executeCommand(rootCmd, "update", "--check")
+		updateCheckFlag = false
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(output, "Update available: v1.0.0 -> v1.1.0") || installedContent() != "old llm binary" {
+			t.Errorf("expected the update to only be reported, but got %q", output)
+		}
+	})
+
+	t.Run("replaces the executable", func(t *testing.T) {
+		install()
+		serveRelease(validChecksums)
+		version = "v1.0.0"
+
+		output, err := executeCommand(rootCmd, "update")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(output, "Updated llm v1.0.0 -> v1.1.0") || installedContent() != string(newBinary) {
+			t.Errorf("expected the new binary to be installed, but got %q", output)
+		}
+
+		info, err := os.Stat(installed)
+		if err != nil || info.Mode().Perm()&0100 == 0 {
+			t.Errorf("expected the new binary to be executable, but got %v", info.Mode())
+		}
+
+		entries, _ := os.ReadDir(filepath.Dir(installed))
+		if len(entries) != 1 {
+			t.Errorf("expected no temporary files left behind, but got %v", entries)
+		}
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		install()
+		serveRelease(strings.Repeat("0", 64) + "  " + assetName + "\n")
+		version = "v1.0.0"
+
+		if _, err := executeCommand(rootCmd, "update"); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Errorf("expected a checksum mismatch, but got %v", err)
+		}
+		if installedContent() != "old llm binary" {
+			t.Error("expected the installed binary to be left alone")
+		}
+	})
+
+	t.Run("development build", func(t *testing.T) {
+		install()
+		serveRelease(validChecksums)
+		version = "dev"
+
+		if _, err := executeCommand(rootCmd, "update"); err == nil {
+			t.Error("expected a development build to need --force")
+		}
+
+		_, err := executeCommand(rootCmd, "update", "--force")
+		updateForceFlag = false
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if installedContent() != string(newBinary) {
+			t.Error("expected --force to install the release")
+		}
+	})
+}
+
+func TestFirstRunConfig(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	t.Setenv("LLM_API_KEY", "sk-or-from-the-environment")
+	t.Setenv("LLM_BASE_URL", "http://localhost:11434/v1")
+
+	// viper.Reset dropped them, and a real run has every flag bound when the config is created
+	for key, flag := range map[string]string{"mock": "mock", "max_tokens": "max-tokens", "temperature": "temperature", "top_p": "top-p", "seed": "seed"} {
+		viper.BindPFlag(key, rootCmd.Flags().Lookup(flag))
+	}
+
+	for run := 1; run <= 2; run++ {
+		if _, err := executeCommand(rootCmd, "--mock", "hello"); err != nil {
+			t.Fatalf("run %d on a fresh home failed: %v", run, err)
+		}
+	}
+	rootCmd.Flags().Set("mock", "false")
+	rootCmd.Flags().Lookup("mock").Changed = false
+
+	written, err := os.ReadFile(filepath.Join(configHome, "llm", "config.yaml"))
+	if err != nil {
+		t.Fatalf("expected a config file to be created: %v", err)
+	}
+
+	for _, key := range []string{"api_key", "sk-or-from", "base_url", "max_tokens", "temperature", "top_p", "seed", "mock"} {
+		if strings.Contains(string(written), key) {
+			t.Errorf("expected %q to stay out of the new config, but got:\n%s", key, written)
+		}
+	}
+	for _, expected := range []string{"model: google/gemini-2.5-flash", "validate: true"} {
+		if !strings.Contains(string(written), expected) {
+			t.Errorf("expected the defaults in the new config, missing %q in:\n%s", expected, written)
+		}
+	}
+}
+
+func TestChatCommand(t *testing.T) {
+	viper.Reset()
+	viper.Set("api_key", "super_secret_key")
+
+	originalHttpClient := httpClient
+	defer func() {
+		httpClient = originalHttpClient
+		rootCmd.SetIn(nil)
+		viper.Reset()
+	}()
+
+	// Every request is kept so the conversation sent with each line can be checked
+	var requests []llm.ChatCompletionRequest
+	httpClient = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) *http.Response {
+			var request llm.ChatCompletionRequest
+			_ = json.NewDecoder(req.Body).Decode(&request)
+			requests = append(requests, request)
+
+			prompt := request.Messages[len(request.Messages)-1].Content
+			body := fmt.Sprintf(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": %q}}]}`, "echo: "+prompt)
+			if request.Stream {
+				body = fmt.Sprintf("data: {\"choices\": [{\"index\": 0, \"delta\": {\"content\": %q}}]}\n\ndata: [DONE]\n\n", "echo: "+prompt)
+			}
+
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
+				Header:     make(http.Header),
+			}
+		}),
+	}
+
+	chat := func(t *testing.T, lines ...string) string {
+		t.Helper()
+		requests = nil
+		rootCmd.SetIn(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+
+		output, err := executeCommand(rootCmd, "chat")
+		if err != nil {
+			t.Fatalf("chat failed: %v", err)
+		}
+		return output
+	}
+
+	t.Run("conversation carries over until /reset", func(t *testing.T) {
+		output := chat(t, "first", "second", "/reset", "third", "/exit", "never sent")
+
+		if !strings.Contains(output, "echo: first") || !strings.Contains(output, "Conversation reset.") {
+			t.Errorf("expected the answers and the reset note, but got %q", output)
+		}
+		if len(requests) != 3 {
+			t.Fatalf("expected 3 requests, with nothing sent after /exit, but got %d", len(requests))
+		}
+		if len(requests[1].Messages) != 3 {
+			t.Errorf("expected the second line to carry the first turn, but got %+v", requests[1].Messages)
+		}
+		if len(requests[2].Messages) != 1 {
+			t.Errorf("expected /reset to drop the earlier turns, but got %+v", requests[2].Messages)
+		}
+	})
+
+	t.Run("model switching", func(t *testing.T) {
+		output := chat(t, "/model openai/gpt-4o", "/model", "hello")
+
+		if !strings.Contains(output, "Switched to openai/gpt-4o.") || !strings.Contains(output, "Current model: openai/gpt-4o") {
+			t.Errorf("expected the switch to be confirmed, but got %q", output)
+		}
+		if len(requests) != 1 || requests[0].Model != "openai/gpt-4o" {
+			t.Errorf("expected the request to use the new model, but got %+v", requests)
+		}
+	})
+
+	t.Run("hooks", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("the hooks below are sh commands")
+		}
+		defer func() {
+			viper.Set(hookPreRequest, nil)
+			viper.Set(hookPostResponse, nil)
+		}()
+		viper.Set(hookPreRequest, "tr a-z A-Z")
+		viper.Set(hookPostResponse, "rev")
+
+		output := chat(t, "hello")
+
+		if len(requests) != 1 || requests[0].Messages[0].Content != "HELLO" {
+			t.Fatalf("expected the line to go through the pre-request hook, but got %+v", requests)
+		}
+		if requests[0].Stream {
+			t.Error("expected a post-response hook to wait for the whole answer")
+		}
+		if !strings.Contains(output, "OLLEH :ohce") {
+			t.Errorf("expected the answer to go through the post-response hook, but got %q", output)
+		}
+	})
+
+	t.Run("default system prompt", func(t *testing.T) {
+		viper.Set("default_system_prompt", "Be brief.")
+		defer viper.Set("default_system_prompt", nil)
+
+		chat(t, "hello", "/reset", "again")
+
+		if len(requests) != 2 {
+			t.Fatalf("expected 2 requests, but got %d", len(requests))
+		}
+		for i, request := range requests {
+			if len(request.Messages) != 2 || request.Messages[0].Role != "system" || request.Messages[0].Content != "Be brief." {
+				t.Errorf("expected request %d to start with the default system prompt, but got %+v", i+1, request.Messages)
+			}
+		}
+
+		// A resumed conversation keeps the system message it started with
+		historyDirPath, err := getHistoryDirPath()
+		if err != nil {
+			t.Fatalf("failed to get history dir: %v", err)
+		}
+		conversation := &history.Conversation{ID: "20260103-120000.000", Messages: []llm.ChatCompletionMessage{
+			{Role: "system", Content: "Be thorough."},
+			{Role: "user", Content: "hi"},
+			{Role: "assistant", Content: "echo: hi"},
+		}}
+		if err := history.Save(historyDirPath, conversation); err != nil {
+			t.Fatalf("failed to save conversation: %v", err)
+		}
+		defer history.Delete(historyDirPath, conversation.ID)
+
+		requests = nil
+		rootCmd.SetIn(strings.NewReader("hello\n"))
+		if _, err := executeCommand(rootCmd, "history", "resume", conversation.ID); err != nil {
+			t.Fatalf("history resume failed: %v", err)
+		}
+		if len(requests) != 1 || len(requests[0].Messages) != 4 || requests[0].Messages[0].Content != "Be thorough." {
+			t.Errorf("expected the resumed system message alone, but got %+v", requests)
+		}
+	})
+
+	t.Run("save and unknown commands", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "chat.json")
+		output := chat(t, "hello", "/save "+path, "/save", "/bogus")
+
+		if !strings.Contains(output, "Saved conversation to "+path) || !strings.Contains(output, "Usage: /save <file>") {
+			t.Errorf("expected the save to be confirmed and the usage shown, but got %q", output)
+		}
+		if !strings.Contains(output, `Unknown command "/bogus"`) {
+			t.Errorf("expected an unknown command note, but got %q", output)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("expected the conversation to be saved: %v", err)
+		}
+		if !strings.Contains(string(data), "echo: hello") {
+			t.Errorf("expected the saved conversation to have the answer, but got %s", data)
+		}
+	})
+}
+
+func TestUsage(t *testing.T) {
+	viper.Reset()
+	viper.Set("models.cache_ttl", time.Hour)
+	defer viper.Reset()
+
+	// A fresh cache keeps estimateCost off the network
+	if err := writeModelsCache([]OpenRouterModel{
+		{ID: "openai/gpt-4o", Pricing: ModelPricing{Prompt: "0.000002", Completion: "0.00001"}},
+		{ID: "broken/pricing", Pricing: ModelPricing{Prompt: "n/a", Completion: "n/a"}},
+	}); err != nil {
+		t.Fatalf("failed to write models cache: %v", err)
+	}
+	defer os.Remove(mustModelsCachePath(t))
+
+	usage := &llm.Usage{PromptTokens: 1000, CompletionTokens: 500, TotalTokens: 1500}
+
+	t.Run("estimate cost", func(t *testing.T) {
+		cost, ok := estimateCost("", "openai/gpt-4o", usage)
+		if !ok || math.Abs(cost-0.007) > 1e-9 {
+			t.Errorf("expected ~$0.007, but got %v, %v", cost, ok)
+		}
+
+		for _, model := range []string{"unknown/model", "broken/pricing"} {
+			if _, ok := estimateCost("", model, usage); ok {
+				t.Errorf("expected no estimate for %s", model)
+			}
+		}
+	})
+
+	t.Run("print usage", func(t *testing.T) {
+		var output bytes.Buffer
+		printUsage(&output, "", "openai/gpt-4o", usage)
+		if expected := "Tokens: 1000 prompt + 500 completion = 1500 total (~$0.007000)\n"; output.String() != expected {
+			t.Errorf("expected %q, but got %q", expected, output.String())
+		}
+
+		output.Reset()
+		printUsage(&output, "", "unknown/model", usage)
+		if expected := "Tokens: 1000 prompt + 500 completion = 1500 total\n"; output.String() != expected {
+			t.Errorf("expected %q, but got %q", expected, output.String())
+		}
+
+		output.Reset()
+		printUsage(&output, "", "openai/gpt-4o", nil)
+		if output.Len() != 0 {
+			t.Errorf("expected nothing without usage, but got %q", output.String())
+		}
+	})
+
+	t.Run("--usage after the answer", func(t *testing.T) {
+		originalHttpClient := httpClient
+		defer func() { httpClient = originalHttpClient }()
+		httpClient = newMockHTTPClient(http.StatusOK, `{"choices": [{"index": 0, "message": {"role": "assistant", "content": "Hi!"}}], "usage": {"prompt_tokens": 1000, "completion_tokens": 500, "total_tokens": 1500}}`)
+
+		viper.Set("api_key", "super_secret_key")
+		viper.Set("model", "openai/gpt-4o")
+		viper.Set("show_usage", true)
+
+		output, err := executeCommand(rootCmd, "--stream-mode=false", "--raw", "Hello")
+		if err != nil {
+			t.Fatalf("root command failed: %v", err)
+		}
+		if !strings.Contains(output, "Tokens: 1000 prompt + 500 completion = 1500 total (~$0.007000)") {
+			t.Errorf("expected the usage line, but got %q", output)
 		}
 	})
 }
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/viper"
+)
+
+var noSpinnerFlag bool
+
+var spinnerFrames = []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+
+// spinner shows that a blocking request is still on its way, with how long it's been waiting. It
+// draws on a single line it clears again once stopped
+type spinner struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startSpinner starts a spinner on stderr, unless stderr isn't a terminal or it's turned off with
+// --quiet or --no-spinner. Stdout is never touched, so piped answers stay clean
+func startSpinner(message string) *spinner {
+	enabled := isatty.IsTerminal(os.Stderr.Fd()) && !viper.GetBool("quiet") && !viper.GetBool("no_spinner")
+	return newSpinner(os.Stderr, message, enabled)
+}
+
+func newSpinner(out io.Writer, message string, enabled bool) *spinner {
+	if !enabled {
+		return &spinner{}
+	}
+
+	s := &spinner{stop: make(chan struct{}), done: make(chan struct{})}
+	started := time.Now()
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
+		for frame := 0; ; frame++ {
+			fmt.Fprintf(out, "\r%c %s %ds", spinnerFrames[frame%len(spinnerFrames)], message, int(time.Since(started).Seconds()))
+
+			select {
+			case <-s.stop:
+				fmt.Fprint(out, "\r\x1b[K")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return s
+}
+
+// Stop clears the spinner's line and returns once it's gone, so nothing printed after it gets
+// overwritten. It's safe to call more than once
+func (s *spinner) Stop() {
+	if s.stop == nil {
+		return
+	}
+
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+}
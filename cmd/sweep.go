@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/pkg/llm"
+)
+
+// sweepResult is the answer at one temperature of a --temp-sweep
+type sweepResult struct {
+	temperature float64
+	content     string
+	err         error
+}
+
+// runTempSweep sends the same request once per temperature and prints the answers grouped under a
+// header each. Blocking requests go out --concurrency at a time, while streamed ones run one after
+// another so their output doesn't interleave
+func runTempSweep(ctx context.Context, llmClient *llm.LLMClient, baseRequest llm.ChatCompletionRequest, temperatures []float64, streaming bool, outputFile io.Writer) error {
+	for _, temperature := range temperatures {
+		request := baseRequest
+		request.Temperature = &temperature
+		if err := request.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if batchConcurrencyFlag < 1 {
+		return fmt.Errorf("invalid concurrency %d: must be at least 1", batchConcurrencyFlag)
+	}
+
+	var results []sweepResult
+	if streaming {
+		results = streamTempSweep(ctx, llmClient, baseRequest, temperatures, outputFile)
+	} else {
+		results = fetchTempSweep(ctx, llmClient, baseRequest, temperatures)
+		for _, result := range results {
+			printSweepResult(os.Stdout, outputFile, result, baseRequest.ResponseFormat != nil)
+		}
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d temperatures failed", failed, len(results))
+	}
+
+	return nil
+}
+
+func fetchTempSweep(ctx context.Context, llmClient *llm.LLMClient, baseRequest llm.ChatCompletionRequest, temperatures []float64) []sweepResult {
+	results := make([]sweepResult, len(temperatures))
+	limit := make(chan struct{}, batchConcurrencyFlag)
+
+	var wg sync.WaitGroup
+	for i, temperature := range temperatures {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			request := baseRequest
+			request.Temperature = &temperature
+			results[i] = sweepResult{temperature: temperature}
+
+			completion, err := llmClient.GetChatCompletion(ctx, request)
+			switch {
+			case err != nil:
+				results[i].err = explainAPIError(err)
+			case len(completion.Choices) == 0:
+				results[i].err = errors.New("no completion choices received")
+			default:
+				results[i].content = completion.Choices[0].Message.Content
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+func streamTempSweep(ctx context.Context, llmClient *llm.LLMClient, baseRequest llm.ChatCompletionRequest, temperatures []float64, outputFile io.Writer) []sweepResult {
+	var results []sweepResult
+
+	for _, temperature := range temperatures {
+		if ctx.Err() != nil {
+			break
+		}
+
+		request := baseRequest
+		request.Temperature = &temperature
+
+		var streamOutput io.Writer = os.Stdout
+		if outputFile != nil {
+			streamOutput = io.MultiWriter(streamOutput, outputFile)
+		}
+
+		fmt.Fprintf(streamOutput, "%s\n\n", sweepHeader(temperature))
+		streamed, err := llmClient.GetStreamingChatCompletion(ctx, request, streamOutput)
+		fmt.Fprint(streamOutput, "\n\n")
+
+		result := sweepResult{temperature: temperature, err: err}
+		if err != nil {
+			result.err = explainAPIError(err)
+			log.Logger.Error().Err(result.err).Float64("temperature", temperature).Msg("Error getting streaming chat completion")
+		}
+		if streamed != nil {
+			result.content = streamed.Content
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func printSweepResult(w io.Writer, outputFile io.Writer, result sweepResult, expectJSON bool) {
+	header := sweepHeader(result.temperature)
+	body := result.content
+	if result.err != nil {
+		body = "Error: " + result.err.Error()
+	}
+
+	if outputFile != nil {
+		fmt.Fprintf(outputFile, "%s\n\n%s\n\n", header, body)
+	}
+
+	if rawOutput() || result.err != nil {
+		fmt.Fprintf(w, "%s\n\n%s\n\n", header, body)
+		return
+	}
+
+	rendered, err := renderResponse(body, expectJSON)
+	if err != nil {
+		log.Logger.Error().Err(err).Msg("Error rendering output.")
+		rendered = body
+	}
+	fmt.Fprintf(w, "%s\n\n%s\n\n", header, rendered)
+}
+
+func sweepHeader(temperature float64) string {
+	return "## Temperature " + strconv.FormatFloat(temperature, 'f', -1, 64)
+}
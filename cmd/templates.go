@@ -0,0 +1,389 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/internal/templating"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const templateFileSuffix = ".tmpl.yaml"
+
+// Config key holding the templates defined without a file
+const inlineTemplatesKey = "templates.inline"
+
+const templateScaffold = `# Used to refer to the template with --template
+name: %q
+# Shown by "llm templates list"
+description: ""
+# Optional instructions that frame every request made with this template
+system_message: |
+  You are a helpful assistant.
+# The prompt sent to the model. {{.UserPrompt}} is replaced by your input,
+# {{.Args}} and {{.Stdin}} by just the arguments or piped stdin,
+# {{.Vars.key}} by values passed with --var key=value, and
+# {{.Attachments}} by the --attach files
+user_prompt_template: |
+  {{.UserPrompt}}
+# Optional overrides:
+# model: "google/gemini-2.5-flash"
+# temperature: 0.7
+`
+
+var templatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "Manage prompt templates",
+	Long:  `List, inspect, create, and edit the prompt templates stored in ~/.llm/templates.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+	},
+}
+
+var templatesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List installed templates",
+	Args:  cobra.NoArgs,
+	RunE:  runTemplatesListCommand,
+}
+
+var templatesShowCmd = &cobra.Command{
+	Use:               "show <name>",
+	Short:             "Print a template in full",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeTemplateNames,
+	RunE:              runTemplatesShowCommand,
+}
+
+var templatesNewCmd = &cobra.Command{
+	Use:   "new <name>",
+	Short: "Scaffold a new template and open it in $EDITOR",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runTemplatesNewCommand,
+}
+
+var templatesEditCmd = &cobra.Command{
+	Use:               "edit <name>",
+	Short:             "Open an existing template in $EDITOR",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeTemplateNames,
+	RunE:              runTemplatesEditCommand,
+}
+
+var templatesValidateCmd = &cobra.Command{
+	Use:               "validate [name]",
+	Short:             "Check templates for YAML and template syntax errors",
+	Long:              `Loads each template (or only the named one), parses its user_prompt_template, and reports syntax errors and references to data that doesn't exist, before a broken template wastes an API call.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeTemplateNames,
+	RunE:              runTemplatesValidateCommand,
+}
+
+func runTemplatesListCommand(cmd *cobra.Command, args []string) error {
+	names, err := listTemplateNames()
+	if err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		fmt.Println("No templates found. Add one to ~/.llm/templates to get started.")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tDESCRIPTION\tMODEL\tTEMPERATURE")
+	for _, name := range names {
+		tmpl, err := loadTemplate(name)
+		if err != nil {
+			fmt.Fprintf(tw, "%s\t(invalid: %v)\t\t\n", name, err)
+			continue
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+			name,
+			truncateString(tmpl.Description, 60),
+			valueOrDash(tmpl.Model),
+			formatOptionalFloat(tmpl.Temperature))
+	}
+
+	return tw.Flush()
+}
+
+func runTemplatesShowCommand(cmd *cobra.Command, args []string) error {
+	name := args[0]
+
+	tmpl, err := loadTemplate(name)
+	if err != nil {
+		return err
+	}
+
+	templatePath, err := getTemplatePath(name)
+	if err != nil {
+		return err
+	}
+	if _, ok := inlineTemplateFields(name); ok {
+		templatePath = fmt.Sprintf("%s (%s.%s)", viper.ConfigFileUsed(), inlineTemplatesKey, strings.ToLower(name))
+	}
+
+	fmt.Printf("Name: %s\n", name)
+	fmt.Printf("Path: %s\n", templatePath)
+	if tmpl.Extends != "" {
+		fmt.Printf("Extends: %s\n", tmpl.Extends)
+	}
+	fmt.Printf("Description: %s\n", valueOrDash(tmpl.Description))
+	fmt.Printf("Model: %s\n", valueOrDash(tmpl.Model))
+	fmt.Printf("Temperature: %s\n", formatOptionalFloat(tmpl.Temperature))
+	if len(tmpl.RequiredVars) > 0 {
+		fmt.Printf("Required variables: %s\n", strings.Join(tmpl.RequiredVars, ", "))
+	}
+	fmt.Printf("\nSystem message:\n%s\n", valueOrDash(strings.TrimSpace(tmpl.SystemMessage)))
+	fmt.Printf("\nUser prompt template:\n%s\n", valueOrDash(strings.TrimSpace(tmpl.UserPromptTemplate)))
+
+	return nil
+}
+
+func runTemplatesValidateCommand(cmd *cobra.Command, args []string) error {
+	names := args
+	if len(names) == 0 {
+		var err error
+		names, err = listTemplateNames()
+		if err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, name := range names {
+		tmpl, err := loadTemplate(name)
+		if err == nil {
+			err = tmpl.Validate()
+		}
+
+		if err != nil {
+			failed++
+			fmt.Printf("%s: %v\n", name, err)
+			continue
+		}
+
+		fmt.Printf("%s: ok\n", name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d template(s) failed validation", failed, len(names))
+	}
+
+	return nil
+}
+
+func runTemplatesNewCommand(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid template name %q", name)
+	}
+
+	templatePath, err := getTemplatePath(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(templatePath); err == nil {
+		return fmt.Errorf("template %q already exists, use 'llm templates edit %s' instead", name, name)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(templatePath), 0755); err != nil {
+		return fmt.Errorf("failed to create template directory: %w", err)
+	}
+
+	if err := os.WriteFile(templatePath, []byte(fmt.Sprintf(templateScaffold, name)), 0644); err != nil {
+		return fmt.Errorf("failed to write template %q: %w", name, err)
+	}
+
+	fmt.Printf("Created %s\n", templatePath)
+
+	return editTemplateFile(templatePath)
+}
+
+func runTemplatesEditCommand(cmd *cobra.Command, args []string) error {
+	templatePath, err := getTemplatePath(args[0])
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(templatePath); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("template %q not found, use 'llm templates new %s' to create it", args[0], args[0])
+		}
+		return err
+	}
+
+	return editTemplateFile(templatePath)
+}
+
+// editTemplateFile opens the template in the user's editor and checks the result once it's
+// closed. Problems are reported but the file is kept so the user can fix it
+func editTemplateFile(templatePath string) error {
+	if err := openInEditor(templatePath); err != nil {
+		return err
+	}
+
+	tmpl, err := templating.LoadFile(templatePath)
+	if err != nil {
+		log.Logger.Warn().Err(err).Str("path", templatePath).Msg("The template doesn't parse. Run 'llm templates edit' again to fix it.")
+		return nil
+	}
+
+	if strings.TrimSpace(tmpl.UserPromptTemplate) == "" {
+		log.Logger.Warn().Str("path", templatePath).Msg("The template has an empty user_prompt_template, so your input will be sent as is.")
+	}
+
+	return nil
+}
+
+// openInEditor runs $VISUAL or $EDITOR (falling back to vi) on path and waits for it to exit
+func openInEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// Editors are often configured with arguments, e.g. "code --wait"
+	editorArgs := strings.Fields(editor)
+	editorCmd := exec.Command(editorArgs[0], append(editorArgs[1:], path)...)
+	editorCmd.Stdin = os.Stdin
+	editorCmd.Stdout = os.Stdout
+	editorCmd.Stderr = os.Stderr
+
+	if err := editorCmd.Run(); err != nil {
+		return fmt.Errorf("failed to run editor %q: %w", editor, err)
+	}
+
+	return nil
+}
+
+func getTemplatePath(name string) (string, error) {
+	templateDirPath, err := getTemplateDirPath()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(templateDirPath, name+templateFileSuffix), nil
+}
+
+// loadTemplate reads the template that `--template name` refers to, along with the templates it
+// extends and includes
+func loadTemplate(name string) (*templating.Template, error) {
+	tmpl, err := templating.Resolve(name, loadTemplateDefinition)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.AllowSystemAccess = viper.GetBool(templating.SystemAccessKey)
+
+	return tmpl, nil
+}
+
+// loadTemplateDefinition reads one template as written. An inline template in the config wins
+// over a file of the same name
+func loadTemplateDefinition(name string) (*templating.Template, error) {
+	if fields, ok := inlineTemplateFields(name); ok {
+		return templating.ParseInline(name, fields)
+	}
+
+	templatePath, err := getTemplatePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := templating.LoadFile(templatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("template %q not found in %s", name, filepath.Dir(templatePath))
+	}
+
+	return tmpl, err
+}
+
+// inlineTemplateFields looks up templates.inline.<name>. Config keys are case-insensitive, so
+// the name is too
+func inlineTemplateFields(name string) (map[string]any, bool) {
+	fields, ok := viper.GetStringMap(inlineTemplatesKey)[strings.ToLower(name)].(map[string]any)
+	return fields, ok
+}
+
+// listTemplateNames returns the names of the installed templates, as accepted by --template,
+// inline ones included
+func listTemplateNames() ([]string, error) {
+	templateDirPath, err := getTemplateDirPath()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(templateDirPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read template directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), templateFileSuffix) {
+			continue
+		}
+
+		names = append(names, strings.TrimSuffix(entry.Name(), templateFileSuffix))
+	}
+
+	for name := range viper.GetStringMap(inlineTemplatesKey) {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func completeTemplateNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	names, _ := listTemplateNames()
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
+
+func formatOptionalFloat(value *float64) string {
+	if value == nil {
+		return "-"
+	}
+
+	return strconv.FormatFloat(*value, 'f', -1, 64)
+}
+
+func init() {
+	rootCmd.AddCommand(templatesCmd)
+
+	templatesCmd.AddCommand(templatesListCmd)
+	templatesCmd.AddCommand(templatesShowCmd)
+	templatesCmd.AddCommand(templatesNewCmd)
+	templatesCmd.AddCommand(templatesEditCmd)
+	templatesCmd.AddCommand(templatesValidateCmd)
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// splitThinkTags pulls the <think> blocks some models write into the answer itself out of content,
+// returning what was inside them and the answer that's left. A block that's never closed runs to
+// the end, as when the answer was cut off mid-thought
+func splitThinkTags(content string) (reasoning, answer string) {
+	var thoughts []string
+	var rest strings.Builder
+
+	for {
+		before, after, found := strings.Cut(content, thinkOpenTag)
+		rest.WriteString(before)
+		if !found {
+			break
+		}
+
+		thought, remaining, _ := strings.Cut(after, thinkCloseTag)
+		thoughts = append(thoughts, strings.TrimSpace(thought))
+		content = remaining
+	}
+
+	if len(thoughts) == 0 {
+		return "", rest.String()
+	}
+
+	return strings.Join(thoughts, "\n\n"), strings.TrimLeft(rest.String(), " \t\r\n")
+}
+
+// thinkNoteWriter is where the note about hidden <think> reasoning goes, nil under --quiet
+func thinkNoteWriter() io.Writer {
+	if viper.GetBool("quiet") {
+		return nil
+	}
+
+	return os.Stderr
+}
+
+// printHiddenReasoning stands in for reasoning that isn't shown, so it's clear the model thought
+// before answering and how to see it
+func printHiddenReasoning(w io.Writer, reasoning string) {
+	if w == nil {
+		return
+	}
+
+	fmt.Fprintf(w, "Reasoning hidden (%d words), --show-reasoning shows it\n", len(strings.Fields(reasoning)))
+}
+
+// thinkTagWriter splits <think> blocks out of a streamed answer, sending them to reasoning, or
+// collapsing them into a note when reasoning is nil. A tag can arrive split across chunks, so
+// text that could still turn out to be one is held back until the next write decides it
+type thinkTagWriter struct {
+	answer    io.Writer
+	reasoning io.Writer
+	note      io.Writer
+
+	pending string
+	inThink bool
+	thought strings.Builder
+	// Set after a tag, so the newlines models put around them don't end up on screen
+	trimLeading bool
+}
+
+func newThinkTagWriter(answer, reasoning, note io.Writer) *thinkTagWriter {
+	return &thinkTagWriter{answer: answer, reasoning: reasoning, note: note}
+}
+
+func (w *thinkTagWriter) Write(p []byte) (int, error) {
+	w.pending += string(p)
+
+	for {
+		tag := thinkOpenTag
+		if w.inThink {
+			tag = thinkCloseTag
+		}
+
+		i := strings.Index(w.pending, tag)
+		if i < 0 {
+			break
+		}
+
+		if err := w.emit(w.pending[:i]); err != nil {
+			return 0, err
+		}
+		w.pending = w.pending[i+len(tag):]
+
+		if err := w.toggle(); err != nil {
+			return 0, err
+		}
+	}
+
+	tag := thinkOpenTag
+	if w.inThink {
+		tag = thinkCloseTag
+	}
+	held := partialTagLength(w.pending, tag)
+	if err := w.emit(w.pending[:len(w.pending)-held]); err != nil {
+		return 0, err
+	}
+	w.pending = w.pending[len(w.pending)-held:]
+
+	return len(p), nil
+}
+
+// Flush writes out text held back as a possible tag once the stream ends without completing it
+func (w *thinkTagWriter) Flush() error {
+	pending := w.pending
+	w.pending = ""
+
+	return w.emit(pending)
+}
+
+func (w *thinkTagWriter) toggle() error {
+	w.inThink = !w.inThink
+	w.trimLeading = true
+
+	if w.inThink {
+		w.thought.Reset()
+		if w.reasoning != nil {
+			_, err := io.WriteString(w.reasoning, "Reasoning:\n")
+			return err
+		}
+		return nil
+	}
+
+	if w.reasoning == nil {
+		printHiddenReasoning(w.note, w.thought.String())
+		return nil
+	}
+
+	_, err := io.WriteString(w.reasoning, "\n\n")
+	return err
+}
+
+func (w *thinkTagWriter) emit(text string) error {
+	if w.trimLeading {
+		text = strings.TrimLeft(text, " \t\r\n")
+		if text == "" {
+			return nil
+		}
+		w.trimLeading = false
+	}
+
+	if !w.inThink {
+		_, err := io.WriteString(w.answer, text)
+		return err
+	}
+
+	w.thought.WriteString(text)
+	if w.reasoning == nil {
+		return nil
+	}
+
+	_, err := io.WriteString(w.reasoning, text)
+	return err
+}
+
+// partialTagLength is how many bytes at the end of s could be the start of tag
+func partialTagLength(s, tag string) int {
+	for n := min(len(tag)-1, len(s)); n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/flacial/llm/internal/tokens"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var tokensModelFlag string
+var tokensPromptFileFlag string
+
+var tokensCmd = &cobra.Command{
+	Use:   "tokens [prompt]",
+	Short: "Estimate how many tokens a prompt is without sending it",
+	Long: `Estimates the token count of a prompt given as arguments, piped through stdin, or read with -f.
+Nothing is sent to the API. When the model is in the cached models list, its tokenizer is used for
+the estimate and the context left for the answer is shown too.`,
+	RunE: runTokensCommand,
+}
+
+func runTokensCommand(cmd *cobra.Command, args []string) error {
+	prompt, err := getPromptContent(args, tokensPromptFileFlag, false)
+	if err != nil {
+		return err
+	}
+
+	requestedModel := viper.GetString("model")
+	if tokensModelFlag != "" {
+		requestedModel = tokensModelFlag
+	}
+	model := resolveModel(requestedModel)
+
+	cachedModel, found := findCachedModel(model)
+
+	tokenizer := ""
+	if found {
+		tokenizer = cachedModel.Architecture.Tokenizer
+	}
+
+	count := tokens.Estimate(prompt.Merged, tokenizer)
+	fmt.Printf("Estimated tokens: %d\n", count)
+
+	if !found {
+		if model != "" {
+			fmt.Printf("Model %s isn't in the cached models list, run 'llm models' to fetch it for its context length.\n", model)
+		}
+		return nil
+	}
+
+	if cachedModel.ContextLength > 0 {
+		fmt.Printf("Context length of %s: %d tokens, ~%d left for the answer\n", model, cachedModel.ContextLength, cachedModel.ContextLength-count)
+	}
+
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(tokensCmd)
+
+	tokensCmd.Flags().StringVarP(&tokensModelFlag, "model", "m", "", "Model to estimate for (defaults to the configured model)")
+	tokensCmd.RegisterFlagCompletionFunc("model", completeModelNames)
+	tokensCmd.Flags().StringVarP(&tokensPromptFileFlag, "prompt-file", "f", "", "Path to a file containing the prompt")
+}
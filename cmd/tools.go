@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/flacial/llm/internal/utils"
+	"github.com/flacial/llm/pkg/llm"
+)
+
+var toolsFlag string
+
+// loadTools reads the --tools file, a JSON array of tools in the OpenAI format. A bare function
+// definition without the {"type": "function", "function": ...} wrapper is accepted too
+func loadTools(path string) ([]llm.Tool, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(utils.ExpandPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading tools file %q: %w", path, err)
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid tools file %q: expected a JSON array of tools: %w", path, err)
+	}
+
+	tools := make([]llm.Tool, 0, len(entries))
+	for i, entry := range entries {
+		var tool llm.Tool
+		if err := json.Unmarshal(entry, &tool); err != nil {
+			return nil, fmt.Errorf("invalid tool %d in %q: %w", i+1, path, err)
+		}
+
+		if tool.Function.Name == "" {
+			if err := json.Unmarshal(entry, &tool.Function); err != nil {
+				return nil, fmt.Errorf("invalid tool %d in %q: %w", i+1, path, err)
+			}
+		}
+		if tool.Function.Name == "" {
+			return nil, fmt.Errorf("invalid tool %d in %q: missing a function name", i+1, path)
+		}
+		tool.Type = "function"
+
+		tools = append(tools, tool)
+	}
+
+	return tools, nil
+}
+
+// printToolCalls prints the calls the model asked for as JSON, in the API's own format, so they
+// can be handed to whatever runs the tools
+func printToolCalls(w io.Writer, calls []llm.ToolCall) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(calls); err != nil {
+		return fmt.Errorf("failed to print tool calls: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flacial/llm/internal/utils"
+	"github.com/flacial/llm/pkg/llm"
+)
+
+var traceFileFlag string
+
+// traceMu keeps writes from concurrent requests, like a --batch run, whole. Their response bodies
+// can still take turns in the file, but the request entries never mix
+var traceMu sync.Mutex
+
+// traceHTTPClient appends every request and its raw response to a file, exactly as they went over
+// the wire, for bug reports. Streamed responses are copied chunk by chunk as they're read, so the
+// trace shows every SSE line even if the stream is cut off
+type traceHTTPClient struct {
+	next llm.HTTPClient
+	path string
+}
+
+func (c *traceHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		var err error
+		requestBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body for the trace: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	file, err := os.OpenFile(c.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+
+	var entry bytes.Buffer
+	fmt.Fprintf(&entry, ">>> %s %s %s\n", time.Now().Format(time.RFC3339), req.Method, req.URL)
+	writeTraceHeaders(&entry, req.Header)
+	fmt.Fprintf(&entry, "\n%s\n\n", requestBody)
+
+	resp, err := c.next.Do(req)
+	if err != nil {
+		fmt.Fprintf(&entry, "<<< error: %v\n\n", err)
+		writeTrace(file, entry.Bytes())
+		file.Close()
+		return nil, err
+	}
+
+	fmt.Fprintf(&entry, "<<< %d %s\n", resp.StatusCode, http.StatusText(resp.StatusCode))
+	writeTraceHeaders(&entry, resp.Header)
+	entry.WriteString("\n")
+	writeTrace(file, entry.Bytes())
+
+	resp.Body = &traceBody{ReadCloser: resp.Body, file: file}
+	return resp, nil
+}
+
+// traceBody copies the response body to the trace file as the client reads it
+type traceBody struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (b *traceBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		writeTrace(b.file, p[:n])
+	}
+
+	return n, err
+}
+
+func (b *traceBody) Close() error {
+	writeTrace(b.file, []byte("\n\n"))
+	b.file.Close()
+
+	return b.ReadCloser.Close()
+}
+
+// writeTrace ignores failures, a broken trace shouldn't fail the request it's recording
+func writeTrace(file *os.File, p []byte) {
+	traceMu.Lock()
+	defer traceMu.Unlock()
+
+	file.Write(p)
+}
+
+// writeTraceHeaders writes the headers sorted by name, with the API key masked
+func writeTraceHeaders(w io.Writer, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range header[name] {
+			if strings.EqualFold(name, "Authorization") {
+				scheme, token, found := strings.Cut(value, " ")
+				if found {
+					value = scheme + " " + utils.MaskSecret(token)
+				} else {
+					value = utils.MaskSecret(value)
+				}
+			}
+			fmt.Fprintf(w, "%s: %s\n", name, value)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/flacial/llm/internal/log"
+	"github.com/spf13/cobra"
+)
+
+const (
+	updateRepository = "flacial/llm"
+	// Published with every release, in sha256sum's "<hash>  <file>" format
+	checksumsAssetName = "checksums.txt"
+	// Far above any real build, it only stops a broken download from filling the memory
+	maxUpdateDownloadBytes = 256 << 20
+)
+
+// Variables so tests can point the update at a fake release and a throwaway binary
+var githubAPIURL = "https://api.github.com"
+var executablePath = os.Executable
+
+var updateCheckFlag bool
+var updateForceFlag bool
+
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update llm to the latest release",
+	Long: `Checks the latest GitHub release of llm and, when it's newer than this build, downloads the binary
+for this OS and architecture, verifies it against the release checksums, and replaces the running
+executable with it. Use --check to only see whether an update is available.`,
+	Args: cobra.NoArgs,
+	RunE: runUpdateCommand,
+}
+
+// githubRelease is the part of GitHub's release API response the update needs
+type githubRelease struct {
+	TagName string         `json:"tag_name"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name        string `json:"name"`
+	DownloadURL string `json:"browser_download_url"`
+}
+
+func runUpdateCommand(cmd *cobra.Command, args []string) error {
+	out := cmd.OutOrStdout()
+
+	release, err := fetchLatestRelease()
+	if err != nil {
+		return err
+	}
+
+	// A build without a version can't be compared, so it only updates when asked to. --force only
+	// changes what gets installed, a check reports the same either way
+	devBuild := !isReleaseVersion(version)
+	upToDate := !devBuild && compareVersions(release.TagName, version) <= 0
+
+	if updateCheckFlag {
+		switch {
+		case devBuild:
+			fmt.Fprintf(out, "This is a development build, the latest release is %s\n", release.TagName)
+		case upToDate:
+			fmt.Fprintf(out, "llm %s is up to date\n", version)
+		default:
+			fmt.Fprintf(out, "Update available: %s -> %s\nRun 'llm update' to install it\n", version, release.TagName)
+		}
+		return nil
+	}
+
+	if upToDate && !updateForceFlag {
+		fmt.Fprintf(out, "llm %s is up to date\n", version)
+		return nil
+	}
+
+	if devBuild && !updateForceFlag {
+		return fmt.Errorf("this is a development build, so there's no telling whether %s is newer. Run 'llm update --force' to install it anyway", release.TagName)
+	}
+
+	assetName := releaseAssetName(runtime.GOOS, runtime.GOARCH)
+	binaryAsset, found := findAsset(release.Assets, assetName)
+	if !found {
+		return fmt.Errorf("release %s has no build for %s/%s (expected an asset named %q)", release.TagName, runtime.GOOS, runtime.GOARCH, assetName)
+	}
+	checksumsAsset, found := findAsset(release.Assets, checksumsAssetName)
+	if !found {
+		return fmt.Errorf("release %s has no %s to verify the download against", release.TagName, checksumsAssetName)
+	}
+
+	checksums, err := downloadAsset(checksumsAsset)
+	if err != nil {
+		return err
+	}
+	binary, err := downloadAsset(binaryAsset)
+	if err != nil {
+		return err
+	}
+	if err := verifyChecksum(binary, checksums, assetName); err != nil {
+		return err
+	}
+
+	path, err := executablePath()
+	if err != nil {
+		return fmt.Errorf("error finding the llm executable: %w", err)
+	}
+	// Replace the binary itself, not a symlink to it such as one a package manager made
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	if err := replaceExecutable(path, binary); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Updated llm %s -> %s\n", version, release.TagName)
+	return nil
+}
+
+// fetchLatestRelease asks GitHub for the newest release that isn't a draft or prerelease
+func fetchLatestRelease() (githubRelease, error) {
+	url := fmt.Sprintf("%s/repos/%s/releases/latest", githubAPIURL, updateRepository)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return githubRelease{}, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "llm/"+version)
+
+	resp, err := newHTTPClient(false).Do(req)
+	if err != nil {
+		return githubRelease{}, fmt.Errorf("failed to reach GitHub: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return githubRelease{}, errors.New("no llm release has been published yet")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return githubRelease{}, fmt.Errorf("GitHub releases returned status %d", resp.StatusCode)
+	}
+
+	var release githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return githubRelease{}, fmt.Errorf("failed to parse the latest release: %w", err)
+	}
+	if release.TagName == "" {
+		return githubRelease{}, errors.New("the latest release has no tag")
+	}
+
+	return release, nil
+}
+
+// releaseAssetName is what the binary for goos and goarch is called in a release
+func releaseAssetName(goos, goarch string) string {
+	name := fmt.Sprintf("llm_%s_%s", goos, goarch)
+	if goos == "windows" {
+		name += ".exe"
+	}
+
+	return name
+}
+
+func findAsset(assets []releaseAsset, name string) (releaseAsset, bool) {
+	for _, asset := range assets {
+		if asset.Name == name {
+			return asset, true
+		}
+	}
+
+	return releaseAsset{}, false
+}
+
+func downloadAsset(asset releaseAsset) ([]byte, error) {
+	req, err := http.NewRequest("GET", asset.DownloadURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("User-Agent", "llm/"+version)
+
+	// Only the wait for headers is bounded, so a slow connection can still finish the download
+	resp, err := newHTTPClient(true).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", asset.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %s returned status %d", asset.Name, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxUpdateDownloadBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", asset.Name, err)
+	}
+	if len(data) > maxUpdateDownloadBytes {
+		return nil, fmt.Errorf("%s is larger than %d MB, refusing to download it", asset.Name, maxUpdateDownloadBytes>>20)
+	}
+
+	log.Logger.Debug().Str("asset", asset.Name).Int("bytes", len(data)).Msg("Downloaded release asset.")
+	return data, nil
+}
+
+// verifyChecksum checks data against the SHA-256 that checksums lists for name
+func verifyChecksum(data, checksums []byte, name string) error {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// sha256sum marks files hashed in binary mode with a leading *
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+
+		sum := sha256.Sum256(data)
+		if !strings.EqualFold(fields[0], hex.EncodeToString(sum[:])) {
+			return fmt.Errorf("checksum mismatch for %s, the download may be corrupted or tampered with", name)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%s has no checksum for %s", checksumsAssetName, name)
+}
+
+// replaceExecutable swaps the binary at path for data. The new binary is written next to the old
+// one and renamed over it, so a failure halfway leaves the old one working rather than a partial
+// file
+func replaceExecutable(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, ".llm-update-*")
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return fmt.Errorf("no permission to write to %s, run the update with the rights to change it (e.g. sudo)", dir)
+		}
+		return fmt.Errorf("error creating the new executable: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // Gone already when the rename succeeded
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, info.Mode().Perm()|0111)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing the new executable: %w", err)
+	}
+
+	if runtime.GOOS != "windows" {
+		if err := os.Rename(tmpPath, path); err != nil {
+			return fmt.Errorf("error replacing %s: %w", path, err)
+		}
+		return nil
+	}
+
+	// Windows refuses to overwrite a running executable but lets it be renamed out of the way. The
+	// old one can't be deleted while running either, so it's cleaned up by the next update
+	oldPath := path + ".old"
+	os.Remove(oldPath)
+	if err := os.Rename(path, oldPath); err != nil {
+		return fmt.Errorf("error moving %s aside: %w", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Rename(oldPath, path)
+		return fmt.Errorf("error replacing %s: %w", path, err)
+	}
+	os.Remove(oldPath)
+
+	return nil
+}
+
+// isReleaseVersion reports whether v is a version number rather than something like "dev"
+func isReleaseVersion(v string) bool {
+	_, _, ok := parseVersion(v)
+	return ok
+}
+
+// parseVersion splits a semantic version like v1.2.3-rc.1 into its numbers and prerelease. Build
+// metadata after a + is ignored, as semver says it doesn't affect precedence
+func parseVersion(v string) (numbers [3]int, prerelease string, ok bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	v, _, _ = strings.Cut(v, "+")
+	v, prerelease, _ = strings.Cut(v, "-")
+
+	parts := strings.Split(v, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return numbers, "", false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return numbers, "", false
+		}
+		numbers[i] = n
+	}
+
+	return numbers, prerelease, true
+}
+
+// compareVersions returns -1, 0, or 1 as a is older than, the same as, or newer than b. A
+// prerelease comes before the release it leads up to
+func compareVersions(a, b string) int {
+	aNumbers, aPrerelease, _ := parseVersion(a)
+	bNumbers, bPrerelease, _ := parseVersion(b)
+
+	for i := range aNumbers {
+		if aNumbers[i] != bNumbers[i] {
+			if aNumbers[i] < bNumbers[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case aPrerelease == bPrerelease:
+		return 0
+	case aPrerelease == "":
+		return 1
+	case bPrerelease == "":
+		return -1
+	}
+
+	return comparePrereleases(aPrerelease, bPrerelease)
+}
+
+// comparePrereleases orders prereleases identifier by identifier, numbers numerically, so rc.10
+// comes after rc.9
+func comparePrereleases(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNumber, aErr := strconv.Atoi(aParts[i])
+		bNumber, bErr := strconv.Atoi(bParts[i])
+
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNumber != bNumber {
+				if aNumber < bNumber {
+					return -1
+				}
+				return 1
+			}
+		// Numeric identifiers sort before alphanumeric ones
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(aParts[i], bParts[i]); c != 0 {
+				return c
+			}
+		}
+	}
+
+	switch {
+	case len(aParts) < len(bParts):
+		return -1
+	case len(aParts) > len(bParts):
+		return 1
+	}
+
+	return 0
+}
+
+func init() {
+	rootCmd.AddCommand(updateCmd)
+
+	updateCmd.Flags().BoolVar(&updateCheckFlag, "check", false, "Only report whether a newer release is available")
+	updateCmd.Flags().BoolVar(&updateForceFlag, "force", false, "Install the latest release even if it isn't newer, or this is a development build")
+}
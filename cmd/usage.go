@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/flacial/llm/internal/log"
+	"github.com/flacial/llm/pkg/llm"
+)
+
+// estimateCost prices a request using the per-token rates OpenRouter publishes for the model.
+// It reports false when the model or its pricing can't be found
+func estimateCost(apiKey, model string, usage *llm.Usage) (float64, bool) {
+	models, err := loadModels(apiKey, false)
+	if err != nil {
+		log.Logger.Debug().Err(err).Msg("Failed to fetch models for cost estimation.")
+		return 0, false
+	}
+
+	for _, m := range models {
+		if m.ID != model {
+			continue
+		}
+
+		promptPrice, err := strconv.ParseFloat(m.Pricing.Prompt, 64)
+		if err != nil {
+			return 0, false
+		}
+
+		completionPrice, err := strconv.ParseFloat(m.Pricing.Completion, 64)
+		if err != nil {
+			return 0, false
+		}
+
+		return float64(usage.PromptTokens)*promptPrice + float64(usage.CompletionTokens)*completionPrice, true
+	}
+
+	return 0, false
+}
+
+func printUsage(w io.Writer, apiKey, model string, usage *llm.Usage) {
+	if usage == nil {
+		log.Logger.Warn().Msg("The response didn't include token usage.")
+		return
+	}
+
+	line := fmt.Sprintf("Tokens: %d prompt + %d completion = %d total", usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens)
+	if cost, ok := estimateCost(apiKey, model, usage); ok {
+		line += fmt.Sprintf(" (~$%.6f)", cost)
+	}
+
+	fmt.Fprintln(w, line)
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/spf13/cobra"
+)
+
+// Set at build time, e.g. go build -ldflags "-X github.com/flacial/llm/cmd.version=v1.2.0"
+var (
+	version = "dev"
+	commit  = "dev"
+	date    = "dev"
+)
+
+var versionFlag bool
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the version and build details of llm",
+	Long:  `Prints the version, commit, and build date of this llm binary along with the Go version and platform it was built for. Include it in bug reports.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Fprint(cmd.OutOrStdout(), versionInfo())
+	},
+}
+
+func versionInfo() string {
+	return fmt.Sprintf("Version:  %s\nCommit:   %s\nBuilt:    %s\nGo:       %s\nPlatform: %s/%s\n",
+		version, commit, date, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+
+	// Cobra answers --version itself once Version is set, using the flag defined here for the -V
+	// shorthand since -v is --verbose
+	rootCmd.Version = version
+	rootCmd.SetVersionTemplate(versionInfo())
+	rootCmd.Flags().BoolVarP(&versionFlag, "version", "V", false, "Print the version and build details")
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/flacial/llm/pkg/llm"
+	"github.com/spf13/viper"
+)
+
+var webFlag bool
+
+// How --web turns on search, set with web.mode
+const (
+	// The web plugin, which works with any model
+	webModePlugin = "plugin"
+	// The model's :online variant
+	webModeOnline = "online"
+)
+
+// applyWebSearch turns on OpenRouter's web search for the request, the way web.mode says
+func applyWebSearch(body *llm.ChatCompletionRequest) error {
+	switch mode := viper.GetString("web.mode"); mode {
+	case webModePlugin:
+		body.Plugins = append(body.Plugins, llm.Plugin{ID: llm.WebPluginID})
+	case webModeOnline:
+		body.Model = onlineModel(body.Model)
+		// Falling back to a model without search would quietly drop it
+		for i, model := range body.Models {
+			body.Models[i] = onlineModel(model)
+		}
+	default:
+		return &UsageError{fmt.Errorf("invalid web.mode %q, expected %q or %q", mode, webModePlugin, webModeOnline)}
+	}
+
+	return nil
+}
+
+func onlineModel(model string) string {
+	if strings.HasSuffix(model, llm.OnlineModelSuffix) {
+		return model
+	}
+
+	return model + llm.OnlineModelSuffix
+}
+
+// citationOutput is where the sources of an answer go: after it on stdout, and in --output-file.
+// JSON answers keep stdout to themselves, so their sources go to stderr
+func citationOutput(outputFile *os.File, expectJSON bool) io.Writer {
+	var out io.Writer = os.Stdout
+	if expectJSON {
+		out = os.Stderr
+	}
+
+	if outputFile != nil {
+		return io.MultiWriter(out, outputFile)
+	}
+
+	return out
+}
+
+// printCitations lists the web pages an answer cites, numbered in the order they first appear.
+// A page cited several times is listed once
+func printCitations(w io.Writer, annotations []llm.Annotation) {
+	seen := make(map[string]bool)
+	var citations []*llm.URLCitation
+	for _, annotation := range annotations {
+		citation := annotation.URLCitation
+		if annotation.Type != "url_citation" || citation == nil || seen[citation.URL] {
+			continue
+		}
+		seen[citation.URL] = true
+		citations = append(citations, citation)
+	}
+
+	if len(citations) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "Sources:")
+	for i, citation := range citations {
+		if citation.Title != "" {
+			fmt.Fprintf(w, "[%d] %s - %s\n", i+1, citation.Title, citation.URL)
+		} else {
+			fmt.Fprintf(w, "[%d] %s\n", i+1, citation.URL)
+		}
+	}
+}
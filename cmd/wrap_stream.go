@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mattn/go-isatty"
+	"github.com/mattn/go-runewidth"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+// wordWrapWriter breaks streamed text between words before it reaches the edge of the terminal,
+// instead of leaving the terminal to split words wherever the line runs out. The word being
+// streamed, and the spaces before it, are held back until it's complete, so a wrap never leaves
+// them dangling at the end of a line. Code fences are passed through untouched
+type wordWrapWriter struct {
+	out   io.Writer
+	width int
+	// Display width of what's already on the current line
+	column int
+	// The spaces before the word still being streamed and the word itself, not yet on screen
+	spaces strings.Builder
+	word   strings.Builder
+	// The raw text of the current line, to spot code fences
+	line    strings.Builder
+	inFence bool
+}
+
+// wrapStreamedOutput reports whether streamed answers get word wrapped: only on a terminal, and
+// not with stream.wrap off, --raw, or JSON answers, where added newlines would get in the way
+func wrapStreamedOutput(expectJSON bool) bool {
+	return viper.GetBool("stream.wrap") && !expectJSON && !rawOutput() && isatty.IsTerminal(os.Stdout.Fd())
+}
+
+func newWordWrapWriter(out io.Writer) *wordWrapWriter {
+	return &wordWrapWriter{out: out, width: terminalWidth()}
+}
+
+func (w *wordWrapWriter) Write(p []byte) (int, error) {
+	var buf strings.Builder
+
+	for _, r := range string(p) {
+		switch {
+		case r == '\n':
+			w.flushWord(&buf)
+			buf.WriteRune(r)
+
+			line := strings.TrimSpace(w.line.String())
+			if strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~") {
+				w.inFence = !w.inFence
+			}
+			w.line.Reset()
+			w.column = 0
+		case w.inFence:
+			w.line.WriteRune(r)
+			buf.WriteRune(r)
+		case unicode.IsSpace(r):
+			w.line.WriteRune(r)
+			if w.word.Len() > 0 {
+				w.flushWord(&buf)
+			}
+			w.spaces.WriteRune(r)
+		default:
+			w.line.WriteRune(r)
+			w.word.WriteRune(r)
+		}
+	}
+
+	if _, err := io.WriteString(w.out, buf.String()); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush prints the last word once the stream ends, since nothing comes after it to complete it
+func (w *wordWrapWriter) Flush() error {
+	var buf strings.Builder
+	w.flushWord(&buf)
+
+	_, err := io.WriteString(w.out, buf.String())
+	return err
+}
+
+// flushWord moves the held back spaces and word to buf. When they wouldn't fit on the line, the
+// spaces become a line break instead. Words wider than the terminal are left for it to split
+func (w *wordWrapWriter) flushWord(buf *strings.Builder) {
+	spaces, word := w.spaces.String(), w.word.String()
+	w.spaces.Reset()
+	w.word.Reset()
+
+	wordWidth := runewidth.StringWidth(word)
+	if w.column > 0 && word != "" && w.column+len(spaces)+wordWidth > w.width {
+		buf.WriteRune('\n')
+		w.column = 0
+		spaces = ""
+	}
+
+	buf.WriteString(spaces)
+	buf.WriteString(word)
+	w.column += len(spaces) + wordWidth
+	if w.column > w.width {
+		w.column %= w.width
+	}
+}
+
+// terminalWidth is the width of the terminal on stdout, then $COLUMNS, then 80 columns
+func terminalWidth() int {
+	if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && width > 0 {
+		return width
+	}
+
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+
+	return defaultTerminalWidth
+}
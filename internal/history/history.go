@@ -0,0 +1,225 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flacial/llm/pkg/llm"
+)
+
+// ErrNoConversations is returned when the history directory has no saved conversation yet
+var ErrNoConversations = errors.New("no saved conversations")
+
+// ErrNoLastRequest is returned when no request has been sent yet to repeat
+var ErrNoLastRequest = errors.New("no previous request to repeat")
+
+// lastRequestFile sits next to the conversations but isn't one, so listing skips it and its name
+// can't be used as a conversation ID
+const lastRequestFile = "last.json"
+
+// LastRequest is the latest request sent, parameters and all, kept so it can be sent again as is
+type LastRequest struct {
+	llm.ChatCompletionRequest
+	SentAt time.Time `json:"sent_at"`
+}
+
+type Conversation struct {
+	ID        string                      `json:"id"`
+	Model     string                      `json:"model"`
+	CreatedAt time.Time                   `json:"created_at"`
+	UpdatedAt time.Time                   `json:"updated_at"`
+	Messages  []llm.ChatCompletionMessage `json:"messages"`
+}
+
+func NewConversation() *Conversation {
+	now := time.Now()
+
+	return &Conversation{
+		// Sortable and readable enough to type when resuming a session
+		ID:        now.Format("20060102-150405.000"),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func conversationPath(dir, id string) string {
+	return filepath.Join(dir, id+".json")
+}
+
+// validateID keeps IDs typed on the command line from pointing outside the history directory or at
+// the last request
+func validateID(id string) error {
+	if id == "" || strings.ContainsAny(id, `/\`) || id == "." || id == ".." || id+".json" == lastRequestFile {
+		return fmt.Errorf("invalid conversation ID %q", id)
+	}
+
+	return nil
+}
+
+func Load(dir, id string) (*Conversation, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(conversationPath(dir, id))
+	if err != nil {
+		return nil, fmt.Errorf("error reading conversation %q: %w", id, err)
+	}
+
+	var conversation Conversation
+	if err := json.Unmarshal(data, &conversation); err != nil {
+		return nil, fmt.Errorf("error decoding conversation %q: %w", id, err)
+	}
+
+	return &conversation, nil
+}
+
+// List returns every conversation in dir, most recently updated first
+func List(dir string) ([]*Conversation, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading history directory: %w", err)
+	}
+
+	var conversations []*Conversation
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") || entry.Name() == lastRequestFile {
+			continue
+		}
+
+		conversation, err := Load(dir, strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			// A single corrupted file shouldn't hide the rest of the history
+			continue
+		}
+
+		conversations = append(conversations, conversation)
+	}
+
+	sort.SliceStable(conversations, func(i, j int) bool {
+		return conversations[i].UpdatedAt.After(conversations[j].UpdatedAt)
+	})
+
+	return conversations, nil
+}
+
+// Latest returns the most recently updated conversation in dir
+func Latest(dir string) (*Conversation, error) {
+	conversations, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(conversations) == 0 {
+		return nil, ErrNoConversations
+	}
+
+	return conversations[0], nil
+}
+
+func Delete(dir, id string) error {
+	if err := validateID(id); err != nil {
+		return err
+	}
+
+	if err := os.Remove(conversationPath(dir, id)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("conversation %q not found", id)
+		}
+		return fmt.Errorf("error deleting conversation %q: %w", id, err)
+	}
+
+	return nil
+}
+
+// Clear deletes every saved conversation in dir, and the last request with them, and returns how
+// many conversations there were
+func Clear(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error reading history directory: %w", err)
+	}
+
+	deleted := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return deleted, fmt.Errorf("error deleting %q: %w", entry.Name(), err)
+		}
+		if entry.Name() != lastRequestFile {
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func Save(dir string, conversation *Conversation) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating history directory: %w", err)
+	}
+
+	conversation.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(conversation, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding conversation: %w", err)
+	}
+
+	if err := os.WriteFile(conversationPath(dir, conversation.ID), data, 0644); err != nil {
+		return fmt.Errorf("error writing conversation %q: %w", conversation.ID, err)
+	}
+
+	return nil
+}
+
+func SaveLastRequest(dir string, request *LastRequest) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating history directory: %w", err)
+	}
+
+	request.SentAt = time.Now()
+
+	data, err := json.MarshalIndent(request, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding last request: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, lastRequestFile), data, 0644); err != nil {
+		return fmt.Errorf("error writing last request: %w", err)
+	}
+
+	return nil
+}
+
+func LoadLastRequest(dir string) (*LastRequest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, lastRequestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoLastRequest
+		}
+		return nil, fmt.Errorf("error reading last request: %w", err)
+	}
+
+	var request LastRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, fmt.Errorf("error decoding last request: %w", err)
+	}
+
+	return &request, nil
+}
@@ -0,0 +1,152 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flacial/llm/pkg/llm"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+
+	conversation := NewConversation()
+	conversation.Model = "openai/gpt-4o"
+	conversation.Messages = []llm.ChatCompletionMessage{
+		{Role: "user", Content: "Hi"},
+		{Role: "assistant", Content: "Hello!"},
+	}
+
+	// The directory doesn't exist yet, Save creates it
+	if err := Save(dir, conversation); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	loaded, err := Load(dir, conversation.ID)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+
+	if loaded.Model != conversation.Model || len(loaded.Messages) != 2 || loaded.Messages[1].Content != "Hello!" {
+		t.Errorf("expected the saved conversation back, but got %+v", loaded)
+	}
+	if loaded.UpdatedAt.IsZero() {
+		t.Error("expected Save to set UpdatedAt")
+	}
+}
+
+func TestLoadRejectsPaths(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, id := range []string{"", ".", "..", "../secrets", `a\b`, "last"} {
+		if _, err := Load(dir, id); err == nil || !strings.Contains(err.Error(), "invalid conversation ID") {
+			t.Errorf("expected ID %q to be rejected, but got %v", id, err)
+		}
+	}
+}
+
+func TestListAndLatest(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := Latest(dir); !errors.Is(err, ErrNoConversations) {
+		t.Errorf("expected ErrNoConversations for an empty directory, but got %v", err)
+	}
+	if conversations, err := List(filepath.Join(dir, "missing")); err != nil || len(conversations) != 0 {
+		t.Errorf("expected nothing for a missing directory, but got %v, %v", conversations, err)
+	}
+
+	older := &Conversation{ID: "older"}
+	newer := &Conversation{ID: "newer"}
+	for _, conversation := range []*Conversation{older, newer} {
+		if err := Save(dir, conversation); err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+		// UpdatedAt has to differ for the order to be stable
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Neither of these is a conversation, so neither may show up or hide the others
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveLastRequest(dir, &LastRequest{ChatCompletionRequest: llm.ChatCompletionRequest{Model: "openai/gpt-4o"}}); err != nil {
+		t.Fatalf("failed to save the last request: %v", err)
+	}
+
+	conversations, err := List(dir)
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if len(conversations) != 2 || conversations[0].ID != "newer" || conversations[1].ID != "older" {
+		t.Errorf("expected newer then older, but got %d conversations", len(conversations))
+	}
+
+	latest, err := Latest(dir)
+	if err != nil || latest.ID != "newer" {
+		t.Errorf("expected the newer conversation, but got %v, %v", latest, err)
+	}
+}
+
+func TestDeleteAndClear(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, id := range []string{"one", "two"} {
+		if err := Save(dir, &Conversation{ID: id}); err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+	}
+	if err := SaveLastRequest(dir, &LastRequest{ChatCompletionRequest: llm.ChatCompletionRequest{Model: "openai/gpt-4o"}}); err != nil {
+		t.Fatalf("failed to save the last request: %v", err)
+	}
+
+	if err := Delete(dir, "one"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if err := Delete(dir, "one"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected deleting twice to fail, but got %v", err)
+	}
+
+	deleted, err := Clear(dir)
+	if err != nil || deleted != 1 {
+		t.Errorf("expected 1 conversation cleared, but got %d, %v", deleted, err)
+	}
+	if _, err := LoadLastRequest(dir); !errors.Is(err, ErrNoLastRequest) {
+		t.Errorf("expected Clear to remove the last request too, but got %v", err)
+	}
+}
+
+func TestLastRequest(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := LoadLastRequest(dir); !errors.Is(err, ErrNoLastRequest) {
+		t.Errorf("expected ErrNoLastRequest, but got %v", err)
+	}
+
+	temperature := 0.4
+	maxTokens := 100
+	request := &LastRequest{ChatCompletionRequest: llm.ChatCompletionRequest{
+		Model:       "openai/gpt-4o",
+		Temperature: &temperature,
+		MaxTokens:   &maxTokens,
+		Stop:        []string{"END"},
+		Messages:    []llm.ChatCompletionMessage{{Role: "user", Content: "Tell me a joke."}},
+	}}
+	if err := SaveLastRequest(dir, request); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	loaded, err := LoadLastRequest(dir)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if loaded.Model != request.Model || loaded.Temperature == nil || *loaded.Temperature != 0.4 || loaded.Messages[0].Content != "Tell me a joke." {
+		t.Errorf("expected the saved request back, but got %+v", loaded)
+	}
+	if loaded.MaxTokens == nil || *loaded.MaxTokens != 100 || len(loaded.Stop) != 1 || loaded.SentAt.IsZero() {
+		t.Errorf("expected the saved request back, but got %+v", loaded)
+	}
+}
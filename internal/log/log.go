@@ -8,19 +8,39 @@ import (
 	"github.com/mattn/go-isatty"
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var Logger zerolog.Logger
 
-func InitLggger(verbose, debug bool, logFile string) {
+// Log file formats accepted by InitLggger
+const (
+	// zerolog's native JSON lines, one object per entry
+	FormatJSON = "json"
+	// The same human-readable lines the console shows, without colors
+	FormatConsole = "console"
+)
+
+// Rotation limits how much disk the log file takes. Zero values fall back to lumberjack's
+// defaults: 100MB per file, every old file kept, forever
+type Rotation struct {
+	// Size in megabytes at which the file is rotated
+	MaxSizeMB int
+	// How many rotated files to keep
+	MaxBackups int
+	// How many days to keep rotated files for
+	MaxAgeDays int
+}
+
+func InitLggger(verbose, debug, quiet, noColor bool, logFile string, fileFormat string, rotation Rotation) {
 	// Default level is info
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 
 	consoleWriter := zerolog.ConsoleWriter{
 		Out:        os.Stderr,
 		TimeFormat: zerolog.TimeFormatUnix,
-		// Disable color for non-TTY
-		NoColor: !isatty.IsTerminal(os.Stderr.Fd()),
+		// Disable color when asked to and for non-TTY
+		NoColor: noColor || !isatty.IsTerminal(os.Stderr.Fd()),
 	}
 
 	var fileWriter io.Writer = io.Discard // Default to discard
@@ -50,17 +70,25 @@ func InitLggger(verbose, debug bool, logFile string) {
 		if err := os.MkdirAll(filepath.Dir(finalLogPath), 0755); err != nil {
 			zlog.Err(err).Str("path", finalLogPath).Msg("Failed to create log directory.")
 		} else {
-			logFileHandle, err := os.OpenFile(finalLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				zlog.Err(err).Str("path", finalLogPath).Msg("Failed to open log file.")
-			} else {
-				fileWriter = logFileHandle
+			// Rolled over files are kept next to it as llm-<timestamp>.log
+			logFileHandle := &lumberjack.Logger{
+				Filename:   finalLogPath,
+				MaxSize:    rotation.MaxSizeMB,
+				MaxBackups: rotation.MaxBackups,
+				MaxAge:     rotation.MaxAgeDays,
+			}
+
+			fileWriter = logFileHandle
+			if fileFormat == FormatConsole {
+				fileWriter = zerolog.ConsoleWriter{Out: logFileHandle, TimeFormat: zerolog.TimeFormatUnix, NoColor: true}
 			}
 		}
 	}
 
-	writers := io.MultiWriter(consoleWriter, fileWriter)
-	Logger = zerolog.New(writers).With().Timestamp().Logger()
+	// Each writer gets the raw JSON entry, so the console formats it for people while the file
+	// keeps it as is unless fileFormat asks otherwise
+	writers := zerolog.MultiLevelWriter(consoleWriter, fileWriter)
+	Logger = zerolog.New(redactingWriter{writers}).With().Timestamp().Logger()
 
 	switch {
 	case debug:
@@ -69,6 +97,10 @@ func InitLggger(verbose, debug bool, logFile string) {
 	case verbose:
 		Logger = Logger.Level(zerolog.InfoLevel)
 		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	// Asking for more detail is a stronger signal than asking for less, so quiet comes last
+	case quiet:
+		Logger = Logger.Level(zerolog.ErrorLevel)
+		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
 	default:
 		Logger = Logger.Level(zerolog.WarnLevel)
 		zerolog.SetGlobalLevel(zerolog.WarnLevel)
@@ -76,6 +108,10 @@ func InitLggger(verbose, debug bool, logFile string) {
 
 	zlog.Logger = Logger
 
+	if fileFormat != "" && fileFormat != FormatJSON && fileFormat != FormatConsole {
+		Logger.Warn().Str("format", fileFormat).Msg("Unknown log.format, expected json or console. Using json.")
+	}
+
 	if finalLogPath != "" {
 		Logger.Debug().Str("log_file_path", finalLogPath).Msg("Logger initialized.")
 	} else {
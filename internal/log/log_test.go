@@ -0,0 +1,101 @@
+package log
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLogFileFormat(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		logPath := filepath.Join(t.TempDir(), "llm.log")
+		InitLggger(false, false, false, false, logPath, FormatJSON, Rotation{})
+		Logger.Warn().Str("model", "openai/gpt-4o").Msg("Something odd.")
+
+		written, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatalf("failed to read log file: %v", err)
+		}
+
+		var entry map[string]any
+		if err := json.Unmarshal(written, &entry); err != nil {
+			t.Fatalf("expected a JSON line, but got %q: %v", written, err)
+		}
+
+		if entry["model"] != "openai/gpt-4o" || entry["message"] != "Something odd." {
+			t.Errorf("expected the fields to be kept, but got %v", entry)
+		}
+	})
+
+	t.Run("console", func(t *testing.T) {
+		logPath := filepath.Join(t.TempDir(), "llm.log")
+		InitLggger(false, false, false, false, logPath, FormatConsole, Rotation{})
+		Logger.Warn().Str("model", "openai/gpt-4o").Msg("Something odd.")
+
+		written, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatalf("failed to read log file: %v", err)
+		}
+
+		if json.Valid(written) || !strings.Contains(string(written), "model=openai/gpt-4o") {
+			t.Errorf("expected a human-readable line, but got %q", written)
+		}
+	})
+}
+
+func TestLogFileRotation(t *testing.T) {
+	logDir := t.TempDir()
+	logPath := filepath.Join(logDir, "llm.log")
+	InitLggger(false, false, false, false, logPath, FormatJSON, Rotation{MaxSizeMB: 1, MaxBackups: 1})
+
+	// Comfortably past 1MB, which is the smallest size lumberjack rotates at
+	padding := strings.Repeat("x", 1024)
+	for range 1500 {
+		Logger.Warn().Str("padding", padding).Msg("Filling the log.")
+	}
+
+	entries, err := os.ReadDir(logDir)
+	if err != nil {
+		t.Fatalf("failed to read log dir: %v", err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected the log and one backup, but got %d files", len(entries))
+	}
+
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatalf("failed to stat log file: %v", err)
+	}
+
+	if info.Size() > 1024*1024 {
+		t.Errorf("expected the current log to stay under 1MB, but it's %d bytes", info.Size())
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	cases := []struct {
+		name                  string
+		verbose, debug, quiet bool
+		expected              zerolog.Level
+	}{
+		{"default", false, false, false, zerolog.WarnLevel},
+		{"quiet", false, false, true, zerolog.ErrorLevel},
+		{"debug wins over quiet", false, true, true, zerolog.DebugLevel},
+		{"verbose wins over quiet", true, false, true, zerolog.InfoLevel},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			InitLggger(c.verbose, c.debug, c.quiet, false, filepath.Join(t.TempDir(), "llm.log"), FormatJSON, Rotation{})
+
+			if Logger.GetLevel() != c.expected {
+				t.Errorf("expected %s, but got %s", c.expected, Logger.GetLevel())
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/flacial/llm/internal/utils"
+)
+
+var (
+	secretsMu sync.RWMutex
+	secrets   [][]byte
+)
+
+// RegisterSecret makes sure secret never reaches the logs in full, wherever it turns up (a
+// request, an Authorization header, an error body echoing it back). Only its last 4 characters
+// are kept, like `llm config get` shows it
+func RegisterSecret(secret string) {
+	// Masking something this short would hide ordinary words all over the logs
+	if len(secret) < 8 {
+		return
+	}
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	for _, registered := range secrets {
+		if string(registered) == secret {
+			return
+		}
+	}
+	secrets = append(secrets, []byte(secret))
+}
+
+// redactingWriter masks the registered secrets in every log entry before passing it on
+type redactingWriter struct {
+	out io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	secretsMu.RLock()
+	redacted := p
+	for _, secret := range secrets {
+		redacted = bytes.ReplaceAll(redacted, secret, []byte(utils.MaskSecret(string(secret))))
+	}
+	secretsMu.RUnlock()
+
+	if _, err := w.out.Write(redacted); err != nil {
+		return 0, err
+	}
+
+	// zerolog treats a short count as a failed write, even though the shorter entry went out whole
+	return len(p), nil
+}
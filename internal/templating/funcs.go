@@ -0,0 +1,64 @@
+package templating
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/flacial/llm/internal/utils"
+)
+
+// SystemAccessKey is the config key that enables the env and include functions
+const SystemAccessKey = "templates.allow_system_access"
+
+// defaultDateLayout is what {{date}} prints without a layout, e.g. 2025-06-01
+const defaultDateLayout = "2006-01-02"
+
+// now is a variable so tests get a fixed date
+var now = time.Now
+
+// funcs are the functions a user_prompt_template can call besides the text/template built-ins
+func (t *Template) funcs() template.FuncMap {
+	return template.FuncMap{
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"trim":  strings.TrimSpace,
+		// {{date}} or {{date "Jan 2, 2006"}}, in Go's reference time layout
+		"date": func(layout ...string) (string, error) {
+			switch len(layout) {
+			case 0:
+				return now().Format(defaultDateLayout), nil
+			case 1:
+				return now().Format(layout[0]), nil
+			}
+			return "", fmt.Errorf("date takes at most one layout, got %d", len(layout))
+		},
+		"env": func(name string) (string, error) {
+			if err := t.checkSystemAccess("env"); err != nil {
+				return "", err
+			}
+			return os.Getenv(name), nil
+		},
+		"include": func(path string) (string, error) {
+			if err := t.checkSystemAccess("include"); err != nil {
+				return "", err
+			}
+
+			content, err := os.ReadFile(utils.ExpandPath(path))
+			if err != nil {
+				return "", fmt.Errorf("error including %q: %w", path, err)
+			}
+			return string(content), nil
+		},
+	}
+}
+
+func (t *Template) checkSystemAccess(function string) error {
+	if t.AllowSystemAccess {
+		return nil
+	}
+
+	return fmt.Errorf("%s reads outside the prompt and is off by default, set %s: true in the config to allow it", function, SystemAccessKey)
+}
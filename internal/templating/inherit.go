@@ -0,0 +1,113 @@
+package templating
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Loader finds a template by name as written, before its extends and includes are applied
+type Loader func(name string) (*Template, error)
+
+// Resolve loads the template called name through load and applies its extends chain and
+// includes. Parents are resolved first, so each template only overrides what it sets itself
+func Resolve(name string, load Loader) (*Template, error) {
+	tmpl, err := resolveExtends(name, load, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tmpl.Includes) > 0 {
+		tmpl.includes = make(map[string]string, len(tmpl.Includes))
+	}
+	// Only the included template's own user_prompt_template is used, so includes don't chain and
+	// can't loop
+	for _, includeName := range tmpl.Includes {
+		included, err := load(includeName)
+		if err != nil {
+			return nil, fmt.Errorf("error including %q in template %q: %w", includeName, name, err)
+		}
+		tmpl.includes[includeName] = included.UserPromptTemplate
+	}
+
+	return tmpl, nil
+}
+
+// resolveExtends loads name and merges in its parents. chain holds the templates that led here,
+// so a template that ends up extending itself is caught instead of recursing forever
+func resolveExtends(name string, load Loader, chain []string) (*Template, error) {
+	chain = append(chain, name)
+	for _, seen := range chain[:len(chain)-1] {
+		if strings.EqualFold(seen, name) {
+			return nil, fmt.Errorf("template inheritance cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	tmpl, err := load(name)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.Extends == "" {
+		return tmpl, nil
+	}
+
+	parent, err := resolveExtends(tmpl.Extends, load, chain)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.inherit(parent)
+
+	return tmpl, nil
+}
+
+// inherit fills in every field t leaves unset from parent. Includes and required variables add
+// up instead, since the parent's prompt may rely on its own
+func (t *Template) inherit(parent *Template) {
+	if t.Description == "" {
+		t.Description = parent.Description
+	}
+	if t.SystemMessage == "" {
+		t.SystemMessage = parent.SystemMessage
+	}
+	if t.UserPromptTemplate == "" {
+		t.UserPromptTemplate = parent.UserPromptTemplate
+	}
+	if t.Model == "" {
+		t.Model = parent.Model
+	}
+	if t.Temperature == nil {
+		t.Temperature = parent.Temperature
+	}
+	if t.MaxTokens == nil {
+		t.MaxTokens = parent.MaxTokens
+	}
+	if t.TopP == nil {
+		t.TopP = parent.TopP
+	}
+	if t.FrequencyPenalty == nil {
+		t.FrequencyPenalty = parent.FrequencyPenalty
+	}
+	if t.PresencePenalty == nil {
+		t.PresencePenalty = parent.PresencePenalty
+	}
+	if len(t.Stop) == 0 {
+		t.Stop = parent.Stop
+	}
+	if t.Seed == nil {
+		t.Seed = parent.Seed
+	}
+	if t.Provider == nil {
+		t.Provider = parent.Provider
+	}
+
+	for _, name := range parent.RequiredVars {
+		if !slices.Contains(t.RequiredVars, name) {
+			t.RequiredVars = append(t.RequiredVars, name)
+		}
+	}
+	for _, include := range parent.Includes {
+		if !slices.Contains(t.Includes, include) {
+			t.Includes = append(t.Includes, include)
+		}
+	}
+}
@@ -2,7 +2,18 @@ package templating
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
 	"text/template"
+	"text/template/parse"
+
+	"github.com/flacial/llm/pkg/llm"
+
+	"gopkg.in/yaml.v3"
 )
 
 type Template struct {
@@ -12,23 +23,307 @@ type Template struct {
 	UserPromptTemplate string   `yaml:"user_prompt_template"`
 	Model              string   `yaml:"model,omitempty"`
 	Temperature        *float64 `yaml:"temperature,omitempty"`
+	MaxTokens          *int     `yaml:"max_tokens,omitempty"`
+	TopP               *float64 `yaml:"top_p,omitempty"`
+	FrequencyPenalty   *float64 `yaml:"frequency_penalty,omitempty"`
+	PresencePenalty    *float64 `yaml:"presence_penalty,omitempty"`
+	Stop               []string `yaml:"stop,omitempty"`
+	Seed               *int     `yaml:"seed,omitempty"`
+	// Variables that must be passed with --var, on top of the ones the prompt uses. Handy when only
+	// an included template or the system message needs them
+	RequiredVars []string `yaml:"required_vars,omitempty"`
+	// OpenRouter provider routing, e.g. to pin a provider for a template
+	Provider *llm.ProviderPreferences `yaml:"provider,omitempty"`
+	// Another template whose fields this one inherits, overriding whichever it sets itself
+	Extends string `yaml:"extends,omitempty"`
+	// Templates whose user_prompt_template is pulled in with {{template "name" .}}
+	Includes []string `yaml:"includes,omitempty"`
+
+	// Lets the env and include functions read the environment and files. It comes from the config
+	// rather than the template, so a template from someone else can't turn it on for itself
+	AllowSystemAccess bool `yaml:"-"`
+
+	// The bodies of Includes by name, filled in by Resolve
+	includes map[string]string
+}
+
+// LoadFile reads and parses a *.tmpl.yaml template file
+func LoadFile(path string) (*Template, error) {
+	templateFileBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template file: %w", err)
+	}
+
+	var tmpl Template
+	if err := yaml.Unmarshal(templateFileBytes, &tmpl); err != nil {
+		return nil, fmt.Errorf("error unmarshalling template file %q, check YAML syntax: %w", path, err)
+	}
+
+	return &tmpl, nil
 }
 
+// inlineTemplate is a template written under templates.inline in the config. Besides the file
+// fields, it takes the shorter system and user keys that suit one-liners
+type inlineTemplate struct {
+	Template `yaml:",inline"`
+	System   string `yaml:"system"`
+	User     string `yaml:"user"`
+}
+
+// ParseInline builds a template from a templates.inline.<name> config entry
+func ParseInline(name string, fields map[string]any) (*Template, error) {
+	// A YAML round trip reuses the file field names and types, pointers and provider included
+	fieldBytes, err := yaml.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding inline template %q: %w", name, err)
+	}
+
+	var inline inlineTemplate
+	if err := yaml.Unmarshal(fieldBytes, &inline); err != nil {
+		return nil, fmt.Errorf("error parsing inline template %q: %w", name, err)
+	}
+
+	tmpl := inline.Template
+	tmpl.Name = name
+	if inline.System != "" {
+		tmpl.SystemMessage = inline.System
+	}
+	if inline.User != "" {
+		tmpl.UserPromptTemplate = inline.User
+	}
+
+	return &tmpl, nil
+}
+
+// PromptShape is the data a user_prompt_template is executed against
 type PromptShape struct {
+	// The arguments, stdin, and prompt file combined, as sent when there's no template
 	UserPrompt string
+	// The prompt arguments and piped stdin on their own, so a template can put them in different places
+	Args  string
+	Stdin string
+	// Values passed with --var key=value, available as {{.Vars.key}}
+	Vars map[string]string
+	// The --attach files, each in a fenced block headed by its path. A template that places them
+	// itself gets them here instead of as messages of their own
+	Attachments string
 }
 
-func (t *Template) ProcessUserPromptTemplate(rawPrompt string) (string, error) {
-	templ, err := template.New("user_prompt").Parse(rawPrompt)
+func (t *Template) ProcessUserPromptTemplate(data PromptShape) (string, error) {
+	missing, err := t.MissingVars(data.Vars)
 	if err != nil {
 		return "", err
 	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing template variables: %s (pass them with --var name=value)", strings.Join(missing, ", "))
+	}
 
-	var buf bytes.Buffer
-	err = templ.Execute(&buf, t)
+	// A template that only sets a system message or model sends the prompt as is
+	if strings.TrimSpace(t.UserPromptTemplate) == "" {
+		return data.UserPrompt, nil
+	}
+
+	if data.Vars == nil {
+		data.Vars = map[string]string{}
+	}
+
+	templ, err := t.parse()
 	if err != nil {
 		return "", err
 	}
 
+	var buf bytes.Buffer
+	err = templ.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("error executing user prompt template (is a --var missing?): %w", err)
+	}
+
 	return buf.String(), nil
 }
+
+// parse compiles the user prompt template. missingkey=error turns a {{.Vars.lang}} without a
+// matching --var into an error instead of a silent "<no value>" in the prompt
+func (t *Template) parse() (*template.Template, error) {
+	templ, err := template.New("user_prompt").Funcs(t.funcs()).Option("missingkey=error").Parse(t.UserPromptTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing user prompt template: %w", err)
+	}
+
+	for name, body := range t.includes {
+		if _, err := templ.New(name).Parse(body); err != nil {
+			return nil, fmt.Errorf("error parsing included template %q: %w", name, err)
+		}
+	}
+
+	return templ, nil
+}
+
+// Validate catches syntax errors and references to data that doesn't exist (e.g. {{.Prompt}}
+// instead of {{.UserPrompt}}) without needing a real prompt. Every {{.Vars.x}} is assumed to be
+// provided since those come from --var at run time
+func (t *Template) Validate() error {
+	if strings.TrimSpace(t.UserPromptTemplate) == "" {
+		return nil
+	}
+
+	templ, err := t.parse()
+	if err != nil {
+		return err
+	}
+
+	sample := PromptShape{UserPrompt: "sample prompt", Args: "sample args", Stdin: "sample stdin", Vars: map[string]string{}, Attachments: "sample attachments"}
+	for _, name := range referencedVars(templ) {
+		sample.Vars[name] = "sample"
+	}
+
+	if err := templ.Execute(io.Discard, sample); err != nil {
+		return fmt.Errorf("user prompt template references undefined data: %w", err)
+	}
+
+	return nil
+}
+
+// ReferencedVars lists the {{.Vars.x}} names the user prompt template uses, sorted
+func (t *Template) ReferencedVars() ([]string, error) {
+	templ, err := t.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return referencedVars(templ), nil
+}
+
+// UsesAttachments reports whether the user prompt template places {{.Attachments}} itself
+func (t *Template) UsesAttachments() (bool, error) {
+	if strings.TrimSpace(t.UserPromptTemplate) == "" {
+		return false, nil
+	}
+
+	templ, err := t.parse()
+	if err != nil {
+		return false, err
+	}
+
+	uses := false
+	walkFields(templ, func(ident []string) {
+		if ident[0] == "Attachments" {
+			uses = true
+		}
+	})
+
+	return uses, nil
+}
+
+// MissingVars lists, sorted, the variables in required_vars or used by the prompt that vars
+// doesn't have. All of them are checked up front, so one run reports every missing --var
+func (t *Template) MissingVars(vars map[string]string) ([]string, error) {
+	needed := slices.Clone(t.RequiredVars)
+	if strings.TrimSpace(t.UserPromptTemplate) != "" {
+		referenced, err := t.ReferencedVars()
+		if err != nil {
+			return nil, err
+		}
+		needed = append(needed, referenced...)
+	}
+
+	var missing []string
+	for _, name := range needed {
+		if _, ok := vars[name]; !ok && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+
+	return missing, nil
+}
+
+func referencedVars(templ *template.Template) []string {
+	seen := map[string]bool{}
+	walkFields(templ, func(ident []string) {
+		if len(ident) >= 2 && ident[0] == "Vars" {
+			seen[ident[1]] = true
+		}
+	})
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// walkFields calls visit with the path of every field of the data the template reads, e.g.
+// ["Vars", "lang"] for {{.Vars.lang}}, included templates and $ inside range and with too
+func walkFields(templ *template.Template, visit func(ident []string)) {
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(&n.BranchNode)
+		case *parse.RangeNode:
+			walk(&n.BranchNode)
+		case *parse.WithNode:
+			walk(&n.BranchNode)
+		case *parse.BranchNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, command := range n.Cmds {
+				walk(command)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.FieldNode:
+			visit(n.Ident)
+		case *parse.VariableNode:
+			// $.Vars.x reaches the same data from inside range/with blocks
+			if len(n.Ident) >= 2 && n.Ident[0] == "$" {
+				visit(n.Ident[1:])
+			}
+		}
+	}
+
+	for _, associated := range templ.Templates() {
+		if associated.Tree != nil {
+			walk(associated.Tree.Root)
+		}
+	}
+}
+
+// ParseVars turns repeated key=value flag values into a map. Later occurrences of a key win
+func ParseVars(rawVars []string) (map[string]string, error) {
+	vars := make(map[string]string, len(rawVars))
+
+	for _, rawVar := range rawVars {
+		key, value, found := strings.Cut(rawVar, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid variable %q: expected key=value", rawVar)
+		}
+
+		vars[key] = value
+	}
+
+	return vars, nil
+}
@@ -0,0 +1,429 @@
+package templating
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessUserPromptTemplate(t *testing.T) {
+	t.Run("user prompt", func(t *testing.T) {
+		tmpl := Template{UserPromptTemplate: "Summarize this:\n\n{{.UserPrompt}}"}
+
+		output, err := tmpl.ProcessUserPromptTemplate(PromptShape{UserPrompt: "a long text"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expected := "Summarize this:\n\na long text"
+		if output != expected {
+			t.Errorf("expected %q, but got %q", expected, output)
+		}
+	})
+
+	t.Run("user prompt isn't parsed as a template", func(t *testing.T) {
+		tmpl := Template{UserPromptTemplate: "{{.UserPrompt}}"}
+
+		output, err := tmpl.ProcessUserPromptTemplate(PromptShape{UserPrompt: "What does {{.Name}} do in Go templates?"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if output != "What does {{.Name}} do in Go templates?" {
+			t.Errorf("expected the prompt to be passed through verbatim, but got %q", output)
+		}
+	})
+
+	t.Run("named variables", func(t *testing.T) {
+		tmpl := Template{UserPromptTemplate: "Translate to {{.Vars.lang}}: {{.UserPrompt}}"}
+
+		output, err := tmpl.ProcessUserPromptTemplate(PromptShape{
+			UserPrompt: "good morning",
+			Vars:       map[string]string{"lang": "French"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expected := "Translate to French: good morning"
+		if output != expected {
+			t.Errorf("expected %q, but got %q", expected, output)
+		}
+	})
+
+	t.Run("undefined variable", func(t *testing.T) {
+		tmpl := Template{UserPromptTemplate: "Translate to {{.Vars.lang}}: {{.UserPrompt}}"}
+
+		_, err := tmpl.ProcessUserPromptTemplate(PromptShape{UserPrompt: "good morning"})
+		if err == nil {
+			t.Fatalf("expected an error for an undefined variable, got nil")
+		}
+
+		if !strings.Contains(err.Error(), "lang") {
+			t.Errorf("expected the error to name the missing variable, got %q", err)
+		}
+	})
+
+	t.Run("args and stdin placed separately", func(t *testing.T) {
+		tmpl := Template{UserPromptTemplate: "{{.Args}}\n---\n{{.Stdin}}"}
+
+		output, err := tmpl.ProcessUserPromptTemplate(PromptShape{
+			UserPrompt: "review this\n\nfunc main() {}",
+			Args:       "review this",
+			Stdin:      "func main() {}",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expected := "review this\n---\nfunc main() {}"
+		if output != expected {
+			t.Errorf("expected %q, but got %q", expected, output)
+		}
+	})
+
+	t.Run("empty user prompt template", func(t *testing.T) {
+		tmpl := Template{SystemMessage: "Be terse."}
+
+		output, err := tmpl.ProcessUserPromptTemplate(PromptShape{UserPrompt: "hello"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if output != "hello" {
+			t.Errorf("expected the raw prompt, but got %q", output)
+		}
+	})
+}
+
+func TestParseVars(t *testing.T) {
+	vars, err := ParseVars([]string{"lang=French", "tone=formal=ish", "lang=German"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if vars["lang"] != "German" || vars["tone"] != "formal=ish" {
+		t.Errorf("unexpected vars: %v", vars)
+	}
+
+	for _, invalid := range []string{"lang", "=French"} {
+		if _, err := ParseVars([]string{invalid}); err == nil {
+			t.Errorf("expected an error for %q, got nil", invalid)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		valid    bool
+	}{
+		{"user prompt", "Summarize: {{.UserPrompt}}", true},
+		{"vars", "Translate to {{.Vars.lang}}: {{.UserPrompt}}", true},
+		{"args and stdin", "{{.Args}}: {{.Stdin}}", true},
+		{"vars inside blocks", "{{with .UserPrompt}}{{$.Vars.tone}} {{.}}{{end}}", true},
+		{"empty", "", true},
+		{"syntax error", "{{.UserPrompt", false},
+		{"unknown field", "Summarize: {{.Prompt}}", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tmpl := Template{UserPromptTemplate: c.template}
+
+			err := tmpl.Validate()
+			if c.valid && err != nil {
+				t.Errorf("expected %q to be valid, got: %v", c.template, err)
+			}
+			if !c.valid && err == nil {
+				t.Errorf("expected %q to be invalid, got nil", c.template)
+			}
+		})
+	}
+}
+
+func TestReferencedVars(t *testing.T) {
+	tmpl := Template{UserPromptTemplate: "{{.Vars.lang}} {{if .Vars.tone}}{{.Vars.tone}}{{end}} {{range .Vars}}{{$.Vars.audience}}{{end}}"}
+
+	vars, err := tmpl.ReferencedVars()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "audience,lang,tone"
+	if strings.Join(vars, ",") != expected {
+		t.Errorf("expected %q, but got %q", expected, vars)
+	}
+}
+
+func TestUsesAttachments(t *testing.T) {
+	tests := map[string]bool{
+		"{{.UserPrompt}}":                        false,
+		"":                                       false,
+		"{{.UserPrompt}}\n{{.Attachments}}":      true,
+		"{{with .Vars}}{{$.Attachments}}{{end}}": true,
+	}
+
+	for prompt, expected := range tests {
+		uses, err := (&Template{UserPromptTemplate: prompt}).UsesAttachments()
+		if err != nil || uses != expected {
+			t.Errorf("%q: expected %v, but got %v, %v", prompt, expected, uses, err)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "json.tmpl.yaml")
+	content := `name: json
+user_prompt_template: "{{.UserPrompt}}"
+seed: 42
+stop:
+  - "</json>"
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+
+	tmpl, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if tmpl.Seed == nil || *tmpl.Seed != 42 {
+		t.Errorf("expected seed 42, but got %v", tmpl.Seed)
+	}
+
+	if len(tmpl.Stop) != 1 || tmpl.Stop[0] != "</json>" {
+		t.Errorf("expected stop [</json>], but got %q", tmpl.Stop)
+	}
+}
+
+func TestParseInline(t *testing.T) {
+	tmpl, err := ParseInline("terse", map[string]any{
+		"system":      "Answer in one sentence.",
+		"user":        "Explain {{.UserPrompt}}",
+		"model":       "openai/gpt-4o",
+		"temperature": 0.2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if tmpl.Name != "terse" || tmpl.SystemMessage != "Answer in one sentence." || tmpl.UserPromptTemplate != "Explain {{.UserPrompt}}" {
+		t.Errorf("expected the short keys to fill the template, but got %+v", tmpl)
+	}
+
+	if tmpl.Model != "openai/gpt-4o" || tmpl.Temperature == nil || *tmpl.Temperature != 0.2 {
+		t.Errorf("expected the model and temperature to carry over, but got %+v", tmpl)
+	}
+
+	t.Run("file field names", func(t *testing.T) {
+		tmpl, err := ParseInline("long", map[string]any{"system_message": "Be brief."})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if tmpl.SystemMessage != "Be brief." {
+			t.Errorf("expected %q, but got %q", "Be brief.", tmpl.SystemMessage)
+		}
+	})
+}
+
+func TestResolve(t *testing.T) {
+	temperature := 0.2
+	templates := map[string]*Template{
+		"base":     {Description: "Base", SystemMessage: "You are a senior engineer.", UserPromptTemplate: "{{.UserPrompt}}", Model: "openai/gpt-4o", Temperature: &temperature},
+		"reviewer": {Extends: "base", UserPromptTemplate: "Review this:\n{{.UserPrompt}}"},
+		"go":       {Extends: "reviewer", Model: "anthropic/claude-sonnet-4", Includes: []string{"rules"}, UserPromptTemplate: `{{template "rules" .}}{{.UserPrompt}}`},
+		"rules":    {UserPromptTemplate: "Follow {{.Vars.style}}. "},
+		"loop-a":   {Extends: "loop-b"},
+		"loop-b":   {Extends: "loop-a"},
+		"self":     {Extends: "self"},
+		"orphan":   {Extends: "missing"},
+	}
+	// A fresh copy each time, as reading the file again would give
+	load := func(name string) (*Template, error) {
+		tmpl, ok := templates[name]
+		if !ok {
+			return nil, fmt.Errorf("template %q not found", name)
+		}
+		copied := *tmpl
+		return &copied, nil
+	}
+
+	t.Run("single inheritance", func(t *testing.T) {
+		tmpl, err := Resolve("reviewer", load)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if tmpl.SystemMessage != "You are a senior engineer." || tmpl.Model != "openai/gpt-4o" || tmpl.Temperature == nil || *tmpl.Temperature != 0.2 {
+			t.Errorf("expected the parent's fields to be inherited, but got %+v", tmpl)
+		}
+		if tmpl.UserPromptTemplate != "Review this:\n{{.UserPrompt}}" {
+			t.Errorf("expected the child's prompt to override the parent's, but got %q", tmpl.UserPromptTemplate)
+		}
+	})
+
+	t.Run("chained inheritance and includes", func(t *testing.T) {
+		tmpl, err := Resolve("go", load)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if tmpl.SystemMessage != "You are a senior engineer." || tmpl.Description != "Base" {
+			t.Errorf("expected fields from the grandparent, but got %+v", tmpl)
+		}
+		if tmpl.Model != "anthropic/claude-sonnet-4" {
+			t.Errorf("expected %q, but got %q", "anthropic/claude-sonnet-4", tmpl.Model)
+		}
+
+		output, err := tmpl.ProcessUserPromptTemplate(PromptShape{UserPrompt: "func main() {}", Vars: map[string]string{"style": "Effective Go"}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := "Follow Effective Go. func main() {}"; output != expected {
+			t.Errorf("expected %q, but got %q", expected, output)
+		}
+
+		if err := tmpl.Validate(); err != nil {
+			t.Errorf("expected variables in includes to be assumed provided, but got %v", err)
+		}
+	})
+
+	t.Run("cycles", func(t *testing.T) {
+		for name, expected := range map[string]string{
+			"loop-a": "loop-a -> loop-b -> loop-a",
+			"self":   "self -> self",
+		} {
+			_, err := Resolve(name, load)
+			if err == nil || !strings.Contains(err.Error(), expected) {
+				t.Errorf("expected a cycle error naming %q, but got %v", expected, err)
+			}
+		}
+	})
+
+	t.Run("missing parent", func(t *testing.T) {
+		if _, err := Resolve("orphan", load); err == nil || !strings.Contains(err.Error(), `"missing"`) {
+			t.Errorf("expected an error naming the missing parent, but got %v", err)
+		}
+	})
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	originalNow := now
+	defer func() { now = originalNow }()
+	now = func() time.Time { return time.Date(2025, time.June, 1, 14, 30, 0, 0, time.UTC) }
+
+	t.Setenv("LLM_TEMPLATE_TEST", "from the environment")
+	notesPath := filepath.Join(t.TempDir(), "notes.md")
+	if err := os.WriteFile(notesPath, []byte("shared notes"), 0644); err != nil {
+		t.Fatalf("failed to write notes: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"upper", "{{.UserPrompt | upper}}", "HELLO THERE"},
+		{"lower", "{{lower .UserPrompt}}", "hello there"},
+		{"trim", "[{{trim .Stdin}}]", "[padded]"},
+		{"date", "{{date}}", "2025-06-01"},
+		{"date with a layout", `{{date "Jan 2, 2006 15:04"}}`, "Jun 1, 2025 14:30"},
+		{"env", `{{env "LLM_TEMPLATE_TEST"}}`, "from the environment"},
+		{"include", `{{include "` + notesPath + `"}}`, "shared notes"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl := Template{UserPromptTemplate: test.template, AllowSystemAccess: true}
+
+			output, err := tmpl.ProcessUserPromptTemplate(PromptShape{UserPrompt: "Hello There", Stdin: "  padded\n"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output != test.expected {
+				t.Errorf("expected %q, but got %q", test.expected, output)
+			}
+		})
+	}
+
+	t.Run("env and include need system access", func(t *testing.T) {
+		for _, body := range []string{`{{env "HOME"}}`, `{{include "` + notesPath + `"}}`} {
+			tmpl := Template{UserPromptTemplate: body}
+
+			_, err := tmpl.ProcessUserPromptTemplate(PromptShape{})
+			if err == nil || !strings.Contains(err.Error(), SystemAccessKey) {
+				t.Errorf("expected %s to be refused without %s, but got %v", body, SystemAccessKey, err)
+			}
+		}
+	})
+
+	t.Run("missing include", func(t *testing.T) {
+		tmpl := Template{UserPromptTemplate: `{{include "does-not-exist.md"}}`, AllowSystemAccess: true}
+
+		if _, err := tmpl.ProcessUserPromptTemplate(PromptShape{}); err == nil {
+			t.Error("expected an error for a missing file")
+		}
+	})
+}
+
+func TestMissingVars(t *testing.T) {
+	t.Run("every missing variable is reported before executing", func(t *testing.T) {
+		tmpl := Template{
+			UserPromptTemplate: "Translate to {{.Vars.lang}} in a {{.Vars.tone}} tone: {{.UserPrompt}}",
+			RequiredVars:       []string{"audience", "lang"},
+		}
+
+		_, err := tmpl.ProcessUserPromptTemplate(PromptShape{UserPrompt: "hi", Vars: map[string]string{"tone": "formal"}})
+		if err == nil {
+			t.Fatal("expected an error for the missing variables")
+		}
+
+		expected := "missing template variables: audience, lang (pass them with --var name=value)"
+		if err.Error() != expected {
+			t.Errorf("expected %q, but got %q", expected, err.Error())
+		}
+	})
+
+	t.Run("required without a user prompt template", func(t *testing.T) {
+		tmpl := Template{SystemMessage: "Stay in character.", RequiredVars: []string{"persona"}}
+
+		if _, err := tmpl.ProcessUserPromptTemplate(PromptShape{UserPrompt: "hi"}); err == nil {
+			t.Error("expected required_vars to be checked even without a user prompt template")
+		}
+
+		output, err := tmpl.ProcessUserPromptTemplate(PromptShape{UserPrompt: "hi", Vars: map[string]string{"persona": "a pirate"}})
+		if err != nil || output != "hi" {
+			t.Errorf("expected the prompt as is, but got %q, %v", output, err)
+		}
+	})
+
+	t.Run("empty values count as passed", func(t *testing.T) {
+		tmpl := Template{UserPromptTemplate: "[{{.Vars.note}}]", RequiredVars: []string{"note"}}
+
+		output, err := tmpl.ProcessUserPromptTemplate(PromptShape{Vars: map[string]string{"note": ""}})
+		if err != nil || output != "[]" {
+			t.Errorf("expected %q, but got %q, %v", "[]", output, err)
+		}
+	})
+
+	t.Run("inherited", func(t *testing.T) {
+		child := Template{RequiredVars: []string{"lang"}}
+		child.inherit(&Template{RequiredVars: []string{"tone", "lang"}})
+
+		missing, err := child.MissingVars(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(missing, []string{"lang", "tone"}) {
+			t.Errorf("expected %q, but got %q", []string{"lang", "tone"}, missing)
+		}
+	})
+}
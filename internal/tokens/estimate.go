@@ -0,0 +1,73 @@
+package tokens
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Average characters per token of English-heavy text for the tokenizer families OpenRouter
+// reports in a model's architecture. Anything unlisted uses defaultCharsPerToken
+var charsPerToken = map[string]float64{
+	"GPT":      4.0,
+	"Claude":   3.5,
+	"Gemini":   4.0,
+	"Llama2":   3.5,
+	"Llama3":   4.0,
+	"Llama4":   4.0,
+	"Mistral":  3.5,
+	"Qwen":     3.8,
+	"Qwen3":    3.8,
+	"DeepSeek": 3.8,
+}
+
+const defaultCharsPerToken = 3.8
+
+// Overhead of the role markers and separators providers wrap every chat message in
+const MessageOverhead = 4
+
+// Estimate approximates how many tokens text is for the given tokenizer family. It's no
+// replacement for the real tokenizer, but lands close enough to tell whether a prompt fits a
+// context window. Text outside ASCII (CJK, emoji, ...) tends to be about a token per character
+func Estimate(text, tokenizer string) int {
+	if text == "" {
+		return 0
+	}
+
+	ratio, found := charsPerToken[tokenizer]
+	if !found {
+		ratio = defaultCharsPerToken
+	}
+
+	asciiChars, otherChars, words := 0, 0, 0
+	inWord := false
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			asciiChars++
+		} else {
+			otherChars++
+		}
+
+		if unicode.IsSpace(r) {
+			inWord = false
+		} else if !inWord {
+			inWord = true
+			words++
+		}
+	}
+
+	byChars := float64(asciiChars)/ratio + float64(otherChars)
+	// Very short words and lots of punctuation push the count up, so never go below a token per word
+	return int(math.Ceil(math.Max(byChars, float64(words))))
+}
+
+// EstimateMessages sums Estimate over chat messages plus the per-message overhead
+func EstimateMessages(contents []string, tokenizer string) int {
+	total := 0
+	for _, content := range contents {
+		total += Estimate(strings.TrimSpace(content), tokenizer) + MessageOverhead
+	}
+
+	return total
+}
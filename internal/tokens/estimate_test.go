@@ -0,0 +1,34 @@
+package tokens
+
+import "testing"
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		tokenizer string
+		expected  int
+	}{
+		{"empty", "", "GPT", 0},
+		{"by characters", "abcdefghijklmnop", "GPT", 4},
+		{"unknown tokenizer", "abcdefghijklmnopqrs", "", 5},
+		{"at least a token per word", "a b c d e f", "GPT", 6},
+		{"non-ascii is a token per character", "こんにちは", "GPT", 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Estimate(test.text, test.tokenizer); got != test.expected {
+				t.Errorf("expected %d, but got %d", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestEstimateMessages(t *testing.T) {
+	got := EstimateMessages([]string{"abcdefgh", "abcd"}, "GPT")
+	expected := 2 + 1 + 2*MessageOverhead
+	if got != expected {
+		t.Errorf("expected %d, but got %d", expected, got)
+	}
+}
@@ -0,0 +1,63 @@
+package utils
+
+import "strings"
+
+// ExtractCodeBlocks returns the contents of the fenced code blocks (``` or ~~~) in a markdown
+// document, without the fences or info strings. A block left open at the end, as in a truncated
+// response, still counts
+func ExtractCodeBlocks(markdown string) []string {
+	var blocks []string
+
+	var fence string
+	var current []string
+	inBlock := false
+
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		// More than 3 spaces of indentation makes it an indented code line, not a fence
+		indented := len(line)-len(trimmed) > 3
+
+		if !inBlock {
+			if marker := fenceMarker(trimmed); marker != "" && !indented {
+				fence = marker
+				current = nil
+				inBlock = true
+			}
+			continue
+		}
+
+		// A closing fence uses the same character, is at least as long, and has nothing after it
+		if !indented && strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]+" \t") == "" {
+			blocks = append(blocks, strings.Join(current, "\n"))
+			inBlock = false
+			continue
+		}
+
+		current = append(current, line)
+	}
+
+	if inBlock {
+		blocks = append(blocks, strings.TrimRight(strings.Join(current, "\n"), "\n"))
+	}
+
+	return blocks
+}
+
+// fenceMarker returns the run of backticks or tildes opening a fence, or "" when line doesn't open one
+func fenceMarker(line string) string {
+	if line == "" || (line[0] != '`' && line[0] != '~') {
+		return ""
+	}
+
+	marker := line[:len(line)-len(strings.TrimLeft(line, line[:1]))]
+	if len(marker) < 3 {
+		return ""
+	}
+
+	// Backtick fences can't have backticks in their info string
+	if marker[0] == '`' && strings.Contains(line[len(marker):], "`") {
+		return ""
+	}
+
+	return marker
+}
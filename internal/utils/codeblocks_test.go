@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractCodeBlocks(t *testing.T) {
+	cases := []struct {
+		name     string
+		markdown string
+		expected []string
+	}{
+		{
+			name:     "no blocks",
+			markdown: "Just prose, with `inline code`.",
+			expected: nil,
+		},
+		{
+			name:     "single block with info string",
+			markdown: "Run this:\n\n```bash\nls -la\n```\n\nIt lists files.",
+			expected: []string{"ls -la"},
+		},
+		{
+			name:     "multiple blocks",
+			markdown: "```\necho one\n```\nthen\n~~~sh\necho two\necho three\n~~~",
+			expected: []string{"echo one", "echo two\necho three"},
+		},
+		{
+			name:     "longer fence keeps shorter fences inside",
+			markdown: "````markdown\n```go\nfmt.Println()\n```\n````",
+			expected: []string{"```go\nfmt.Println()\n```"},
+		},
+		{
+			name:     "tilde fence isn't closed by backticks",
+			markdown: "~~~\na\n```\nb\n~~~",
+			expected: []string{"a\n```\nb"},
+		},
+		{
+			name:     "unclosed block at the end",
+			markdown: "```python\nprint('hi')\n",
+			expected: []string{"print('hi')"},
+		},
+		{
+			name:     "empty block",
+			markdown: "```\n```",
+			expected: []string{""},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			output := ExtractCodeBlocks(c.markdown)
+
+			if !reflect.DeepEqual(output, c.expected) {
+				t.Errorf("expected %q, but got %q", c.expected, output)
+			}
+		})
+	}
+}
@@ -1,10 +1,79 @@
 package utils
 
-import "golang.design/x/clipboard"
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
 
-func CopyToClipboard(input string) error {
-	err := clipboard.Init()
+	"golang.design/x/clipboard"
+)
+
+// Clipboard backends accepted by the clipboard.backend config key
+const (
+	ClipboardNative = "native"
+	ClipboardOSC52  = "osc52"
+	ClipboardAuto   = "auto"
+)
+
+var (
+	clipboardInitOnce sync.Once
+	clipboardInitErr  error
+)
+
+// ErrClipboardEmpty means there's no text on the clipboard, or only whitespace
+var ErrClipboardEmpty = errors.New("clipboard is empty")
+
+// readClipboard reads the system clipboard. Tests swap it out, CI machines have no clipboard
+var readClipboard = readNative
+
+// CopyToClipboard copies input with the given backend. auto tries the system clipboard first and
+// falls back to OSC 52, which reaches the local clipboard through the terminal even over SSH
+func CopyToClipboard(input string, backend string) error {
+	switch backend {
+	case ClipboardNative:
+		return copyNative(input)
+	case ClipboardOSC52:
+		return copyOSC52(input)
+	case ClipboardAuto, "":
+		if err := copyNative(input); err != nil {
+			return copyOSC52(input)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown clipboard backend %q, expected %s, %s, or %s", backend, ClipboardNative, ClipboardOSC52, ClipboardAuto)
+	}
+}
+
+// ReadFromClipboard returns the text on the system clipboard. Unlike copying, there's no OSC 52
+// fallback since few terminals allow reading the clipboard that way
+func ReadFromClipboard() (string, error) {
+	content, err := readClipboard()
 	if err != nil {
+		return "", fmt.Errorf("failed to read clipboard: %w", err)
+	}
+
+	if strings.TrimSpace(string(content)) == "" {
+		return "", ErrClipboardEmpty
+	}
+
+	return string(content), nil
+}
+
+// initClipboard runs clipboard.Init once, it's expensive and its result doesn't change within a run
+func initClipboard() error {
+	clipboardInitOnce.Do(func() {
+		clipboardInitErr = clipboard.Init()
+	})
+
+	return clipboardInitErr
+}
+
+func copyNative(input string) error {
+	if err := initClipboard(); err != nil {
 		return err
 	}
 
@@ -12,3 +81,35 @@ func CopyToClipboard(input string) error {
 
 	return nil
 }
+
+func readNative() ([]byte, error) {
+	if err := initClipboard(); err != nil {
+		return nil, err
+	}
+
+	return clipboard.Read(clipboard.FmtText), nil
+}
+
+// copyOSC52 writes the sequence to the controlling terminal rather than stdout, so it works
+// when the output is piped and doesn't end up in redirected files
+func copyOSC52(input string) error {
+	var out io.Writer = os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0); err == nil {
+		defer tty.Close()
+		out = tty
+	}
+
+	_, err := io.WriteString(out, OSC52Sequence(input, os.Getenv("TMUX") != ""))
+	return err
+}
+
+// OSC52Sequence builds the escape sequence that asks the terminal to set its clipboard to input.
+// tmux swallows unknown sequences unless they're wrapped in its passthrough
+func OSC52Sequence(input string, tmux bool) string {
+	sequence := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(input)) + "\a"
+	if !tmux {
+		return sequence
+	}
+
+	return "\x1bPtmux;\x1b" + sequence + "\x1b\\"
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestOSC52Sequence(t *testing.T) {
+	t.Run("plain", func(t *testing.T) {
+		expected := "\x1b]52;c;aGVsbG8=\a"
+		output := OSC52Sequence("hello", false)
+
+		if output != expected {
+			t.Errorf("expected %q, but got %q", expected, output)
+		}
+	})
+
+	t.Run("multibyte", func(t *testing.T) {
+		expected := "\x1b]52;c;w6l0w6kg4pyT\a"
+		output := OSC52Sequence("été ✓", false)
+
+		if output != expected {
+			t.Errorf("expected %q, but got %q", expected, output)
+		}
+	})
+
+	t.Run("tmux passthrough", func(t *testing.T) {
+		expected := "\x1bPtmux;\x1b\x1b]52;c;aGVsbG8=\a\x1b\\"
+		output := OSC52Sequence("hello", true)
+
+		if output != expected {
+			t.Errorf("expected %q, but got %q", expected, output)
+		}
+	})
+}
+
+func TestCopyToClipboardUnknownBackend(t *testing.T) {
+	if err := CopyToClipboard("hello", "xclip"); err == nil {
+		t.Errorf("expected an error for an unknown backend, got nil")
+	}
+}
+
+func TestReadFromClipboard(t *testing.T) {
+	defer func(original func() ([]byte, error)) { readClipboard = original }(readClipboard)
+
+	t.Run("text", func(t *testing.T) {
+		readClipboard = func() ([]byte, error) { return []byte("copied text\n"), nil }
+
+		content, err := ReadFromClipboard()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if content != "copied text\n" {
+			t.Errorf("expected %q, but got %q", "copied text\n", content)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		readClipboard = func() ([]byte, error) { return []byte(" \n"), nil }
+
+		if _, err := ReadFromClipboard(); !errors.Is(err, ErrClipboardEmpty) {
+			t.Errorf("expected ErrClipboardEmpty, but got %v", err)
+		}
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		readClipboard = func() ([]byte, error) { return nil, errors.New("no display") }
+
+		if _, err := ReadFromClipboard(); err == nil || !strings.Contains(err.Error(), "no display") {
+			t.Errorf("expected the backend error, but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// ExpandPath expands $VAR and ${VAR}, then a leading ~ or ~user, like a shell would. Paths from
+// the config file or a quoted flag never went through a shell, so they need it done here
+func ExpandPath(path string) string {
+	path = os.ExpandEnv(path)
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+
+	name, rest, _ := strings.Cut(path[1:], "/")
+
+	var home string
+	if name == "" {
+		userHome, err := os.UserHomeDir()
+		if err != nil {
+			return path
+		}
+		home = userHome
+	} else {
+		named, err := user.Lookup(name)
+		if err != nil {
+			return path
+		}
+		home = named.HomeDir
+	}
+
+	return filepath.Join(home, rest)
+}
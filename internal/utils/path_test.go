@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"os/user"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("NOTES_DIR", "/srv/notes")
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"~", home},
+		{"~/notes.txt", filepath.Join(home, "notes.txt")},
+		{"$HOME/notes.txt", filepath.Join(home, "notes.txt")},
+		{"${NOTES_DIR}/today.md", "/srv/notes/today.md"},
+		{"relative/notes.txt", "relative/notes.txt"},
+		{"/abs/~notes.txt", "/abs/~notes.txt"},
+		{"~no-such-user-here/notes.txt", "~no-such-user-here/notes.txt"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			if got := ExpandPath(test.path); got != test.expected {
+				t.Errorf("expected %q, but got %q", test.expected, got)
+			}
+		})
+	}
+
+	t.Run("~user", func(t *testing.T) {
+		current, err := user.Current()
+		if err != nil {
+			t.Skipf("no current user: %v", err)
+		}
+
+		expected := filepath.Join(current.HomeDir, "notes.txt")
+		if got := ExpandPath("~" + current.Username + "/notes.txt"); got != expected {
+			t.Errorf("expected %q, but got %q", expected, got)
+		}
+	})
+}
@@ -0,0 +1,17 @@
+package utils
+
+import "strings"
+
+// MaskSecret hides all but the last 4 characters of a secret so it can be shown or logged
+// while still being recognizable
+func MaskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	if len(secret) <= 4 {
+		return strings.Repeat("*", len(secret))
+	}
+
+	return "****" + secret[len(secret)-4:]
+}
@@ -9,6 +9,6 @@ import (
 func main() {
 	// PNOTE: runs whatever in the cmd root.go file
 	if err := cmd.Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 }
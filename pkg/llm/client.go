@@ -0,0 +1,692 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/flacial/llm/internal/log"
+)
+
+const (
+	// Root of the OpenAI-compatible API. Endpoints like /chat/completions are appended to it
+	OpenRouterBaseURL = "https://openrouter.ai/api/v1"
+	// Used when the caller doesn't bring its own client. The CLI overrides it via the
+	// timeout config key because some LLM responses are pretty lengthy
+	DefaultTimeout = (2 * time.Minute)
+	// Longest single SSE line a stream may carry. bufio.Scanner's own 64KB limit is too small for
+	// the big chunks some models send, reasoning ones especially
+	DefaultMaxStreamLineBytes = 1024 * 1024
+)
+
+// HTTPClient is what LLMClient sends requests with. *http.Client satisfies it
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// LLMClient talks to an OpenAI-compatible chat completions API, OpenRouter by default
+type LLMClient struct {
+	APIKey     string
+	HTTPClient HTTPClient
+	// API root such as https://api.openai.com/v1 or http://localhost:11434/v1
+	BaseURL string
+	// When set, reasoning deltas from streaming responses are written here ahead of the answer.
+	// Left nil, reasoning is dropped like before
+	ReasoningWriter io.Writer
+	// Sent as OpenRouter's HTTP-Referer and X-Title headers, which attribute usage to an app on
+	// its rankings. Empty values are left out
+	AppReferer string
+	AppTitle   string
+	// Zero means DefaultMaxStreamLineBytes
+	MaxStreamLineBytes int
+}
+
+// NewLLMClient returns a client for baseURL. A nil client gets an http.Client with DefaultTimeout,
+// and an empty baseURL means OpenRouterBaseURL
+func NewLLMClient(apiKey string, client HTTPClient, baseURL string) *LLMClient {
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+
+	if baseURL == "" {
+		baseURL = OpenRouterBaseURL
+	}
+
+	return &LLMClient{
+		APIKey:     apiKey,
+		HTTPClient: client,
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+	}
+}
+
+func (c *LLMClient) chatCompletionsURL() string {
+	return c.BaseURL + "/chat/completions"
+}
+
+func (c *LLMClient) setHeaders(req *http.Request) {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.APIKey)
+
+	if c.AppReferer != "" {
+		req.Header.Set("HTTP-Referer", c.AppReferer)
+	}
+
+	if c.AppTitle != "" {
+		req.Header.Set("X-Title", c.AppTitle)
+	}
+}
+
+// ChatCompletionRequest is the body of a chat completions request. Optional parameters are
+// pointers so that leaving them nil lets the model use its own default
+type ChatCompletionRequest struct {
+	Model string `json:"model"`
+	// Models OpenRouter falls back to, in order, when Model is unavailable
+	Models           []string                `json:"models,omitempty"`
+	Messages         []ChatCompletionMessage `json:"messages"`
+	Stream           bool                    `json:"stream"`
+	Temperature      *float64                `json:"temperature"`
+	MaxTokens        *int                    `json:"max_tokens,omitempty"`
+	TopP             *float64                `json:"top_p,omitempty"`
+	FrequencyPenalty *float64                `json:"frequency_penalty,omitempty"`
+	PresencePenalty  *float64                `json:"presence_penalty,omitempty"`
+	Stop             []string                `json:"stop,omitempty"`
+	Seed             *int                    `json:"seed,omitempty"`
+	// How many alternative answers to generate. Streaming only makes sense with one
+	N              *int                 `json:"n,omitempty"`
+	StreamOptions  *StreamOptions       `json:"stream_options,omitempty"`
+	ResponseFormat *ResponseFormat      `json:"response_format,omitempty"`
+	Provider       *ProviderPreferences `json:"provider,omitempty"`
+	// Functions the model may ask to call instead of, or besides, answering
+	Tools []Tool `json:"tools,omitempty"`
+	// OpenRouter plugins to run on the request, see WebPluginID
+	Plugins []Plugin `json:"plugins,omitempty"`
+}
+
+// ProviderPreferences controls which upstream providers OpenRouter routes the request to
+type ProviderPreferences struct {
+	// Provider names to try, in this order
+	Order []string `json:"order,omitempty" yaml:"order,omitempty"`
+	// Whether other providers may be used when the ones in Order are down
+	AllowFallbacks *bool `json:"allow_fallbacks,omitempty" yaml:"allow_fallbacks,omitempty"`
+	// Only use providers that support every parameter in the request
+	RequireParameters *bool `json:"require_parameters,omitempty" yaml:"require_parameters,omitempty"`
+	// "allow" or "deny" providers that may store or train on prompts
+	DataCollection string `json:"data_collection,omitempty" yaml:"data_collection,omitempty"`
+}
+
+// IsZero reports whether no preference is set, in which case the provider object shouldn't be sent
+func (p ProviderPreferences) IsZero() bool {
+	return len(p.Order) == 0 && p.AllowFallbacks == nil && p.RequireParameters == nil && p.DataCollection == ""
+}
+
+// ResponseFormat asks the model for JSON output, optionally matching a schema. Only some models
+// support it
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+type JSONSchema struct {
+	Name   string          `json:"name"`
+	Strict bool            `json:"strict"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+// NewJSONObjectFormat asks for any valid JSON object
+func NewJSONObjectFormat() *ResponseFormat {
+	return &ResponseFormat{Type: "json_object"}
+}
+
+// NewJSONSchemaFormat asks for JSON that strictly follows schema
+func NewJSONSchemaFormat(name string, schema json.RawMessage) *ResponseFormat {
+	return &ResponseFormat{
+		Type:       "json_schema",
+		JSONSchema: &JSONSchema{Name: name, Strict: true, Schema: schema},
+	}
+}
+
+// StreamOptions tunes a streaming request
+type StreamOptions struct {
+	// Asks for a final chunk carrying the token usage of the whole stream
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// Usage is the token count the API reports for a completion
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Validate checks the sampling parameters against the ranges OpenRouter accepts so
+// bad values fail fast instead of costing a round trip
+func (r ChatCompletionRequest) Validate() error {
+	if r.Temperature != nil && (*r.Temperature < 0 || *r.Temperature > 2) {
+		return fmt.Errorf("invalid temperature %v: must be between 0 and 2", *r.Temperature)
+	}
+
+	if r.N != nil && *r.N < 1 {
+		return fmt.Errorf("invalid n %d: must be at least 1", *r.N)
+	}
+
+	if r.TopP != nil && (*r.TopP < 0 || *r.TopP > 1) {
+		return fmt.Errorf("invalid top_p %v: must be between 0 and 1", *r.TopP)
+	}
+
+	if r.FrequencyPenalty != nil && (*r.FrequencyPenalty < -2 || *r.FrequencyPenalty > 2) {
+		return fmt.Errorf("invalid frequency_penalty %v: must be between -2 and 2", *r.FrequencyPenalty)
+	}
+
+	if r.PresencePenalty != nil && (*r.PresencePenalty < -2 || *r.PresencePenalty > 2) {
+		return fmt.Errorf("invalid presence_penalty %v: must be between -2 and 2", *r.PresencePenalty)
+	}
+
+	for _, tool := range r.Tools {
+		if tool.Function.Name == "" {
+			return errors.New("invalid tool: every function needs a name")
+		}
+	}
+
+	if r.Provider != nil && r.Provider.DataCollection != "" && r.Provider.DataCollection != "allow" && r.Provider.DataCollection != "deny" {
+		return fmt.Errorf("invalid data_collection %q: must be allow or deny", r.Provider.DataCollection)
+	}
+
+	return nil
+}
+
+// ChatCompletionMessage is one turn of the conversation, with a role of system, user, or assistant
+type ChatCompletionMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// When set, sent as the content instead of Content. Only needed for images since plain
+	// strings are what every provider understands
+	ContentParts []ContentPart `json:"-"`
+}
+
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+type ImageURL struct {
+	// Either a regular URL or a base64 data URI
+	URL string `json:"url"`
+}
+
+// NewTextPart wraps text for a message that also carries images
+func NewTextPart(text string) ContentPart {
+	return ContentPart{Type: "text", Text: text}
+}
+
+// NewImagePart references an image by URL, a data: URI included
+func NewImagePart(url string) ContentPart {
+	return ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: url}}
+}
+
+func (m ChatCompletionMessage) MarshalJSON() ([]byte, error) {
+	if len(m.ContentParts) == 0 {
+		type plainMessage ChatCompletionMessage
+		return json.Marshal(plainMessage(m))
+	}
+
+	return json.Marshal(struct {
+		Role    string        `json:"role"`
+		Content []ContentPart `json:"content"`
+	}{m.Role, m.ContentParts})
+}
+
+// UnmarshalJSON accepts both content forms. For parts, Content is filled with the text parts so
+// code that only cares about text keeps working
+func (m *ChatCompletionMessage) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	m.Role = raw.Role
+	m.Content = ""
+	m.ContentParts = nil
+
+	if len(raw.Content) == 0 || string(raw.Content) == "null" {
+		return nil
+	}
+
+	if raw.Content[0] != '[' {
+		return json.Unmarshal(raw.Content, &m.Content)
+	}
+
+	if err := json.Unmarshal(raw.Content, &m.ContentParts); err != nil {
+		return err
+	}
+
+	var texts []string
+	for _, part := range m.ContentParts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	m.Content = strings.Join(texts, "\n")
+
+	return nil
+}
+
+type ChatCompletionResponseChoices struct {
+	Index        int                           `json:"index"`
+	Message      ChatCompletionResponseMessage `json:"message"`
+	FinishReason string                        `json:"finish_reason"`
+}
+
+type ChatCompletionResponseMessage struct {
+	Role         string       `json:"role"`
+	Content      string       `json:"content"`
+	FinishReason string       `json:"finish_reason"`
+	Reasoning    string       `json:"reasoning,omitempty"`
+	ToolCalls    []ToolCall   `json:"tool_calls,omitempty"`
+	Annotations  []Annotation `json:"annotations,omitempty"`
+}
+
+// ChatCompletionResponse is the answer to a non-streaming request
+type ChatCompletionResponse struct {
+	Id      string                          `json:"id"`
+	Choices []ChatCompletionResponseChoices `json:"choices"`
+	Usage   *Usage                          `json:"usage,omitempty"`
+	// Source URLs some providers, like Perplexity, list here instead of as annotations
+	Citations []string `json:"citations,omitempty"`
+}
+
+type ChatCompletionStreamResponseMessageDelta struct {
+	Role        string       `json:"role"`
+	Content     string       `json:"content"`
+	Reasoning   string       `json:"reasoning,omitempty"`
+	Annotations []Annotation `json:"annotations,omitempty"`
+}
+
+type ChatCompletionStreamResponseChoices struct {
+	Index        int                                      `json:"index"`
+	Delta        ChatCompletionStreamResponseMessageDelta `json:"delta"`
+	FinishReason string                                   `json:"finish_reason"`
+}
+
+type ChatCompletionStreamResponse struct {
+	Id      string                                `json:"id"`
+	Created int64                                 `json:"created"`
+	Model   string                                `json:"model"`
+	Choices []ChatCompletionStreamResponseChoices `json:"choices"`
+	Usage   *Usage                                `json:"usage,omitempty"`
+	// Repeated in every chunk by the providers that send it
+	Citations []string `json:"citations,omitempty"`
+}
+
+// StreamEventType tells what a StreamEvent carries
+type StreamEventType int
+
+const (
+	// A piece of the answer, in Content
+	StreamEventContent StreamEventType = iota
+	// A piece of the model's reasoning, in Content
+	StreamEventReasoning
+	// The model is done, FinishReason says why
+	StreamEventFinish
+	// Token usage of the whole request, in Usage
+	StreamEventUsage
+	// The stream broke off, Err says why. It's always the last event
+	StreamEventError
+	// Sources the answer cites, in Annotations
+	StreamEventAnnotations
+)
+
+// StreamEvent is one piece of a streamed answer, as sent by StreamChatCompletion
+type StreamEvent struct {
+	Type         StreamEventType
+	Content      string
+	FinishReason string
+	Usage        *Usage
+	Annotations  []Annotation
+	Err          error
+}
+
+// StreamingChatCompletion is what's left once a stream ends: the accumulated answer plus
+// anything the final chunks carried
+type StreamingChatCompletion struct {
+	Content string
+	Usage   *Usage
+	// Why the model stopped, e.g. "stop", "length", or "content_filter". Empty if the stream
+	// ended without saying
+	FinishReason string
+	// Sources cited along the way, from every chunk that carried some, so the same source can
+	// appear more than once
+	Annotations []Annotation
+}
+
+// GetChatCompletion sends a non-streaming request and waits for the whole answer. API errors
+// come back as *APIError
+func (c *LLMClient) GetChatCompletion(ctx context.Context, reqBody ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	log.Logger.Debug().Interface("request_body", reqBody).Msg("Sending chat completion request.")
+	start := time.Now()
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		log.Logger.Error().Err(err).Msg("Error encoding completion JSON.")
+		return nil, fmt.Errorf("error encoding completion JSON: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.chatCompletionsURL(), bytes.NewBuffer(jsonData))
+	if err != nil {
+		log.Logger.Error().Err(err).Msg("Failed to create HTTP request.")
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	c.setHeaders(httpReq)
+
+	resp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Logger.Info().Msg("HTTP request cancelled by context.")
+			return nil, context.Canceled
+		}
+		log.Logger.Error().Err(err).Msg("Error sending request to LLM API.")
+		return nil, fmt.Errorf("error sending request to LLM API: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Logger.Error().Err(err).Msg("Failed to close response body.")
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		apiErr := newAPIError(resp.StatusCode, bodyBytes)
+		log.Logger.Debug().Bytes("response_body", bodyBytes).Msg("LLM API error body.")
+		log.Logger.Error().
+			Int("status_code", resp.StatusCode).
+			Str("message", apiErr.Message).
+			Str("code", apiErr.Code).
+			Msg("LLM API returned non-OK status.")
+		return nil, fmt.Errorf("chat completion failed: %w", apiErr)
+	}
+
+	var completionResp ChatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completionResp); err != nil {
+		log.Logger.Error().Err(err).Msg("Error decoding LLM API response.")
+		return nil, fmt.Errorf("error decoding LLM API response: %w", err)
+	}
+
+	log.Logger.Debug().Msg("Successfully received chat completion response.")
+	c.logElapsed(ctx, start, c.httpTimeout())
+	return &completionResp, nil
+}
+
+// logElapsed records how long a request took and warns when it used up most of its time budget,
+// which is the context deadline if there's one, otherwise fallback. A zero budget only logs
+func (c *LLMClient) logElapsed(ctx context.Context, start time.Time, fallback time.Duration) {
+	elapsed := time.Since(start)
+
+	budget := fallback
+	if deadline, ok := ctx.Deadline(); ok {
+		budget = deadline.Sub(start)
+	}
+
+	log.Logger.Debug().Dur("elapsed", elapsed).Dur("budget", budget).Msg("Request completed.")
+
+	if budget > 0 && elapsed > budget*8/10 {
+		log.Logger.Warn().
+			Dur("elapsed", elapsed.Round(time.Millisecond)).
+			Dur("timeout", budget).
+			Msg("Request took over 80% of its timeout. Consider raising it if answers get cut off.")
+	}
+}
+
+// httpTimeout is the overall timeout of the HTTP client, when it has one
+func (c *LLMClient) httpTimeout() time.Duration {
+	if client, ok := c.HTTPClient.(*http.Client); ok {
+		return client.Timeout
+	}
+
+	return 0
+}
+
+// GetStreamingChatCompletion streams the answer to outputWriter (and reasoning to ReasoningWriter,
+// when set) as it arrives. It's the CLI's view of StreamChatCompletion. On failure, including
+// cancellation, whatever arrived so far is returned along with the error
+func (c *LLMClient) GetStreamingChatCompletion(ctx context.Context, reqBody ChatCompletionRequest, outputWriter io.Writer) (*StreamingChatCompletion, error) {
+	events, err := c.StreamChatCompletion(ctx, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var fullContent strings.Builder
+	var usage *Usage
+	var streamErr error
+	var finishReason string
+	var annotations []Annotation
+	reasoningStarted := false
+
+	for event := range events {
+		switch event.Type {
+		case StreamEventReasoning:
+			if c.ReasoningWriter == nil {
+				continue
+			}
+			if !reasoningStarted {
+				fmt.Fprint(c.ReasoningWriter, "Reasoning:\n")
+				reasoningStarted = true
+			}
+			fmt.Fprintf(c.ReasoningWriter, "%s", event.Content)
+		case StreamEventContent:
+			// Keep the answer visually apart from the reasoning that came before it
+			if reasoningStarted && fullContent.Len() == 0 {
+				fmt.Fprint(outputWriter, "\n\n")
+			}
+
+			fmt.Fprintf(outputWriter, "%s", event.Content)
+			fullContent.WriteString(event.Content)
+		case StreamEventFinish:
+			finishReason = event.FinishReason
+			fmt.Fprintf(outputWriter, "\n\n")
+		case StreamEventUsage:
+			usage = event.Usage
+		case StreamEventAnnotations:
+			annotations = append(annotations, event.Annotations...)
+		case StreamEventError:
+			streamErr = event.Err
+		}
+	}
+
+	// Once ctx is done the stream may stop without sending its error, so it's taken from ctx
+	if streamErr == nil && finishReason == "" {
+		streamErr = ctx.Err()
+	}
+
+	// A stream cut off mid-line, by Ctrl-C or a deadline, would otherwise leave the shell prompt
+	// stuck to the end of the partial answer
+	stopped := errors.Is(streamErr, context.Canceled) || errors.Is(streamErr, context.DeadlineExceeded)
+	if stopped && (fullContent.Len() > 0 || reasoningStarted) {
+		fmt.Fprint(outputWriter, "\n")
+	}
+
+	return &StreamingChatCompletion{Content: fullContent.String(), Usage: usage, FinishReason: finishReason, Annotations: annotations}, streamErr
+}
+
+// StreamChatCompletion sends a streaming request and returns the answer as typed events. Errors
+// before the stream starts (bad status, network) are returned directly, later ones arrive as a
+// StreamEventError. The channel is closed when the stream ends and must be drained until then, or
+// until ctx is canceled, which also closes it without necessarily sending what was left
+func (c *LLMClient) StreamChatCompletion(ctx context.Context, reqBody ChatCompletionRequest) (<-chan StreamEvent, error) {
+	reqBody.Stream = true
+	log.Logger.Debug().Interface("request_body", reqBody).Msg("Sending streaming chat completion request.")
+	start := time.Now()
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		log.Logger.Error().Err(err).Msg("Error encoding streaming completion JSON.")
+		return nil, fmt.Errorf("error encoding completion JSON: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.chatCompletionsURL(), bytes.NewBuffer(jsonData))
+	if err != nil {
+		log.Logger.Error().Err(err).Msg("Failed to create HTTP request for streaming.")
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	c.setHeaders(httpReq)
+
+	resp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Logger.Info().Msg("Streaming HTTP request cancelled by context.")
+			return nil, context.Canceled
+		}
+		log.Logger.Error().Err(err).Msg("Error sending streaming request to LLM API.")
+		return nil, fmt.Errorf("error sending request to LLM API: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		apiErr := newAPIError(resp.StatusCode, bodyBytes)
+		log.Logger.Debug().Bytes("response_body", bodyBytes).Msg("LLM API error body.")
+		log.Logger.Error().
+			Int("status_code", resp.StatusCode).
+			Str("message", apiErr.Message).
+			Str("code", apiErr.Code).
+			Msg("LLM API returned non-OK status for streaming.")
+		return nil, fmt.Errorf("chat completion failed: %w", apiErr)
+	}
+
+	events := make(chan StreamEvent)
+	go func() {
+		defer close(events)
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				log.Logger.Error().Err(err).Msg("Failed to close streaming response body.")
+			}
+		}()
+
+		c.readStream(ctx, resp.Body, events)
+		// The HTTP client's timeout can't cover a stream that's already flowing, so only a
+		// context deadline counts here
+		c.logElapsed(ctx, start, 0)
+	}()
+
+	return events, nil
+}
+
+// readStream parses the SSE body into events until [DONE] or the body ends. It gives up as soon as
+// ctx is done, so a reader that stopped listening doesn't leave it blocked on a send
+func (c *LLMClient) readStream(ctx context.Context, body io.Reader, events chan<- StreamEvent) {
+	send := func(event StreamEvent) bool {
+		select {
+		case events <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var malformedChunkErr error
+	receivedContent := false
+
+	maxLineBytes := c.MaxStreamLineBytes
+	if maxLineBytes <= 0 {
+		maxLineBytes = DefaultMaxStreamLineBytes
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+	for scanner.Scan() {
+		line := scanner.Text()
+		log.Logger.Trace().Str("raw_line", line).Msg("Received stream line.")
+
+		// Blank lines separate events and lines starting with ":" are comments, which OpenRouter
+		// sends as keep-alives while the model is still thinking
+		data, isData := strings.CutPrefix(line, "data:")
+		if !isData {
+			continue
+		}
+
+		data = strings.TrimPrefix(data, " ")
+		if data == "[DONE]" {
+			log.Logger.Debug().Msg("Streaming complete (DONE signal received).")
+			break
+		}
+
+		// One bad chunk shouldn't throw away the rest of the answer
+		var chunk ChatCompletionStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			log.Logger.Debug().Err(err).Str("data", data).Msg("Skipping malformed streaming chunk.")
+			malformedChunkErr = err
+			continue
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Reasoning != "" {
+				if !send(StreamEvent{Type: StreamEventReasoning, Content: choice.Delta.Reasoning}) {
+					return
+				}
+			}
+
+			if choice.Delta.Content != "" {
+				receivedContent = true
+				if !send(StreamEvent{Type: StreamEventContent, Content: choice.Delta.Content}) {
+					return
+				}
+			}
+
+			if len(choice.Delta.Annotations) > 0 {
+				if !send(StreamEvent{Type: StreamEventAnnotations, Annotations: choice.Delta.Annotations}) {
+					return
+				}
+			}
+
+			if choice.FinishReason != "" {
+				log.Logger.Debug().Str("finish_reason", choice.FinishReason).Msg("Stream finished.")
+				if !send(StreamEvent{Type: StreamEventFinish, FinishReason: choice.FinishReason}) {
+					return
+				}
+			}
+		}
+
+		if len(chunk.Citations) > 0 {
+			if !send(StreamEvent{Type: StreamEventAnnotations, Annotations: CitationAnnotations(chunk.Citations)}) {
+				return
+			}
+		}
+
+		if chunk.Usage != nil {
+			if !send(StreamEvent{Type: StreamEventUsage, Usage: chunk.Usage}) {
+				return
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Logger.Info().Msg("Streaming response read cancelled by context.")
+			send(StreamEvent{Type: StreamEventError, Err: context.Canceled})
+			return
+		}
+		log.Logger.Error().Err(err).Msg("Error reading streaming response.")
+		send(StreamEvent{Type: StreamEventError, Err: fmt.Errorf("error reading streaming response: %w", err)})
+		return
+	}
+
+	if !receivedContent && malformedChunkErr != nil {
+		log.Logger.Error().Err(malformedChunkErr).Msg("No usable chunks in the streaming response.")
+		send(StreamEvent{Type: StreamEventError, Err: fmt.Errorf("error unmarshalling streaming chunk: %w", malformedChunkErr)})
+		return
+	}
+
+	log.Logger.Debug().Msg("Streaming session completed successfully.")
+}
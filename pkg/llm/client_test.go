@@ -0,0 +1,251 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// readerHTTPClient answers every request with body, which can fail partway like a real stream
+type readerHTTPClient struct {
+	body io.Reader
+}
+
+func (c readerHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(c.body), Header: make(http.Header)}, nil
+}
+
+// bodyHTTPClient answers every request with body as is, so tests can watch it being closed
+type bodyHTTPClient struct {
+	body io.ReadCloser
+}
+
+func (c bodyHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: c.body, Header: make(http.Header)}, nil
+}
+
+// closeNotifyingBody reports when the client closes the response body
+type closeNotifyingBody struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func (b closeNotifyingBody) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestGetStreamingChatCompletion(t *testing.T) {
+	t.Run("keep-alive comments and malformed chunks are skipped", func(t *testing.T) {
+		body := strings.Join([]string{
+			": OPENROUTER PROCESSING",
+			"",
+			`data: {"choices": [{"index": 0, "delta": {"content": "Hello"}}]}`,
+			"",
+			": OPENROUTER PROCESSING",
+			`data: {"choices": [{"index": 0, "delta": {"cont`,
+			"",
+			`data:{"choices": [{"index": 0, "delta": {"content": " world"}}]}`,
+			"",
+			"data: [DONE]",
+			"",
+		}, "\n")
+
+		client := NewLLMClient("key", mockHTTPClient{200, body}, "")
+
+		var out strings.Builder
+		completion, err := client.GetStreamingChatCompletion(context.Background(), ChatCompletionRequest{}, &out)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if completion.Content != "Hello world" {
+			t.Errorf("expected %q, but got %q", "Hello world", completion.Content)
+		}
+
+		if out.String() != "Hello world" {
+			t.Errorf("expected output %q, but got %q", "Hello world", out.String())
+		}
+	})
+
+	t.Run("lines longer than 64KB", func(t *testing.T) {
+		longContent := strings.Repeat("a", 100*1024)
+		body := `data: {"choices": [{"index": 0, "delta": {"content": "` + longContent + `"}}]}` + "\n\ndata: [DONE]\n\n"
+		client := NewLLMClient("key", mockHTTPClient{200, body}, "")
+
+		completion, err := client.GetStreamingChatCompletion(context.Background(), ChatCompletionRequest{}, &strings.Builder{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if completion.Content != longContent {
+			t.Errorf("expected %d bytes of content, but got %d", len(longContent), len(completion.Content))
+		}
+	})
+
+	t.Run("annotations are collected", func(t *testing.T) {
+		body := strings.Join([]string{
+			`data: {"choices": [{"index": 0, "delta": {"content": "Cited"}}]}`,
+			`data: {"choices": [{"index": 0, "delta": {"annotations": [{"type": "url_citation", "url_citation": {"url": "https://example.com/a"}}]}}]}`,
+			`data: {"choices": [{"index": 0, "delta": {"annotations": [{"type": "url_citation", "url_citation": {"url": "https://example.com/b"}}]}}]}`,
+			`data: {"citations": ["https://example.com/c"], "choices": [{"index": 0, "delta": {}, "finish_reason": "stop"}]}`,
+			"data: [DONE]",
+		}, "\n\n")
+		client := NewLLMClient("key", mockHTTPClient{200, body}, "")
+
+		completion, err := client.GetStreamingChatCompletion(context.Background(), ChatCompletionRequest{}, &strings.Builder{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(completion.Annotations) != 3 || completion.Annotations[1].URLCitation.URL != "https://example.com/b" {
+			t.Fatalf("expected all three annotations, but got %+v", completion.Annotations)
+		}
+		if citation := completion.Annotations[2]; citation.Type != "url_citation" || citation.URLCitation.URL != "https://example.com/c" {
+			t.Errorf("expected the bare citation as a url_citation, but got %+v", citation)
+		}
+	})
+
+	t.Run("cancelled stream ends its line", func(t *testing.T) {
+		chunk := `data: {"choices": [{"index": 0, "delta": {"content": "Once upon a"}}]}` + "\n\n"
+		client := NewLLMClient("key", readerHTTPClient{io.MultiReader(strings.NewReader(chunk), iotest.ErrReader(context.Canceled))}, "")
+
+		var out strings.Builder
+		completion, err := client.GetStreamingChatCompletion(context.Background(), ChatCompletionRequest{}, &out)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, but got %v", err)
+		}
+
+		if out.String() != "Once upon a\n" {
+			t.Errorf("expected the partial answer and a newline, but got %q", out.String())
+		}
+		if completion.Content != "Once upon a" {
+			t.Errorf("expected the newline to stay out of the content, but got %q", completion.Content)
+		}
+	})
+
+	t.Run("only malformed chunks", func(t *testing.T) {
+		body := "data: {not json}\n\ndata: [DONE]\n\n"
+		client := NewLLMClient("key", mockHTTPClient{200, body}, "")
+
+		if _, err := client.GetStreamingChatCompletion(context.Background(), ChatCompletionRequest{}, &strings.Builder{}); err == nil {
+			t.Error("expected an error when no chunk could be parsed")
+		}
+	})
+}
+
+func TestStreamChatCompletion(t *testing.T) {
+	body := strings.Join([]string{
+		`data: {"choices": [{"index": 0, "delta": {"reasoning": "Thinking"}}]}`,
+		`data: {"choices": [{"index": 0, "delta": {"content": "Hi"}}]}`,
+		`data: {"choices": [{"index": 0, "delta": {}, "finish_reason": "stop"}]}`,
+		`data: {"choices": [], "usage": {"prompt_tokens": 3, "completion_tokens": 1, "total_tokens": 4}}`,
+		"data: [DONE]",
+	}, "\n\n")
+
+	client := NewLLMClient("key", mockHTTPClient{200, body}, "")
+
+	events, err := client.StreamChatCompletion(context.Background(), ChatCompletionRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var received []StreamEvent
+	for event := range events {
+		received = append(received, event)
+	}
+
+	expectedTypes := []StreamEventType{StreamEventReasoning, StreamEventContent, StreamEventFinish, StreamEventUsage}
+	if len(received) != len(expectedTypes) {
+		t.Fatalf("expected %d events, but got %+v", len(expectedTypes), received)
+	}
+
+	for i, expectedType := range expectedTypes {
+		if received[i].Type != expectedType {
+			t.Errorf("expected event %d to be of type %d, but got %d", i, expectedType, received[i].Type)
+		}
+	}
+
+	if received[1].Content != "Hi" || received[2].FinishReason != "stop" || received[3].Usage.TotalTokens != 4 {
+		t.Errorf("unexpected event payloads: %+v", received)
+	}
+}
+
+func TestStreamChatCompletionCanceled(t *testing.T) {
+	chunk := `data: {"choices": [{"index": 0, "delta": {"content": "Hi"}}]}` + "\n\n"
+	body := closeNotifyingBody{Reader: strings.NewReader(strings.Repeat(chunk, 10)), closed: make(chan struct{})}
+	client := NewLLMClient("key", bodyHTTPClient{body}, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := client.StreamChatCompletion(ctx, ChatCompletionRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Read one event, then stop listening the way a consumer that gave up would
+	<-events
+	cancel()
+
+	select {
+	case <-body.closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the stream to stop and close the body once the context was canceled")
+	}
+}
+
+func TestStreamingFinishReason(t *testing.T) {
+	for _, finishReason := range []string{"stop", "length", "content_filter"} {
+		t.Run(finishReason, func(t *testing.T) {
+			body := `data: {"choices": [{"index": 0, "delta": {"content": "Hi"}}]}` + "\n\n" +
+				`data: {"choices": [{"index": 0, "delta": {}, "finish_reason": "` + finishReason + `"}]}` + "\n\ndata: [DONE]\n\n"
+			client := NewLLMClient("key", mockHTTPClient{200, body}, "")
+
+			completion, err := client.GetStreamingChatCompletion(context.Background(), ChatCompletionRequest{}, &strings.Builder{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if completion.FinishReason != finishReason {
+				t.Errorf("expected finish reason %q, but got %q", finishReason, completion.FinishReason)
+			}
+		})
+	}
+}
+
+func TestChatCompletionRequestValidate(t *testing.T) {
+	float := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name    string
+		request ChatCompletionRequest
+		wantErr string
+	}{
+		{name: "nothing set"},
+		{name: "in range", request: ChatCompletionRequest{Temperature: float(2), TopP: float(0.9), FrequencyPenalty: float(-2), PresencePenalty: float(2)}},
+		{name: "temperature too high", request: ChatCompletionRequest{Temperature: float(2.1)}, wantErr: "invalid temperature"},
+		{name: "top_p too high", request: ChatCompletionRequest{TopP: float(1.5)}, wantErr: "invalid top_p"},
+		{name: "top_p negative", request: ChatCompletionRequest{TopP: float(-0.1)}, wantErr: "invalid top_p"},
+		{name: "frequency_penalty too low", request: ChatCompletionRequest{FrequencyPenalty: float(-2.5)}, wantErr: "invalid frequency_penalty"},
+		{name: "presence_penalty too high", request: ChatCompletionRequest{PresencePenalty: float(3)}, wantErr: "invalid presence_penalty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.request.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("expected no error, but got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, but got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
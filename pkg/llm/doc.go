@@ -0,0 +1,12 @@
+// Package llm is a client for OpenAI-compatible chat completion APIs, OpenRouter by default. It's
+// what the llm CLI uses, and can be imported on its own:
+//
+//	client := llm.NewLLMClient(os.Getenv("OPENROUTER_API_KEY"), nil, "")
+//	completion, err := client.GetChatCompletion(ctx, llm.ChatCompletionRequest{
+//		Model:    "google/gemini-2.5-flash",
+//		Messages: []llm.ChatCompletionMessage{{Role: "user", Content: "Hello!"}},
+//	})
+//
+// Streaming answers are available as events through StreamChatCompletion, or written to an
+// io.Writer as they arrive with GetStreamingChatCompletion
+package llm
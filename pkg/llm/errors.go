@@ -0,0 +1,52 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// APIError is returned (wrapped) when the API answers with a non-OK status. Message and Code
+// come from the error JSON OpenRouter and OpenAI-compatible APIs send, when there is one
+type APIError struct {
+	StatusCode int
+	Body       string
+	Message    string
+	Code       string
+}
+
+// Error shows the API's own explanation when it gave one, and the raw body otherwise
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("LLM API error (status %d): %s", e.StatusCode, e.Message)
+	}
+
+	return fmt.Sprintf("LLM API returned non-OK status: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// newAPIError builds an APIError from a failed response, picking out the message and code from
+// a {"error": {"message": ..., "code": ...}} body
+func newAPIError(statusCode int, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: statusCode,
+		Body:       string(body),
+	}
+
+	var errorBody struct {
+		Error struct {
+			Message string          `json:"message"`
+			Code    json.RawMessage `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errorBody); err != nil {
+		return apiErr
+	}
+
+	apiErr.Message = errorBody.Error.Message
+	// OpenRouter sends numeric codes while OpenAI sends strings like "invalid_api_key"
+	if code := strings.Trim(string(errorBody.Error.Code), `"`); code != "null" {
+		apiErr.Code = code
+	}
+
+	return apiErr
+}
@@ -0,0 +1,84 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+)
+
+type mockHTTPClient struct {
+	statusCode int
+	body       string
+}
+
+func (c mockHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: c.statusCode,
+		Body:       io.NopCloser(bytes.NewBufferString(c.body)),
+		Header:     make(http.Header),
+	}, nil
+}
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		name            string
+		statusCode      int
+		body            string
+		expectedMessage string
+		expectedCode    string
+	}{
+		{"openrouter", http.StatusUnauthorized, `{"error": {"message": "No auth credentials found", "code": 401}}`, "No auth credentials found", "401"},
+		{"openai", http.StatusTooManyRequests, `{"error": {"message": "Rate limit reached", "code": "rate_limit_exceeded"}}`, "Rate limit reached", "rate_limit_exceeded"},
+		{"not json", http.StatusBadGateway, `<html>Bad Gateway</html>`, "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := NewLLMClient("key", mockHTTPClient{test.statusCode, test.body}, "")
+			request := ChatCompletionRequest{Model: "test/model"}
+
+			_, blockingErr := client.GetChatCompletion(context.Background(), request)
+			_, streamingErr := client.GetStreamingChatCompletion(context.Background(), request, io.Discard)
+
+			for _, err := range []error{blockingErr, streamingErr} {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) {
+					t.Fatalf("expected an APIError, but got %v", err)
+				}
+
+				if apiErr.StatusCode != test.statusCode {
+					t.Errorf("expected status %d, but got %d", test.statusCode, apiErr.StatusCode)
+				}
+
+				if apiErr.Message != test.expectedMessage {
+					t.Errorf("expected message %q, but got %q", test.expectedMessage, apiErr.Message)
+				}
+
+				if apiErr.Code != test.expectedCode {
+					t.Errorf("expected code %q, but got %q", test.expectedCode, apiErr.Code)
+				}
+
+				if apiErr.Body != test.body {
+					t.Errorf("expected body %q, but got %q", test.body, apiErr.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIErrorMessage(t *testing.T) {
+	parsed := newAPIError(http.StatusPaymentRequired, []byte(`{"error": {"message": "Insufficient credits", "code": 402}}`))
+	expected := "LLM API error (status 402): Insufficient credits"
+	if parsed.Error() != expected {
+		t.Errorf("expected %q, but got %q", expected, parsed.Error())
+	}
+
+	raw := newAPIError(http.StatusBadGateway, []byte("Bad Gateway"))
+	expected = "LLM API returned non-OK status: 502, body: Bad Gateway"
+	if raw.Error() != expected {
+		t.Errorf("expected %q, but got %q", expected, raw.Error())
+	}
+}
@@ -0,0 +1,31 @@
+package llm
+
+import "encoding/json"
+
+// Tool is a function the model may ask to have called. Only "function" tools exist so far
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction describes a function to the model, with its arguments as a JSON schema
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// ToolCall is the model asking for a tool to be called. Running it and sending back the result
+// is up to the caller
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction names the function to call. Arguments is the JSON object the model wrote,
+// still encoded as a string, and isn't guaranteed to be valid
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
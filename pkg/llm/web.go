@@ -0,0 +1,40 @@
+package llm
+
+const (
+	// WebPluginID is OpenRouter's web search plugin, which adds search results to the prompt
+	WebPluginID = "web"
+	// OnlineModelSuffix turns a model ID into its variant with web search built in
+	OnlineModelSuffix = ":online"
+)
+
+// Plugin turns on one of OpenRouter's request plugins, like web search
+type Plugin struct {
+	ID string `json:"id"`
+}
+
+// Annotation is extra information attached to an answer. Web search adds a url_citation for
+// every source the answer draws on
+type Annotation struct {
+	Type        string       `json:"type"`
+	URLCitation *URLCitation `json:"url_citation,omitempty"`
+}
+
+// URLCitation is a web page an answer cites. The indexes mark the cited span of the answer
+type URLCitation struct {
+	URL        string `json:"url"`
+	Title      string `json:"title,omitempty"`
+	Content    string `json:"content,omitempty"`
+	StartIndex int    `json:"start_index,omitempty"`
+	EndIndex   int    `json:"end_index,omitempty"`
+}
+
+// CitationAnnotations turns a bare list of cited URLs into url_citation annotations, so both ways
+// of citing sources can be handled alike
+func CitationAnnotations(urls []string) []Annotation {
+	annotations := make([]Annotation, 0, len(urls))
+	for _, url := range urls {
+		annotations = append(annotations, Annotation{Type: "url_citation", URLCitation: &URLCitation{URL: url}})
+	}
+
+	return annotations
+}